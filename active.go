@@ -0,0 +1,176 @@
+package stats
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActiveTimers tracks in-flight Stoppers so operations are visible before
+// they finish. Report bumps, for every key and set of tags in flight:
+//
+//	key.in_flight  the number of operations in flight
+//	key.oldest     the age of the oldest operation in flight, in milliseconds
+//	key.running    the age of every operation in flight, in milliseconds, if
+//	               RunningHistogram is set
+//
+// with the tags the operations were started with. Stuck operations then show
+// up as a growing key.oldest instead of not showing up at all.
+type ActiveTimers struct {
+	// Client receives the Stopper results and the reports.
+	Client Client
+
+	// RunningHistogram enables a histogram sample for every in-flight
+	// operation in each report.
+	RunningHistogram bool
+
+	mu     sync.Mutex
+	active map[string]*activeSeries
+
+	// idle are the series which finished since the last report, which
+	// report zero once before being forgotten.
+	idle map[string]*activeSeries
+}
+
+type activeSeries struct {
+	key      string
+	tags     []string
+	stoppers map[*Stopper]struct{}
+}
+
+// activeID identifies the series of the key and tags.
+func activeID(key string, tags []string) string {
+	return key + "\x00" + strings.Join(sortedTags(tags), ",")
+}
+
+// NewActiveTimers creates ActiveTimers reporting to the Client.
+func NewActiveTimers(c Client) *ActiveTimers {
+	return &ActiveTimers{Client: c}
+}
+
+// Start starts a Stopper which is tracked until End'ed.
+func (a *ActiveTimers) Start(key string, tags ...string) *Stopper {
+	s := &Stopper{
+		Key:    key,
+		Start:  time.Now(),
+		Client: a.Client,
+		Tags:   tags,
+		active: a,
+	}
+	id := activeID(key, tags)
+	a.mu.Lock()
+	if a.active == nil {
+		a.active = map[string]*activeSeries{}
+	}
+	series, ok := a.active[id]
+	if !ok {
+		series = &activeSeries{
+			key:      key,
+			tags:     tags,
+			stoppers: map[*Stopper]struct{}{},
+		}
+		a.active[id] = series
+	}
+	series.stoppers[s] = struct{}{}
+	delete(a.idle, id)
+	a.mu.Unlock()
+	return s
+}
+
+// BumpTime starts a tracked Stopper. It allows ActiveTimers to be used where
+// only a BumpTime method is needed.
+func (a *ActiveTimers) BumpTime(key string, tags ...string) interface {
+	End()
+} {
+	return a.Start(key, tags...)
+}
+
+func (a *ActiveTimers) remove(s *Stopper) {
+	id := activeID(s.Key, s.Tags)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	series, ok := a.active[id]
+	if !ok {
+		return
+	}
+	delete(series.stoppers, s)
+	if len(series.stoppers) == 0 {
+		delete(a.active, id)
+		if a.idle == nil {
+			a.idle = map[string]*activeSeries{}
+		}
+		a.idle[id] = series
+	}
+}
+
+// InFlight returns the number of operations in flight for the key and tags,
+// and the start time of the oldest one.
+func (a *ActiveTimers) InFlight(key string, tags ...string) (int, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	series, ok := a.active[activeID(key, tags)]
+	if !ok {
+		return 0, time.Time{}
+	}
+	var oldest time.Time
+	for s := range series.stoppers {
+		if oldest.IsZero() || s.Start.Before(oldest) {
+			oldest = s.Start
+		}
+	}
+	return len(series.stoppers), oldest
+}
+
+// Report bumps the in-flight stats for every key and set of tags in flight.
+// Those which finished since the last report report zero once, so gauges
+// don't stay at their last value.
+func (a *ActiveTimers) Report() {
+	type seriesReport struct {
+		key  string
+		tags []string
+		ages []float64
+	}
+	now := time.Now()
+	var reports []seriesReport
+	a.mu.Lock()
+	for _, series := range a.active {
+		r := seriesReport{key: series.key, tags: series.tags}
+		for s := range series.stoppers {
+			r.ages = append(r.ages, now.Sub(s.Start).Seconds()*1000.0)
+		}
+		reports = append(reports, r)
+	}
+	for _, series := range a.idle {
+		reports = append(reports, seriesReport{key: series.key, tags: series.tags})
+	}
+	a.idle = nil
+	a.mu.Unlock()
+
+	for _, r := range reports {
+		var oldest float64
+		for _, age := range r.ages {
+			if age > oldest {
+				oldest = age
+			}
+			if a.RunningHistogram {
+				BumpHistogram(a.Client, r.key+".running", age, r.tags...)
+			}
+		}
+		BumpAvg(a.Client, r.key+".in_flight", float64(len(r.ages)), r.tags...)
+		BumpAvg(a.Client, r.key+".oldest", oldest, r.tags...)
+	}
+}
+
+// Run calls Report every interval until stop is closed.
+func (a *ActiveTimers) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.Report()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package stats_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+type activeRecorder struct {
+	mu     sync.Mutex
+	values map[string][]float64
+}
+
+// record records the values by key, followed by the tags if there are any.
+func (r *activeRecorder) record(key string, val float64, tags ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = map[string][]float64{}
+	}
+	if len(tags) > 0 {
+		key += "{" + strings.Join(tags, ",") + "}"
+	}
+	r.values[key] = append(r.values[key], val)
+}
+
+func (r *activeRecorder) client() stats.Client {
+	return &stats.HookClient{
+		BumpAvgHook:       r.record,
+		BumpSumHook:       r.record,
+		BumpHistogramHook: r.record,
+	}
+}
+
+func TestActiveTimers(t *testing.T) {
+	t.Parallel()
+	var r activeRecorder
+	a := stats.NewActiveTimers(r.client())
+	a.RunningHistogram = true
+
+	first := a.Start("job")
+	a.Start("job")
+	a.BumpTime("job", "tag:1")
+	n, oldest := a.InFlight("job")
+	ensure.DeepEqual(t, n, 2)
+	ensure.DeepEqual(t, oldest, first.Start)
+	n, _ = a.InFlight("job", "tag:1")
+	ensure.DeepEqual(t, n, 1)
+
+	a.Report()
+	ensure.DeepEqual(t, r.values["job.in_flight"], []float64{2})
+	ensure.DeepEqual(t, r.values["job.in_flight{tag:1}"], []float64{1})
+	ensure.DeepEqual(t, len(r.values["job.oldest"]), 1)
+	ensure.DeepEqual(t, len(r.values["job.oldest{tag:1}"]), 1)
+	ensure.DeepEqual(t, len(r.values["job.running"]), 2)
+	ensure.DeepEqual(t, len(r.values["job.running{tag:1}"]), 1)
+
+	first.End()
+	first.End()
+	n, _ = a.InFlight("job")
+	ensure.DeepEqual(t, n, 1)
+	ensure.DeepEqual(t, len(r.values["job"]), 1)
+	ensure.DeepEqual(t, len(r.values["job.total"]), 1)
+
+	a.Report()
+	ensure.DeepEqual(t, r.values["job.in_flight"], []float64{2, 1})
+}
+
+// Ensure series which finish report zero once and are then forgotten.
+func TestActiveTimersIdle(t *testing.T) {
+	t.Parallel()
+	var r activeRecorder
+	a := &stats.ActiveTimers{Client: r.client()}
+	a.Start("job", "tag:1").End()
+	a.Report()
+	ensure.DeepEqual(t, r.values["job.in_flight{tag:1}"], []float64{0})
+	ensure.DeepEqual(t, r.values["job.oldest{tag:1}"], []float64{0})
+	ensure.DeepEqual(t, len(r.values["job.running{tag:1}"]), 0)
+	n, _ := a.InFlight("job", "tag:1")
+	ensure.DeepEqual(t, n, 0)
+
+	a.Report()
+	ensure.DeepEqual(t, r.values["job.in_flight{tag:1}"], []float64{0})
+}
+
+func TestActiveTimersRun(t *testing.T) {
+	t.Parallel()
+	var r activeRecorder
+	a := stats.NewActiveTimers(r.client())
+	a.Start("job")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		a.Run(time.Millisecond, stop)
+		close(done)
+	}()
+	for {
+		r.mu.Lock()
+		reported := len(r.values["job.in_flight"]) > 0
+		r.mu.Unlock()
+		if reported {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+}
@@ -1,35 +1,236 @@
 package stats
 
-import "sort"
+import (
+	"math"
+	"sort"
+)
+
+// ValuePolicy determines how NaN and ±Inf values are handled. Negative values
+// are always valid, since sums are commonly used to record deltas.
+type ValuePolicy int
+
+const (
+	// ValueDefault uses InvalidValuePolicy. It is the zero value, so counters
+	// and clients with a Policy field follow the global policy unless set.
+	ValueDefault ValuePolicy = iota
+
+	// ValueKeep keeps invalid values as they are. A single NaN will make an
+	// average NaN.
+	ValueKeep
+
+	// ValueDrop drops invalid values.
+	ValueDrop
+
+	// ValueClamp replaces +Inf and -Inf with the largest and smallest finite
+	// values, and drops NaN. Sums which overflow are clamped the same way.
+	ValueClamp
+
+	// ValueCount drops invalid values, and SimpleCounter.Aggregate reports
+	// how many were dropped with the ".invalid_values" suffix.
+	ValueCount
+)
+
+// resolve returns the policy, or InvalidValuePolicy for ValueDefault.
+func (p ValuePolicy) resolve() ValuePolicy {
+	if p == ValueDefault {
+		return InvalidValuePolicy
+	}
+	return p
+}
+
+// ValidValues applies the policy to the values, returning the resulting
+// values along with the number of invalid values seen. The input is returned
+// as is if there is nothing to change, otherwise a new slice is returned.
+func ValidValues(values []float64, policy ValuePolicy) ([]float64, int) {
+	policy = policy.resolve()
+	invalid := 0
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			invalid++
+		}
+	}
+	if invalid == 0 || policy == ValueKeep {
+		return values, invalid
+	}
+	valid := make([]float64, 0, len(values))
+	for _, v := range values {
+		switch {
+		case math.IsNaN(v):
+		case math.IsInf(v, 1):
+			if policy == ValueClamp {
+				valid = append(valid, math.MaxFloat64)
+			}
+		case math.IsInf(v, -1):
+			if policy == ValueClamp {
+				valid = append(valid, -math.MaxFloat64)
+			}
+		default:
+			valid = append(valid, v)
+		}
+	}
+	return valid, invalid
+}
 
 // Average returns the average value
 func Average(values []float64) float64 {
+	return average(values, ValueDefault)
+}
+
+func average(values []float64, policy ValuePolicy) float64 {
+	values, _ = ValidValues(values, policy)
 	if len(values) == 0 {
 		return 0
 	}
-
-	var val float64
+	n := float64(len(values))
+	val := sum(values, ValueKeep)
+	if !math.IsInf(val, 0) {
+		return val / n
+	}
+	// The sum overflowed, which dividing first avoids for finite values.
+	// Rounding could still push it past the largest value.
+	val = 0
 	for _, point := range values {
-		val += point
+		val += point / n
 	}
-	return val / float64(len(values))
+	return math.Max(minValue(values, ValueKeep), math.Min(maxValue(values, ValueKeep), val))
 }
 
 // Sum returns the sum of all the given values
 func Sum(values []float64) float64 {
+	return sum(values, ValueDefault)
+}
+
+func sum(values []float64, policy ValuePolicy) float64 {
+	values, _ = ValidValues(values, policy)
 	var val float64
 	for _, point := range values {
 		val += point
 	}
+	if policy.resolve() == ValueClamp {
+		val = math.Max(-math.MaxFloat64, math.Min(math.MaxFloat64, val))
+	}
 	return val
 }
 
-// Percentiles returns a map containing the asked for percentiles
+// Min returns the smallest value, or 0 if there are no values
+func Min(values []float64) float64 {
+	return minValue(values, ValueDefault)
+}
+
+func minValue(values []float64, policy ValuePolicy) float64 {
+	values, _ = ValidValues(values, policy)
+	if len(values) == 0 {
+		return 0
+	}
+	min := values[0]
+	for _, v := range values[1:] {
+		min = math.Min(min, v)
+	}
+	return min
+}
+
+// Max returns the largest value, or 0 if there are no values
+func Max(values []float64) float64 {
+	return maxValue(values, ValueDefault)
+}
+
+func maxValue(values []float64, policy ValuePolicy) float64 {
+	values, _ = ValidValues(values, policy)
+	if len(values) == 0 {
+		return 0
+	}
+	max := values[0]
+	for _, v := range values[1:] {
+		max = math.Max(max, v)
+	}
+	return max
+}
+
+// Last returns the last value, or 0 if there are no values
+func Last(values []float64) float64 {
+	return last(values, ValueDefault)
+}
+
+func last(values []float64, policy ValuePolicy) float64 {
+	values, _ = ValidValues(values, policy)
+	if len(values) == 0 {
+		return 0
+	}
+	return values[len(values)-1]
+}
+
+// AggregateValue returns the single value the aggregation type reports for
+// the values: the sum, minimum, maximum or last value, or the average for
+// AggregateAvg and AggregateHistogram
+func AggregateValue(t Type, values []float64) float64 {
+	return aggregateValue(t, values, ValueDefault)
+}
+
+func aggregateValue(t Type, values []float64, policy ValuePolicy) float64 {
+	switch t {
+	case AggregateSum:
+		return sum(values, policy)
+	case AggregateMin:
+		return minValue(values, policy)
+	case AggregateMax:
+		return maxValue(values, policy)
+	case AggregateLast:
+		return last(values, policy)
+	}
+	return average(values, policy)
+}
+
+// Percentiles returns a map containing the asked for percentiles, using the
+// nearest rank. The percentiles are all 0 if there are no values. NaN values
+// are always ignored since they have no rank, and percentiles outside [0, 1]
+// are clamped, so results never decrease as the percentile increases and
+// are within the minimum and maximum values.
 func Percentiles(values []float64, percentiles map[string]float64) map[string]float64 {
+	return percentilesOf(values, percentiles, ValueDefault)
+}
+
+func percentilesOf(values []float64, percentiles map[string]float64, policy ValuePolicy) map[string]float64 {
+	values, _ = ValidValues(values, policy)
+	values = dropNaN(values)
 	sort.Float64s(values)
 	results := map[string]float64{}
+	if len(values) == 0 {
+		for label := range percentiles {
+			results[label] = 0
+		}
+		return results
+	}
 	for label, p := range percentiles {
-		results[label] = values[int(float64(len(values))*p)]
+		results[label] = values[rank(len(values), p)]
 	}
 	return results
 }
+
+func dropNaN(values []float64) []float64 {
+	for i, v := range values {
+		if math.IsNaN(v) {
+			valid := append([]float64(nil), values[:i]...)
+			for _, v := range values[i+1:] {
+				if !math.IsNaN(v) {
+					valid = append(valid, v)
+				}
+			}
+			return valid
+		}
+	}
+	return values
+}
+
+// rank returns the index of the percentile in n sorted values.
+func rank(n int, p float64) int {
+	if !(p > 0) {
+		return 0
+	}
+	if p >= 1 {
+		return n - 1
+	}
+	if i := int(float64(n) * p); i < n {
+		return i
+	}
+	return n - 1
+}
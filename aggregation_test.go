@@ -1,6 +1,7 @@
 package stats_test
 
 import (
+	"math"
 	"testing"
 
 	"github.com/facebookgo/ensure"
@@ -23,6 +24,32 @@ func TestSum(t *testing.T) {
 	ensure.DeepEqual(t, stats.Sum([]float64{1, 2, 3}), 6.0)
 }
 
+func TestMinMaxLast(t *testing.T) {
+	t.Parallel()
+	values := []float64{2, -1, 7, 3}
+	ensure.DeepEqual(t, stats.Min(values), -1.0)
+	ensure.DeepEqual(t, stats.Max(values), 7.0)
+	ensure.DeepEqual(t, stats.Last(values), 3.0)
+	ensure.DeepEqual(t, stats.Min(nil), 0.0)
+	ensure.DeepEqual(t, stats.Max(nil), 0.0)
+	ensure.DeepEqual(t, stats.Last(nil), 0.0)
+}
+
+func TestAggregateValue(t *testing.T) {
+	t.Parallel()
+	values := []float64{4, 1, 2, 5}
+	for typ, want := range map[stats.Type]float64{
+		stats.AggregateAvg:       3,
+		stats.AggregateSum:       12,
+		stats.AggregateHistogram: 3,
+		stats.AggregateMin:       1,
+		stats.AggregateMax:       5,
+		stats.AggregateLast:      5,
+	} {
+		ensure.DeepEqual(t, stats.AggregateValue(typ, values), want, typ)
+	}
+}
+
 func TestPercentiles(t *testing.T) {
 	t.Parallel()
 	percentiles := map[string]float64{
@@ -36,3 +63,51 @@ func TestPercentiles(t *testing.T) {
 	}
 	ensure.DeepEqual(t, stats.Percentiles(input, percentiles), expected)
 }
+
+func TestPercentilesEmpty(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, stats.Percentiles(nil, stats.HistogramPercentiles), map[string]float64{
+		"p50": 0,
+		"p95": 0,
+		"p99": 0,
+	})
+}
+
+func TestValidValues(t *testing.T) {
+	t.Parallel()
+	input := []float64{1, math.NaN(), math.Inf(1), -2, math.Inf(-1)}
+
+	kept, invalid := stats.ValidValues(input, stats.ValueKeep)
+	ensure.DeepEqual(t, len(kept), 5)
+	ensure.DeepEqual(t, invalid, 3)
+
+	dropped, invalid := stats.ValidValues(input, stats.ValueDrop)
+	ensure.DeepEqual(t, dropped, []float64{1, -2})
+	ensure.DeepEqual(t, invalid, 3)
+
+	clamped, invalid := stats.ValidValues(input, stats.ValueClamp)
+	ensure.DeepEqual(t, clamped, []float64{1, math.MaxFloat64, -2, -math.MaxFloat64})
+	ensure.DeepEqual(t, invalid, 3)
+
+	counted, invalid := stats.ValidValues(input, stats.ValueCount)
+	ensure.DeepEqual(t, counted, []float64{1, -2})
+	ensure.DeepEqual(t, invalid, 3)
+
+	valid := []float64{1, 2}
+	same, invalid := stats.ValidValues(valid, stats.ValueDrop)
+	ensure.True(t, &same[0] == &valid[0])
+	ensure.DeepEqual(t, invalid, 0)
+}
+
+// Changes the global policy, so it can't run in parallel.
+func TestInvalidValuePolicyHelpers(t *testing.T) {
+	defer func(p stats.ValuePolicy) { stats.InvalidValuePolicy = p }(stats.InvalidValuePolicy)
+	input := []float64{1, math.NaN(), 3, math.Inf(1)}
+
+	ensure.True(t, math.IsNaN(stats.Average(input)))
+
+	stats.InvalidValuePolicy = stats.ValueDrop
+	ensure.DeepEqual(t, stats.Average(input), 2.0)
+	ensure.DeepEqual(t, stats.Sum(input), 4.0)
+	ensure.DeepEqual(t, stats.Percentiles(input, map[string]float64{"p50": 0.5}), map[string]float64{"p50": 3})
+}
@@ -0,0 +1,189 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aggregator is a Client which aggregates values in memory until Flush. It is
+// an AliasClient, so a value bumped for many keys at once, such as through
+// PrefixClient, is recorded once and only expanded to every key by Flush. It
+// is also an ExtendedClient. It is safe for concurrent use.
+type Aggregator struct {
+	// Policy is applied to values as they are bumped. Dropped values are
+	// reported through the Invalid count of the flushed counters.
+	Policy ValuePolicy
+
+	mu     sync.Mutex
+	series map[string]*aggregatorSeries
+	seq    int
+}
+
+type aggregatorSeries struct {
+	keys    []string
+	tags    []string
+	typ     Type
+	values  []float64
+	invalid int
+
+	// seq orders the series by their last bump, so values of the same key
+	// recorded in different series are merged in order.
+	seq int
+}
+
+// seriesID identifies the series of the keys, type and tags, which must be
+// sorted.
+func seriesID(t Type, keys []string, tags []string) string {
+	var b strings.Builder
+	b.WriteByte(byte(t))
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteByte(0)
+	}
+	b.WriteByte(1)
+	for _, tag := range tags {
+		b.WriteString(tag)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func sortedTags(tags []string) []string {
+	if sort.StringsAreSorted(tags) {
+		return tags
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (a *Aggregator) bump(t Type, keys []string, val float64, tags []string) {
+	policy := a.Policy.resolve()
+	valid, invalid := ValidValues([]float64{val}, policy)
+	if len(valid) == 0 && policy != ValueCount {
+		return
+	}
+	tags = sortedTags(tags)
+	id := seriesID(t, keys, tags)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.series[id]
+	if !ok {
+		if a.series == nil {
+			a.series = map[string]*aggregatorSeries{}
+		}
+		s = &aggregatorSeries{
+			keys: append([]string(nil), keys...),
+			tags: append([]string(nil), tags...),
+			typ:  t,
+		}
+		a.series[id] = s
+	}
+	s.values = append(s.values, valid...)
+	s.invalid += invalid
+	a.seq++
+	s.seq = a.seq
+}
+
+// BumpAvg is part of the Client interface.
+func (a *Aggregator) BumpAvg(key string, val float64, tags ...string) {
+	a.bump(AggregateAvg, []string{key}, val, tags)
+}
+
+// BumpSum is part of the Client interface.
+func (a *Aggregator) BumpSum(key string, val float64, tags ...string) {
+	a.bump(AggregateSum, []string{key}, val, tags)
+}
+
+// BumpHistogram is part of the Client interface.
+func (a *Aggregator) BumpHistogram(key string, val float64, tags ...string) {
+	a.bump(AggregateHistogram, []string{key}, val, tags)
+}
+
+// BumpTime is part of the Client interface. It returns a Stopper.
+func (a *Aggregator) BumpTime(key string, tags ...string) interface {
+	End()
+} {
+	return &Stopper{Key: key, Start: time.Now(), Client: a, Tags: tags}
+}
+
+// BumpMin is part of the ExtendedClient interface.
+func (a *Aggregator) BumpMin(key string, val float64, tags ...string) {
+	a.bump(AggregateMin, []string{key}, val, tags)
+}
+
+// BumpMax is part of the ExtendedClient interface.
+func (a *Aggregator) BumpMax(key string, val float64, tags ...string) {
+	a.bump(AggregateMax, []string{key}, val, tags)
+}
+
+// BumpLast is part of the ExtendedClient interface.
+func (a *Aggregator) BumpLast(key string, val float64, tags ...string) {
+	a.bump(AggregateLast, []string{key}, val, tags)
+}
+
+// BumpAvgAliases is part of the AliasClient interface.
+func (a *Aggregator) BumpAvgAliases(keys []string, val float64, tags ...string) {
+	a.bump(AggregateAvg, keys, val, tags)
+}
+
+// BumpSumAliases is part of the AliasClient interface.
+func (a *Aggregator) BumpSumAliases(keys []string, val float64, tags ...string) {
+	a.bump(AggregateSum, keys, val, tags)
+}
+
+// BumpHistogramAliases is part of the AliasClient interface.
+func (a *Aggregator) BumpHistogramAliases(keys []string, val float64, tags ...string) {
+	a.bump(AggregateHistogram, keys, val, tags)
+}
+
+// BumpTimeAliases is part of the AliasClient interface. It returns an
+// AliasStopper.
+func (a *Aggregator) BumpTimeAliases(keys []string, tags ...string) interface {
+	End()
+} {
+	return &AliasStopper{Keys: keys, Start: time.Now(), Client: a, Tags: tags}
+}
+
+// Flush returns what was recorded since the last Flush as a SimpleCounter for
+// every key and set of tags, and starts over. Values recorded for many keys
+// are expanded to a counter for each key here. If a key was bumped with
+// different aggregation types the first error from Aggregates.Add is
+// returned along with the other counters.
+func (a *Aggregator) Flush() (Aggregates, error) {
+	a.mu.Lock()
+	series := make([]*aggregatorSeries, 0, len(a.series))
+	for _, s := range a.series {
+		series = append(series, s)
+	}
+	a.series = nil
+	a.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].seq < series[j].seq
+	})
+	result := Aggregates{}
+	var err error
+	for _, s := range series {
+		for _, key := range s.keys {
+			c := &SimpleCounter{
+				Key: key,
+				// The values are shared by the counters of every key, so
+				// appending to them must copy.
+				Values:  s.values[:len(s.values):len(s.values)],
+				Type:    s.typ,
+				Policy:  a.Policy,
+				Invalid: s.invalid,
+			}
+			if len(s.tags) > 0 {
+				c.Tags = s.tags
+			}
+			if aerr := result.Add(c); aerr != nil && err == nil {
+				err = aerr
+			}
+		}
+	}
+	return result, err
+}
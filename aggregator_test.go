@@ -0,0 +1,108 @@
+package stats_test
+
+import (
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/clienttest"
+)
+
+func TestAggregatorConformance(t *testing.T) {
+	clienttest.Run(t, func(t *testing.T) (stats.Client, clienttest.Snapshot) {
+		a := &stats.Aggregator{}
+		flushed := stats.Aggregates{}
+		return a, func() []clienttest.Sample {
+			counters, err := a.Flush()
+			ensure.Nil(t, err)
+			for _, c := range counters {
+				ensure.Nil(t, flushed.Add(c))
+			}
+			var samples []clienttest.Sample
+			for _, c := range flushed {
+				sc := c.(*stats.SimpleCounter)
+				samples = append(samples, clienttest.Sample{
+					Key:   sc.Key,
+					Tags:  sc.Tags,
+					Count: len(sc.Values),
+					Value: stats.AggregateValue(sc.Type, sc.Values),
+				})
+			}
+			return samples
+		}
+	})
+}
+
+func TestAggregatorAliases(t *testing.T) {
+	t.Parallel()
+	a := &stats.Aggregator{}
+	pc := stats.PrefixClient([]string{"a.", "b."}, a)
+	pc.BumpSum("sum", 1, "t:x")
+	pc.BumpSum("sum", 2, "t:x")
+	a.BumpSum("a.sum", 4, "t:x")
+	pc.BumpTime("time", "t:y").End()
+	stats.BumpLast(pc, "last", 1)
+	a.BumpLast("a.last", 2)
+	stats.BumpLast(pc, "last", 3)
+
+	counters, err := a.Flush()
+	ensure.Nil(t, err)
+	values := map[string]float64{}
+	for key, c := range counters {
+		values[key] = stats.AggregateValue(c.GetType(), c.GetValues())
+	}
+	ensure.DeepEqual(t, len(values), 8)
+	ensure.DeepEqual(t, values["a.sum{t:x}"], 7.0)
+	ensure.DeepEqual(t, values["b.sum{t:x}"], 3.0)
+	ensure.DeepEqual(t, values["a.last"], 3.0)
+	ensure.DeepEqual(t, values["b.last"], 3.0)
+	for _, key := range []string{"a.time{t:y}", "b.time{t:y}", "a.time.total{t:y}", "b.time.total{t:y}"} {
+		c, ok := counters[key].(*stats.SimpleCounter)
+		ensure.True(t, ok, key)
+		ensure.DeepEqual(t, len(c.Values), 1, key)
+		ensure.DeepEqual(t, c.Tags, []string{"t:y"}, key)
+	}
+
+	// Flush starts over.
+	counters, err = a.Flush()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(counters), 0)
+}
+
+func TestAggregatorMismatchedTypes(t *testing.T) {
+	t.Parallel()
+	a := &stats.Aggregator{}
+	a.BumpSum("key", 1)
+	a.BumpAvg("key", 1)
+	a.BumpAvg("other", 1)
+	counters, err := a.Flush()
+	ensure.Err(t, err, regexp.MustCompile("mismatched aggregation type for: key"))
+	ensure.DeepEqual(t, len(counters), 2)
+}
+
+func TestAggregatorPolicy(t *testing.T) {
+	t.Parallel()
+	a := &stats.Aggregator{Policy: stats.ValueCount}
+	a.BumpAvg("avg", math.NaN())
+	a.BumpAvg("avg", 1)
+	a.BumpSum("invalid", math.Inf(1))
+	counters, err := a.Flush()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, counters["avg"].GetValues(), []float64{1})
+	ensure.DeepEqual(t, counters["avg"].(*stats.SimpleCounter).Aggregate(), map[string]float64{
+		"avg":                1,
+		"avg.invalid_values": 1,
+	})
+	ensure.DeepEqual(t, counters["invalid"].(*stats.SimpleCounter).Aggregate(), map[string]float64{
+		"invalid":                0,
+		"invalid.invalid_values": 1,
+	})
+
+	a = &stats.Aggregator{Policy: stats.ValueDrop}
+	a.BumpAvg("avg", math.NaN())
+	counters, err = a.Flush()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(counters), 0)
+}
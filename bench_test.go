@@ -0,0 +1,238 @@
+package stats_test
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/statsd"
+)
+
+// Benchmarks run under parallel load since clients are used from hot paths
+// in many goroutines. Compare against testdata/bench_baseline.txt, recorded
+// with -cpu 1,4, with cmd/statsbench.
+
+type benchAliasClient struct {
+	stats.HookClient
+}
+
+func (benchAliasClient) BumpAvgAliases(keys []string, val float64, tags ...string)       {}
+func (benchAliasClient) BumpSumAliases(keys []string, val float64, tags ...string)       {}
+func (benchAliasClient) BumpHistogramAliases(keys []string, val float64, tags ...string) {}
+func (benchAliasClient) BumpTimeAliases(keys []string, tags ...string) interface {
+	End()
+} {
+	return stats.NoOpEnd
+}
+
+// flushing flushes the Aggregator every millisecond in a goroutine, as a
+// reporter would, so its values don't grow for the whole benchmark.
+func flushing(b *testing.B, a *stats.Aggregator) *stats.Aggregator {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.Flush()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	b.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	return a
+}
+
+// benchClients returns a constructor for every client, since the aggregating
+// and async ones run a goroutine for the duration of a benchmark.
+func benchClients() map[string]func(*testing.B) stats.Client {
+	var count int64
+	hook := func(key string, val float64, tags ...string) {
+		atomic.AddInt64(&count, 1)
+	}
+	counting := &stats.HookClient{
+		BumpAvgHook:       hook,
+		BumpSumHook:       hook,
+		BumpHistogramHook: hook,
+		BumpTimeHook: func(key string, tags ...string) interface {
+			End()
+		} {
+			return &stats.Stopper{Key: key, Start: time.Now(), Client: &stats.HookClient{
+				BumpSumHook:       hook,
+				BumpHistogramHook: hook,
+			}}
+		},
+	}
+	prefixes := []string{"a.", "b.", "c."}
+	client := func(c stats.Client) func(*testing.B) stats.Client {
+		return func(*testing.B) stats.Client { return c }
+	}
+	return map[string]func(*testing.B) stats.Client{
+		"HookClient":        client(&stats.HookClient{}),
+		"HookClientHooks":   client(counting),
+		"PrefixClient":      client(stats.PrefixClient(prefixes, counting)),
+		"PrefixClientAlias": client(stats.PrefixClient(prefixes, &benchAliasClient{})),
+		"ScopedClient":      client(stats.Scope(counting, "db").Scope("users", "shard:1")),
+		"Aggregator": func(b *testing.B) stats.Client {
+			return flushing(b, &stats.Aggregator{})
+		},
+		"AggregatorPrefix": func(b *testing.B) stats.Client {
+			return stats.PrefixClient(prefixes, flushing(b, &stats.Aggregator{}))
+		},
+		// The async statsd emitter, flushing in its own goroutine.
+		"StatsdPacker": func(b *testing.B) stats.Client {
+			p := statsd.NewPacker(io.Discard, statsd.DefaultMaxPacketSize, false)
+			p.Start(time.Millisecond)
+			b.Cleanup(func() { p.Close() })
+			return p
+		},
+	}
+}
+
+func BenchmarkClients(b *testing.B) {
+	for name, newClient := range benchClients() {
+		newClient := newClient
+		b.Run(name+"/BumpAvg", func(b *testing.B) {
+			c := newClient(b)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.BumpAvg("key", 1)
+				}
+			})
+		})
+		b.Run(name+"/BumpSumTags", func(b *testing.B) {
+			c := newClient(b)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.BumpSum("key", 1, "method:get", "status:200")
+				}
+			})
+		})
+		b.Run(name+"/BumpHistogram", func(b *testing.B) {
+			c := newClient(b)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.BumpHistogram("key", 1)
+				}
+			})
+		})
+		b.Run(name+"/BumpTime", func(b *testing.B) {
+			c := newClient(b)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.BumpTime("key").End()
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkAggregatorFlush(b *testing.B) {
+	for _, n := range []int{10, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			tags := make([]string, n)
+			for i := range tags {
+				tags[i] = fmt.Sprintf("shard:%d", i)
+			}
+			a := &stats.Aggregator{}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, tag := range tags {
+					a.BumpSum("key", 1, tag)
+				}
+				a.Flush()
+			}
+		})
+	}
+}
+
+func BenchmarkNilClientHelpers(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			stats.BumpSum(nil, "key", 1)
+			stats.BumpTime(nil, "key").End()
+		}
+	})
+}
+
+func BenchmarkCounterHandle(b *testing.B) {
+	var m struct {
+		Requests stats.CounterHandle `stats:"requests,sum"`
+	}
+	if err := stats.Init(&m, &stats.HookClient{}, "server."); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Requests.Bump(1)
+		}
+	})
+}
+
+func BenchmarkPercentiles(b *testing.B) {
+	for _, n := range []int{10, 1000, 100000} {
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = rand.Float64()
+		}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			// Percentiles sorts in place, so each iteration sorts a fresh copy.
+			scratch := make([]float64, n)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copy(scratch, values)
+				stats.Percentiles(scratch, stats.HistogramPercentiles)
+			}
+		})
+	}
+}
+
+func BenchmarkSimpleCounterAggregate(b *testing.B) {
+	for _, n := range []int{10, 1000, 100000} {
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = rand.Float64()
+		}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			c := &stats.SimpleCounter{
+				Key:    "key",
+				Type:   stats.AggregateHistogram,
+				Values: make([]float64, n),
+			}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copy(c.Values, values)
+				c.Aggregate()
+			}
+		})
+	}
+}
+
+func BenchmarkReservoirCounter(b *testing.B) {
+	for _, decay := range []float64{0, 0.015} {
+		b.Run(fmt.Sprintf("Decay%v", decay), func(b *testing.B) {
+			r := &stats.ReservoirCounter{Key: "key", Decay: decay}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.AddValues(float64(i))
+			}
+		})
+	}
+}
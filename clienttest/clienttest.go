@@ -0,0 +1,276 @@
+// Package clienttest provides a conformance suite for stats.Client
+// implementations.
+//
+// An implementation runs the suite from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		clienttest.Run(t, func(t *testing.T) (stats.Client, clienttest.Snapshot) {
+//			c := mybackend.New()
+//			return c, c.Samples
+//		})
+//	}
+package clienttest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/facebookgo/stats"
+)
+
+// Sample is a single aggregated series as recorded by an implementation.
+type Sample struct {
+	Key  string
+	Tags []string
+
+	// Count is the number of values recorded for the series.
+	Count int
+
+	// Value is the aggregated value: the average for BumpAvg and
+	// BumpHistogram, the sum for BumpSum and the minimum, maximum or last
+	// value for BumpMin, BumpMax and BumpLast.
+	Value float64
+}
+
+// Snapshot returns everything recorded so far. Implementations which can't
+// report what they recorded may return a nil Snapshot from the Factory, in
+// which case only the checks which don't need results are run.
+type Snapshot func() []Sample
+
+// Factory returns a new Client for a single test.
+type Factory func(t *testing.T) (stats.Client, Snapshot)
+
+// Run runs the conformance suite. The contract it checks is:
+//
+//   - Clients are safe for concurrent use.
+//   - Nil tags and empty keys don't panic.
+//   - A key is only ever bumped with one aggregation type, so aggregating
+//     clients may reject a key bumped with different types.
+//   - NaN and ±Inf values don't panic or affect other series.
+//   - Negative values are summed like any other value.
+//   - Calling End on the value returned by BumpTime more than once records
+//     the duration only once.
+//   - The order of tags doesn't matter, a series is identified by its key and
+//     its set of tags.
+//   - BumpAvg, BumpSum and BumpHistogram aggregate as documented on Sample.
+//   - The stats.BumpMin, stats.BumpMax and stats.BumpLast helpers record a
+//     single series under the key, aggregated as documented on Sample by an
+//     ExtendedClient and as a histogram otherwise.
+func Run(t *testing.T, factory Factory) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			c, snapshot := factory(t)
+			if test.needsSnapshot && snapshot == nil {
+				t.Skip("clienttest: no snapshot provided")
+			}
+			test.run(t, c, snapshot)
+		})
+	}
+}
+
+var tests = []struct {
+	name          string
+	needsSnapshot bool
+	run           func(*testing.T, stats.Client, Snapshot)
+}{
+	{"Concurrency", false, testConcurrency},
+	{"NilTags", false, testNilTags},
+	{"EmptyKeyAvg", false, testEmptyKey(func(c stats.Client) { c.BumpAvg("", 1) })},
+	{"EmptyKeySum", false, testEmptyKey(func(c stats.Client) { c.BumpSum("", 1) })},
+	{"EmptyKeyHistogram", false, testEmptyKey(func(c stats.Client) { c.BumpHistogram("", 1) })},
+	{"EmptyKeyTime", false, testEmptyKey(func(c stats.Client) { c.BumpTime("").End() })},
+	{"NonFinite", false, testNonFinite},
+	{"NegativeSum", true, testNegativeSum},
+	{"EndTwice", true, testEndTwice},
+	{"TagOrdering", true, testTagOrdering},
+	{"Aggregation", true, testAggregation},
+	{"ExtendedHelpers", true, testExtendedHelpers},
+}
+
+func testConcurrency(t *testing.T, c stats.Client, snapshot Snapshot) {
+	const goroutines, bumps = 8, 1000
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			tag := fmt.Sprintf("g:%d", g%2)
+			for i := 0; i < bumps; i++ {
+				c.BumpSum("concurrent.sum", 1)
+				c.BumpAvg("concurrent.avg", 1, tag)
+				c.BumpHistogram("concurrent.histogram", float64(i))
+				c.BumpTime("concurrent.time").End()
+			}
+		}(g)
+	}
+	wg.Wait()
+	if snapshot == nil {
+		return
+	}
+	s := find(t, snapshot, "concurrent.sum")
+	if s.Count != goroutines*bumps || s.Value != goroutines*bumps {
+		t.Fatalf("clienttest: concurrent sum: got count %d value %v, want %d", s.Count, s.Value, goroutines*bumps)
+	}
+	s = find(t, snapshot, "concurrent.histogram")
+	if s.Count != goroutines*bumps {
+		t.Fatalf("clienttest: concurrent histogram: got count %d, want %d", s.Count, goroutines*bumps)
+	}
+}
+
+func testNilTags(t *testing.T, c stats.Client, snapshot Snapshot) {
+	var tags []string
+	c.BumpAvg("nil.tags.avg", 1, tags...)
+	c.BumpSum("nil.tags.sum", 1, tags...)
+	c.BumpHistogram("nil.tags.histogram", 1, tags...)
+	c.BumpTime("nil.tags.time", tags...).End()
+	if snapshot == nil {
+		return
+	}
+	if s := find(t, snapshot, "nil.tags.sum"); len(s.Tags) != 0 {
+		t.Fatalf("clienttest: nil tags: got tags %q", s.Tags)
+	}
+}
+
+// testEmptyKey returns a test bumping the empty key with a single method,
+// since each method records a different aggregation type.
+func testEmptyKey(bump func(stats.Client)) func(*testing.T, stats.Client, Snapshot) {
+	return func(t *testing.T, c stats.Client, snapshot Snapshot) {
+		bump(c)
+		if snapshot != nil {
+			snapshot()
+		}
+	}
+}
+
+func testNonFinite(t *testing.T, c stats.Client, snapshot Snapshot) {
+	c.BumpSum("finite.sum", 1)
+	c.BumpAvg("finite.avg", 2)
+	c.BumpHistogram("finite.histogram", 3)
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		c.BumpAvg("nonfinite.avg", v)
+		c.BumpSum("nonfinite.sum", v)
+		c.BumpHistogram("nonfinite.histogram", v)
+	}
+	if snapshot == nil {
+		return
+	}
+	for key, want := range map[string]float64{
+		"finite.sum":       1,
+		"finite.avg":       2,
+		"finite.histogram": 3,
+	} {
+		if s := find(t, snapshot, key); s.Value != want {
+			t.Fatalf("clienttest: %s affected by non-finite values: got %v, want %v", key, s.Value, want)
+		}
+	}
+}
+
+func testNegativeSum(t *testing.T, c stats.Client, snapshot Snapshot) {
+	c.BumpSum("negative.sum", 5)
+	c.BumpSum("negative.sum", -2)
+	if s := find(t, snapshot, "negative.sum"); s.Value != 3 {
+		t.Fatalf("clienttest: negative sum: got %v, want 3", s.Value)
+	}
+}
+
+func testEndTwice(t *testing.T, c stats.Client, snapshot Snapshot) {
+	e := c.BumpTime("end.twice")
+	e.End()
+	e.End()
+	if s := find(t, snapshot, "end.twice"); s.Count != 1 {
+		t.Fatalf("clienttest: End called twice: got count %d, want 1", s.Count)
+	}
+}
+
+func testTagOrdering(t *testing.T, c stats.Client, snapshot Snapshot) {
+	c.BumpSum("tag.order", 1, "a:1", "b:2")
+	c.BumpSum("tag.order", 1, "b:2", "a:1")
+	var matches []Sample
+	for _, s := range snapshot() {
+		if s.Key == "tag.order" {
+			matches = append(matches, s)
+		}
+	}
+	if len(matches) != 1 {
+		t.Fatalf("clienttest: tag ordering: got %d series, want 1", len(matches))
+	}
+	if got := sortedTags(matches[0].Tags); got != "a:1,b:2" {
+		t.Fatalf("clienttest: tag ordering: got tags %s", got)
+	}
+	if matches[0].Value != 2 {
+		t.Fatalf("clienttest: tag ordering: got %v, want 2", matches[0].Value)
+	}
+}
+
+func testAggregation(t *testing.T, c stats.Client, snapshot Snapshot) {
+	for _, v := range []float64{1, 2, 3, 6} {
+		c.BumpAvg("agg.avg", v)
+		c.BumpSum("agg.sum", v)
+		c.BumpHistogram("agg.histogram", v)
+	}
+	c.BumpSum("agg.tagged", 1, "t:x")
+	c.BumpSum("agg.tagged", 2, "t:y")
+
+	for key, want := range map[string]Sample{
+		"agg.avg":       {Count: 4, Value: 3},
+		"agg.sum":       {Count: 4, Value: 12},
+		"agg.histogram": {Count: 4, Value: 3},
+	} {
+		if s := find(t, snapshot, key); s.Count != want.Count || s.Value != want.Value {
+			t.Fatalf("clienttest: %s: got count %d value %v, want count %d value %v",
+				key, s.Count, s.Value, want.Count, want.Value)
+		}
+	}
+	tagged := map[string]float64{}
+	for _, s := range snapshot() {
+		if s.Key == "agg.tagged" {
+			tagged[sortedTags(s.Tags)] = s.Value
+		}
+	}
+	if len(tagged) != 2 || tagged["t:x"] != 1 || tagged["t:y"] != 2 {
+		t.Fatalf("clienttest: tagged series: got %v", tagged)
+	}
+}
+
+func testExtendedHelpers(t *testing.T, c stats.Client, snapshot Snapshot) {
+	for _, v := range []float64{4, 2, 6} {
+		stats.BumpMin(c, "extended.min", v)
+		stats.BumpMax(c, "extended.max", v)
+		stats.BumpLast(c, "extended.last", v)
+	}
+	want := map[string]float64{"extended.min": 2, "extended.max": 6, "extended.last": 6}
+	if _, ok := c.(stats.ExtendedClient); !ok {
+		// The fallback histograms report the average.
+		want = map[string]float64{"extended.min": 4, "extended.max": 4, "extended.last": 4}
+	}
+	for key, want := range want {
+		if s := find(t, snapshot, key); s.Value != want {
+			t.Fatalf("clienttest: %s: got %v, want %v", key, s.Value, want)
+		}
+	}
+}
+
+// find returns the single sample for key.
+func find(t *testing.T, snapshot Snapshot, key string) Sample {
+	var found []Sample
+	for _, s := range snapshot() {
+		if s.Key == key {
+			found = append(found, s)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("clienttest: got %d series for %q, want 1", len(found), key)
+	}
+	return found[0]
+}
+
+func sortedTags(tags []string) string {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
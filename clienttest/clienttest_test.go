@@ -0,0 +1,119 @@
+package clienttest_test
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/clienttest"
+)
+
+// recorder is a minimal conforming implementation used to test the suite.
+// Like aggregating clients it rejects a key bumped with different
+// aggregation types.
+type recorder struct {
+	mu         sync.Mutex
+	series     map[string]*clienttest.Sample
+	sums       map[string]bool
+	kinds      map[string]string
+	mismatched []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		series: map[string]*clienttest.Sample{},
+		sums:   map[string]bool{},
+		kinds:  map[string]string{},
+	}
+}
+
+func (r *recorder) bump(key string, val float64, sum bool, tags []string, kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.kinds[key]; ok && existing != kind {
+		r.mismatched = append(r.mismatched, key)
+	}
+	r.kinds[key] = kind
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	id := key + "\x00" + strings.Join(sorted, "\x00")
+
+	s, ok := r.series[id]
+	if !ok {
+		s = &clienttest.Sample{Key: key, Tags: sorted}
+		r.series[id] = s
+		r.sums[id] = sum
+	}
+	s.Count++
+	s.Value += val
+}
+
+func (r *recorder) BumpAvg(key string, val float64, tags ...string) {
+	r.bump(key, val, false, tags, "avg")
+}
+
+func (r *recorder) BumpSum(key string, val float64, tags ...string) {
+	r.bump(key, val, true, tags, "sum")
+}
+
+func (r *recorder) BumpHistogram(key string, val float64, tags ...string) {
+	r.bump(key, val, false, tags, "histogram")
+}
+
+func (r *recorder) BumpTime(key string, tags ...string) interface {
+	End()
+} {
+	start := time.Now()
+	var once sync.Once
+	return endFunc(func() {
+		once.Do(func() {
+			r.BumpHistogram(key, time.Since(start).Seconds()*1000, tags...)
+		})
+	})
+}
+
+func (r *recorder) Samples() []clienttest.Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var samples []clienttest.Sample
+	for id, s := range r.series {
+		sample := *s
+		if !r.sums[id] {
+			sample.Value /= float64(sample.Count)
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+type endFunc func()
+
+func (e endFunc) End() {
+	e()
+}
+
+func TestRunRecorder(t *testing.T) {
+	clienttest.Run(t, func(t *testing.T) (stats.Client, clienttest.Snapshot) {
+		r := newRecorder()
+		t.Cleanup(func() {
+			if len(r.mismatched) > 0 {
+				t.Errorf("keys bumped with different types: %q", r.mismatched)
+			}
+		})
+		return r, r.Samples
+	})
+}
+
+// Clients without a Snapshot only run the checks which don't need results.
+func TestRunWithoutSnapshot(t *testing.T) {
+	clienttest.Run(t, func(t *testing.T) (stats.Client, clienttest.Snapshot) {
+		return stats.PrefixClient([]string{"a.", "b."}, &stats.HookClient{}), nil
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Result is a single benchmark measurement.
+type Result struct {
+	NsPerOp     float64
+	BytesPerOp  float64
+	AllocsPerOp float64
+}
+
+// Results maps benchmark names to all the measurements seen for them. Names
+// keep the -N GOMAXPROCS suffix, so the runs of each -cpu value are compared
+// separately.
+type Results map[string][]Result
+
+// Parse reads go test -bench output, ignoring lines which aren't benchmark
+// results.
+func Parse(r io.Reader) (Results, error) {
+	results := Results{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		var res Result
+		var ok bool
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				res.NsPerOp, ok = v, true
+			case "B/op":
+				res.BytesPerOp = v
+			case "allocs/op":
+				res.AllocsPerOp = v
+			}
+		}
+		if ok {
+			results[fields[0]] = append(results[fields[0]], res)
+		}
+	}
+	return results, s.Err()
+}
+
+// Median returns the median of each measurement for the benchmark.
+func (r Results) Median(name string) *Result {
+	runs := r[name]
+	if len(runs) == 0 {
+		return nil
+	}
+	median := func(get func(Result) float64) float64 {
+		vs := make([]float64, len(runs))
+		for i, run := range runs {
+			vs[i] = get(run)
+		}
+		sort.Float64s(vs)
+		if len(vs)%2 == 1 {
+			return vs[len(vs)/2]
+		}
+		return (vs[len(vs)/2-1] + vs[len(vs)/2]) / 2
+	}
+	return &Result{
+		NsPerOp:     median(func(r Result) float64 { return r.NsPerOp }),
+		BytesPerOp:  median(func(r Result) float64 { return r.BytesPerOp }),
+		AllocsPerOp: median(func(r Result) float64 { return r.AllocsPerOp }),
+	}
+}
+
+// Comparison is the outcome of comparing one benchmark.
+type Comparison struct {
+	Name string
+	Old  *Result
+	New  *Result
+
+	// Delta is the percentage change in ns/op.
+	Delta float64
+
+	// Regressed is true if ns/op increased by more than the threshold, or if
+	// allocations increased.
+	Regressed bool
+}
+
+// Compare compares every benchmark present in either result set.
+func Compare(baseline, current Results, threshold float64) []*Comparison {
+	names := map[string]bool{}
+	for name := range baseline {
+		names[name] = true
+	}
+	for name := range current {
+		names[name] = true
+	}
+	var comparisons []*Comparison
+	for name := range names {
+		c := &Comparison{
+			Name: name,
+			Old:  baseline.Median(name),
+			New:  current.Median(name),
+		}
+		if c.Old != nil && c.New != nil {
+			if c.Old.NsPerOp > 0 {
+				c.Delta = (c.New.NsPerOp - c.Old.NsPerOp) / c.Old.NsPerOp * 100
+			}
+			c.Regressed = c.Delta > threshold ||
+				c.New.AllocsPerOp > c.Old.AllocsPerOp ||
+				c.New.BytesPerOp > c.Old.BytesPerOp*(1+threshold/100)
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+)
+
+const sampleBaseline = `# -cpu 1,4
+goos: linux
+goarch: amd64
+pkg: github.com/facebookgo/stats
+BenchmarkClients/HookClient/BumpAvg       	100000000	        20.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkClients/HookClient/BumpAvg-4     	100000000	        10.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkClients/HookClient/BumpAvg-4     	100000000	        12.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkClients/HookClient/BumpAvg-4     	100000000	        11.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPercentiles/10-4                 	 5000000	       200 ns/op	     336 B/op	       2 allocs/op
+BenchmarkRemoved-4                        	 5000000	       200 ns/op
+PASS
+ok  	github.com/facebookgo/stats	10.000s
+`
+
+const sampleCurrent = `BenchmarkClients/HookClient/BumpAvg       	100000000	        21.0 ns/op	       0 B/op	       0 allocs/op
+BenchmarkClients/HookClient/BumpAvg-4     	100000000	        11.5 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPercentiles/10-4                 	 5000000	       300 ns/op	     336 B/op	       2 allocs/op
+BenchmarkAdded-4                          	 5000000	       200 ns/op
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	results, err := Parse(strings.NewReader(sampleBaseline))
+	ensure.Nil(t, err)
+	// The runs with GOMAXPROCS 1 and 4 are kept apart.
+	ensure.DeepEqual(t, len(results["BenchmarkClients/HookClient/BumpAvg"]), 1)
+	ensure.DeepEqual(t, len(results["BenchmarkClients/HookClient/BumpAvg-4"]), 3)
+	ensure.DeepEqual(t, results.Median("BenchmarkClients/HookClient/BumpAvg-4"), &Result{NsPerOp: 11})
+	ensure.DeepEqual(t, results.Median("BenchmarkPercentiles/10-4"), &Result{
+		NsPerOp:     200,
+		BytesPerOp:  336,
+		AllocsPerOp: 2,
+	})
+	ensure.True(t, results.Median("BenchmarkMissing") == nil)
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+	baseline, err := Parse(strings.NewReader(sampleBaseline))
+	ensure.Nil(t, err)
+	current, err := Parse(strings.NewReader(sampleCurrent))
+	ensure.Nil(t, err)
+
+	byName := map[string]*Comparison{}
+	for _, c := range Compare(baseline, current, 10) {
+		byName[c.Name] = c
+	}
+	ensure.DeepEqual(t, len(byName), 5)
+	ensure.False(t, byName["BenchmarkClients/HookClient/BumpAvg"].Regressed)
+	ensure.DeepEqual(t, byName["BenchmarkClients/HookClient/BumpAvg"].Delta, 5.0)
+	ensure.False(t, byName["BenchmarkClients/HookClient/BumpAvg-4"].Regressed)
+	ensure.True(t, byName["BenchmarkPercentiles/10-4"].Regressed)
+	ensure.DeepEqual(t, byName["BenchmarkPercentiles/10-4"].Delta, 50.0)
+	ensure.True(t, byName["BenchmarkAdded-4"].Old == nil)
+	ensure.True(t, byName["BenchmarkRemoved-4"].New == nil)
+	ensure.False(t, byName["BenchmarkAdded-4"].Regressed)
+}
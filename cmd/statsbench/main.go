@@ -0,0 +1,93 @@
+// Command statsbench compares go test benchmark output against a checked-in
+// baseline and fails if any benchmark regressed beyond a threshold.
+//
+// Usage:
+//
+//	go test -run NONE -bench . -benchmem -count 5 -cpu 1,4 | statsbench -baseline testdata/bench_baseline.txt
+//
+// When a benchmark appears multiple times, as with -count, the median is
+// used. The -N suffix -cpu adds for GOMAXPROCS above 1 is part of the name,
+// so the same -cpu list must be used for both sides, and it keeps the names
+// the same on machines with a different number of CPUs. Benchmarks missing
+// from either side are reported but don't fail.
+// Regenerate the baseline by saving the output of the command above in its
+// place, with "#" comment lines at the top describing the machine, since
+// numbers are only comparable on a similar one. Lines other than benchmark
+// results are ignored.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+func main() {
+	baselinePath := flag.String("baseline", "testdata/bench_baseline.txt", "baseline benchmark output")
+	threshold := flag.Float64("threshold", 10, "percent increase in ns/op, B/op or allocs/op considered a regression")
+	flag.Parse()
+
+	baseline, err := parseFile(*baselinePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var current Results
+	if flag.NArg() > 0 {
+		current, err = parseFile(flag.Arg(0))
+	} else {
+		current, err = Parse(os.Stdin)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	comparisons := Compare(baseline, current, *threshold)
+	if err := Report(os.Stdout, comparisons); err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range comparisons {
+		if c.Regressed {
+			os.Exit(1)
+		}
+	}
+}
+
+func parseFile(name string) (Results, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Report writes a table of the comparisons.
+func Report(w io.Writer, comparisons []*Comparison) error {
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Name < comparisons[j].Name
+	})
+	for _, c := range comparisons {
+		var err error
+		switch {
+		case c.Old == nil:
+			_, err = fmt.Fprintf(w, "%-60s new\n", c.Name)
+		case c.New == nil:
+			_, err = fmt.Fprintf(w, "%-60s missing\n", c.Name)
+		default:
+			status := "ok"
+			if c.Regressed {
+				status = "REGRESSED"
+			}
+			_, err = fmt.Fprintf(w, "%-60s %12.1f -> %12.1f ns/op %+7.1f%%  %6.0f -> %6.0f B/op  %4.0f -> %4.0f allocs/op  %s\n",
+				c.Name, c.Old.NsPerOp, c.New.NsPerOp, c.Delta,
+				c.Old.BytesPerOp, c.New.BytesPerOp, c.Old.AllocsPerOp, c.New.AllocsPerOp, status)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+// stubs declare the parts of the imported packages that generated code uses,
+// so the output can be type-checked without loading the real packages.
+var stubs = map[string]string{
+	"time": `package time
+
+type Duration int64
+`,
+	"github.com/facebookgo/stats": `package stats
+
+import "time"
+
+type Client interface {
+	BumpAvg(key string, val float64, tags ...string)
+	BumpSum(key string, val float64, tags ...string)
+	BumpHistogram(key string, val float64, tags ...string)
+	BumpTime(key string, tags ...string) interface{ End() }
+}
+
+func BumpAvg(c Client, key string, val float64, tags ...string)       {}
+func BumpSum(c Client, key string, val float64, tags ...string)       {}
+func BumpHistogram(c Client, key string, val float64, tags ...string) {}
+func BumpMin(c Client, key string, val float64, tags ...string)       {}
+func BumpMax(c Client, key string, val float64, tags ...string)       {}
+func BumpLast(c Client, key string, val float64, tags ...string)      {}
+
+func BumpTime(c Client, key string, tags ...string) interface{ End() } { return nil }
+
+func RecordDuration(c Client, key string, d time.Duration, tags ...string) {}
+`,
+}
+
+// stubImporter type-checks the stubs on demand.
+type stubImporter struct {
+	fset *token.FileSet
+	pkgs map[string]*types.Package
+}
+
+func (i *stubImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := i.pkgs[path]; ok {
+		return pkg, nil
+	}
+	src, ok := stubs[path]
+	if !ok {
+		return nil, fmt.Errorf("unexpected import %q", path)
+	}
+	pkg, err := i.check(path, src)
+	if err != nil {
+		return nil, err
+	}
+	i.pkgs[path] = pkg
+	return pkg, nil
+}
+
+func (i *stubImporter) check(path, src string) (*types.Package, error) {
+	f, err := parser.ParseFile(i.fset, path+".go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: i}
+	return conf.Check(path, i.fset, []*ast.File{f}, nil)
+}
+
+// typeCheck parses and type-checks generated source.
+func typeCheck(src []byte) error {
+	i := &stubImporter{fset: token.NewFileSet(), pkgs: map[string]*types.Package{}}
+	_, err := i.check("generated", string(src))
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var bumpFuncs = map[string]string{
+	"avg":       "BumpAvg",
+	"sum":       "BumpSum",
+	"histogram": "BumpHistogram",
+	"min":       "BumpMin",
+	"max":       "BumpMax",
+	"last":      "BumpLast",
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by statsgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .NeedsTime}}
+	"time"
+{{end}}
+	"github.com/facebookgo/stats"
+)
+
+// {{.Type}} provides typed methods for bumping metrics. The Client may be nil,
+// in which case the methods are no-ops.
+type {{.Type}} struct {
+	Client stats.Client
+}
+{{range .Methods}}
+{{.Doc}}
+func (m *{{$.Type}}) {{.Signature}} {
+	{{.Body}}
+}
+{{end}}`))
+
+type method struct {
+	Doc       string
+	Signature string
+	Body      string
+}
+
+// Generate returns the formatted Go source for the schema.
+func Generate(s *Schema) ([]byte, error) {
+	data := struct {
+		*Schema
+		NeedsTime bool
+		Methods   []method
+	}{Schema: s}
+
+	for _, m := range s.Metrics {
+		var params, tags []string
+		for _, tag := range m.Tags {
+			p := paramName(tag)
+			params = append(params, p)
+			tags = append(tags, fmt.Sprintf("%s+%s", strconv.Quote(tag+":"), p))
+		}
+		tagParams := ""
+		if len(params) > 0 {
+			tagParams = ", " + strings.Join(params, ", ") + " string"
+		}
+		tagArgs := ""
+		if len(tags) > 0 {
+			tagArgs = ", " + strings.Join(tags, ", ")
+		}
+		key := strconv.Quote(m.Key)
+
+		doc := docComment(m.Name, fmt.Sprintf("bumps the %s %s", m.Type, key), m)
+		if m.Type != "time" {
+			data.Methods = append(data.Methods, method{
+				Doc:       doc,
+				Signature: fmt.Sprintf("%s(val float64%s)", m.Name, tagParams),
+				Body:      fmt.Sprintf("stats.%s(m.Client, %s, val%s)", bumpFuncs[m.Type], key, tagArgs),
+			})
+			continue
+		}
+
+		data.NeedsTime = true
+		data.Methods = append(data.Methods, method{
+			Doc:       docComment(m.Name, fmt.Sprintf("records the duration for %s like a timer", key), m),
+			Signature: fmt.Sprintf("%s(d time.Duration%s)", m.Name, tagParams),
+			Body:      fmt.Sprintf("stats.RecordDuration(m.Client, %s, d%s)", key, tagArgs),
+		}, method{
+			Doc:       docComment("Start"+m.Name, fmt.Sprintf("starts a timer for %s", key), m),
+			Signature: fmt.Sprintf("Start%s(%s) interface{ End() }", m.Name, strings.TrimPrefix(tagParams, ", ")),
+			Body:      fmt.Sprintf("return stats.BumpTime(m.Client, %s%s)", key, tagArgs),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := fileTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("statsgen: generated invalid source: %s", err)
+	}
+	if err := typeCheck(src); err != nil {
+		return nil, fmt.Errorf("statsgen: generated code does not compile: %s", err)
+	}
+	return src, nil
+}
+
+func docComment(name, summary string, m *MetricSchema) string {
+	lines := []string{fmt.Sprintf("// %s %s", name, summary)}
+	if m.Unit != "" {
+		lines[0] += fmt.Sprintf(" in %s", m.Unit)
+	}
+	lines[0] += "."
+	if m.Doc != "" {
+		lines = append(lines, "//")
+		for _, l := range strings.Split(strings.TrimSpace(m.Doc), "\n") {
+			lines = append(lines, strings.TrimRight("// "+l, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+const sampleSchema = `{
+  "type": "ServerMetrics",
+  "metrics": [
+    {"name": "Requests", "key": "requests", "type": "sum", "tags": ["method", "status_code"], "doc": "Requests handled."},
+    {"name": "RPCLatency", "key": "rpc.latency", "type": "time", "tags": ["method"]},
+    {"name": "QueueDepth", "key": "queue.depth", "type": "avg", "unit": "items"}
+  ]
+}`
+
+const sampleOutput = `// Code generated by statsgen. DO NOT EDIT.
+
+package server
+
+import (
+	"time"
+
+	"github.com/facebookgo/stats"
+)
+
+// ServerMetrics provides typed methods for bumping metrics. The Client may be nil,
+// in which case the methods are no-ops.
+type ServerMetrics struct {
+	Client stats.Client
+}
+
+// Requests bumps the sum "requests".
+//
+// Requests handled.
+func (m *ServerMetrics) Requests(val float64, method, statusCode string) {
+	stats.BumpSum(m.Client, "requests", val, "method:"+method, "status_code:"+statusCode)
+}
+
+// RPCLatency records the duration for "rpc.latency" like a timer in ms.
+func (m *ServerMetrics) RPCLatency(d time.Duration, method string) {
+	stats.RecordDuration(m.Client, "rpc.latency", d, "method:"+method)
+}
+
+// StartRPCLatency starts a timer for "rpc.latency" in ms.
+func (m *ServerMetrics) StartRPCLatency(method string) interface{ End() } {
+	return stats.BumpTime(m.Client, "rpc.latency", "method:"+method)
+}
+
+// QueueDepth bumps the avg "queue.depth" in items.
+func (m *ServerMetrics) QueueDepth(val float64) {
+	stats.BumpAvg(m.Client, "queue.depth", val)
+}
+`
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+	s, err := ReadSchema(strings.NewReader(sampleSchema), "server")
+	ensure.Nil(t, err)
+	src, err := Generate(s)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, string(src), sampleOutput)
+}
+
+func TestReadSchemaErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Schema string
+		Error  string
+	}{
+		{`{"metrics": [{"name": "foo", "key": "k", "type": "sum"}]}`, `invalid metric name: "foo"`},
+		{`{"metrics": [{"name": "Foo", "type": "sum"}]}`, `missing key for metric: Foo`},
+		{`{"metrics": [{"name": "Foo", "key": "k", "type": "gauge"}]}`, `invalid type for metric Foo: "gauge"`},
+		{`{"metrics": [{"name": "Foo", "key": "k", "type": "time", "unit": "h"}]}`, `invalid time unit for metric Foo: "h"`},
+		{`{"metrics": [{"name": "Foo", "key": "k", "type": "time", "unit": "s"}]}`, `invalid time unit for metric Foo: "s"`},
+		{`{"metrics": [{"name": "Foo", "key": "k", "type": "sum", "tags": ["a_b", "aB"]}]}`, `invalid tag for metric Foo: "aB"`},
+		{`{"metrics": [{"name": "Foo", "key": "k", "type": "sum"}, {"name": "Foo", "key": "j", "type": "avg"}]}`, `duplicate metric name: Foo`},
+		{`{"metrics": [{"name": "Client", "key": "k", "type": "sum"}]}`, `reserved metric name: Client`},
+		{`{"metrics": [{"name": "Client", "key": "k", "type": "time"}]}`, `reserved metric name: Client`},
+		{`{"metric": []}`, `invalid schema`},
+	}
+	for _, c := range cases {
+		_, err := ReadSchema(strings.NewReader(c.Schema), "pkg")
+		ensure.Err(t, err, regexp.MustCompile(regexp.QuoteMeta(c.Error)))
+	}
+}
+
+func TestGenerateTypeCheck(t *testing.T) {
+	t.Parallel()
+	s := &Schema{
+		Package: "pkg",
+		Type:    "Metrics",
+		Metrics: []*MetricSchema{{Name: "Client", Key: "k", Type: "sum"}},
+	}
+	_, err := Generate(s)
+	ensure.Err(t, err, regexp.MustCompile("generated code does not compile: .*Client"))
+}
+
+// The stubs used to type-check generated code must match the real packages.
+var (
+	_ func(stats.Client, string, float64, ...string)           = stats.BumpAvg
+	_ func(stats.Client, string, float64, ...string)           = stats.BumpSum
+	_ func(stats.Client, string, float64, ...string)           = stats.BumpHistogram
+	_ func(stats.Client, string, float64, ...string)           = stats.BumpMin
+	_ func(stats.Client, string, float64, ...string)           = stats.BumpMax
+	_ func(stats.Client, string, float64, ...string)           = stats.BumpLast
+	_ func(stats.Client, string, ...string) interface{ End() } = stats.BumpTime
+	_ func(stats.Client, string, time.Duration, ...string)     = stats.RecordDuration
+)
+
+func TestParamName(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, paramName("method"), "method")
+	ensure.DeepEqual(t, paramName("status_code"), "statusCode")
+	ensure.DeepEqual(t, paramName("Host-Name"), "hostName")
+	ensure.DeepEqual(t, paramName("type"), "typeTag")
+	ensure.DeepEqual(t, paramName("val"), "valTag")
+	ensure.DeepEqual(t, paramName("1xx"), "")
+	ensure.DeepEqual(t, paramName("--"), "")
+}
@@ -0,0 +1,59 @@
+// Command statsgen generates a struct with typed methods for the metrics
+// described in a JSON schema. The generated methods call through a
+// stats.Client, so keys can't be mistyped and tags are always emitted in the
+// same order.
+//
+// A schema looks like:
+//
+//	{
+//	  "type": "ServerMetrics",
+//	  "metrics": [
+//	    {"name": "Requests", "key": "requests", "type": "sum", "tags": ["method"]},
+//	    {"name": "RPCLatency", "key": "rpc.latency", "type": "time", "unit": "ms", "tags": ["method"]}
+//	  ]
+//	}
+//
+// Tags are emitted as "key:value". It is intended to be used with go
+// generate:
+//
+//	//go:generate statsgen -schema metrics.json -o metrics_gen.go
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "path to the JSON schema")
+	outPath := flag.String("o", "", "output file, defaults to stdout")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package name of the generated file")
+	flag.Parse()
+
+	if *schemaPath == "" {
+		log.Fatal("statsgen: -schema is required")
+	}
+	f, err := os.Open(*schemaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	schema, err := ReadSchema(f, *pkg)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := Generate(schema)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *outPath == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*outPath, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"unicode"
+)
+
+// Schema describes a set of metrics to generate a typed struct for.
+type Schema struct {
+	// Package is the package name of the generated file. It defaults to
+	// $GOPACKAGE which is set by go generate.
+	Package string `json:"package"`
+
+	// Type is the name of the generated struct. It defaults to Metrics.
+	Type string `json:"type"`
+
+	// Metrics are the metrics which get a typed method each.
+	Metrics []*MetricSchema `json:"metrics"`
+}
+
+// MetricSchema describes a single metric.
+type MetricSchema struct {
+	// Name is the exported Go method name, for example RPCLatency.
+	Name string `json:"name"`
+
+	// Key is the key passed to the stats.Client.
+	Key string `json:"key"`
+
+	// Type is one of avg, sum, histogram, min, max, last or time.
+	Type string `json:"type"`
+
+	// Unit is the unit of the value. Time metrics are always recorded in ms
+	// like stats.Stopper, so it must be ms or empty, otherwise it is only
+	// documentation.
+	Unit string `json:"unit"`
+
+	// Tags are the tag keys. Each becomes a string parameter of the
+	// generated method, and the tags are always emitted in this order.
+	Tags []string `json:"tags"`
+
+	// Doc is included in the doc comment of the generated method.
+	Doc string `json:"doc"`
+}
+
+// reserved are the names of the generated struct's fields, which the methods
+// can't share.
+var reserved = map[string]bool{"Client": true}
+
+// ReadSchema decodes and validates a schema.
+func ReadSchema(r io.Reader, defaultPackage string) (*Schema, error) {
+	var s Schema
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&s); err != nil {
+		return nil, fmt.Errorf("statsgen: invalid schema: %s", err)
+	}
+	if s.Package == "" {
+		s.Package = defaultPackage
+	}
+	if s.Type == "" {
+		s.Type = "Metrics"
+	}
+	if !token.IsIdentifier(s.Package) {
+		return nil, fmt.Errorf("statsgen: invalid package name: %q", s.Package)
+	}
+	if !token.IsIdentifier(s.Type) {
+		return nil, fmt.Errorf("statsgen: invalid type name: %q", s.Type)
+	}
+
+	names := map[string]bool{}
+	for _, m := range s.Metrics {
+		if !token.IsExported(m.Name) || !token.IsIdentifier(m.Name) {
+			return nil, fmt.Errorf("statsgen: invalid metric name: %q", m.Name)
+		}
+		if reserved[m.Name] {
+			return nil, fmt.Errorf("statsgen: reserved metric name: %s", m.Name)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("statsgen: duplicate metric name: %s", m.Name)
+		}
+		names[m.Name] = true
+		if m.Key == "" {
+			return nil, fmt.Errorf("statsgen: missing key for metric: %s", m.Name)
+		}
+		switch m.Type {
+		case "avg", "sum", "histogram", "min", "max", "last":
+		case "time":
+			if m.Unit == "" {
+				m.Unit = "ms"
+			}
+			if m.Unit != "ms" {
+				return nil, fmt.Errorf("statsgen: invalid time unit for metric %s: %q", m.Name, m.Unit)
+			}
+			// The Start method shares the namespace.
+			if names["Start"+m.Name] {
+				return nil, fmt.Errorf("statsgen: duplicate metric name: Start%s", m.Name)
+			}
+			names["Start"+m.Name] = true
+		default:
+			return nil, fmt.Errorf("statsgen: invalid type for metric %s: %q", m.Name, m.Type)
+		}
+		params := map[string]bool{}
+		for _, tag := range m.Tags {
+			p := paramName(tag)
+			if p == "" || params[p] {
+				return nil, fmt.Errorf("statsgen: invalid tag for metric %s: %q", m.Name, tag)
+			}
+			params[p] = true
+		}
+	}
+	return &s, nil
+}
+
+// paramName converts a tag key like rpc_method into a parameter name like
+// rpcMethod. It returns an empty string if no valid name can be made.
+func paramName(tag string) string {
+	var out []rune
+	upper := false
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = len(out) > 0
+			continue
+		}
+		if len(out) == 0 {
+			if unicode.IsDigit(r) {
+				return ""
+			}
+			r = unicode.ToLower(r)
+		} else if upper {
+			r = unicode.ToUpper(r)
+		}
+		upper = false
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	name := string(out)
+	// Avoid keywords and the names used by the generated code.
+	if !token.IsIdentifier(name) || name == "m" || name == "val" || name == "d" || name == "time" || name == "stats" {
+		name += "Tag"
+	}
+	return name
+}
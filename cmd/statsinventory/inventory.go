@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Metric describes a single key emitted through a stats.Client.
+type Metric struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"`
+	Dynamic     bool     `json:"dynamic,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	DynamicTags bool     `json:"dynamic_tags,omitempty"`
+	Locations   []string `json:"locations"`
+	Docs        []string `json:"docs,omitempty"`
+}
+
+// bumpTypes maps the Client method names to the inventory type. BumpTime
+// isn't a type of its own, it reports a sum for key.total and a histogram for
+// key like stats.Stopper.
+var bumpTypes = map[string]string{
+	"BumpAvg":       "avg",
+	"BumpSum":       "sum",
+	"BumpHistogram": "histogram",
+	"BumpMin":       "min",
+	"BumpMax":       "max",
+	"BumpLast":      "last",
+	"BumpTime":      "time",
+}
+
+// binding records the prefixes added by a client wrapping another: a
+// PrefixClient with constant prefixes, or a ScopedClient with a constant
+// name. The wrapped client is resolved when keys are expanded, since it may
+// be bound in another package.
+type binding struct {
+	prefixes []string
+	inner    ast.Expr
+	info     *types.Info
+}
+
+// Scanner finds stats.Client usage in the Go packages under a directory.
+type Scanner struct {
+	// Root is the directory to scan. It must be inside a module, or a GOPATH
+	// workspace when modules are off.
+	Root string
+
+	// StatsPath is the import path of the stats package. The package and the
+	// packages under it, such as clienttest, are skipped.
+	StatsPath string
+
+	// Tests includes _test.go files when true.
+	Tests bool
+
+	root     string
+	bindings map[types.Object][]*binding
+	metrics  map[string]*Metric
+}
+
+// Scan type checks the packages and returns the sorted inventory.
+func (s *Scanner) Scan() ([]*Metric, error) {
+	root, err := filepath.Abs(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	s.root = root
+	s.bindings = map[types.Object][]*binding{}
+	s.metrics = map[string]*Metric{}
+
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:   root,
+		Tests: s.Tests,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, err
+	}
+	var scanned []*packages.Package
+	for _, pkg := range pkgs {
+		if s.skip(pkg) {
+			continue
+		}
+		if len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("statsinventory: %s", pkg.Errors[0])
+		}
+		scanned = append(scanned, pkg)
+	}
+	for _, pkg := range scanned {
+		s.collectBindings(pkg)
+	}
+	for _, pkg := range scanned {
+		client := s.clientInterface(pkg)
+		for _, f := range pkg.Syntax {
+			ast.Inspect(f, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok {
+					s.bump(pkg, f, client, call)
+				}
+				return true
+			})
+		}
+	}
+
+	var metrics []*Metric
+	for _, m := range s.metrics {
+		sort.Strings(m.Tags)
+		sortLocations(m.Locations)
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Key != metrics[j].Key {
+			return metrics[i].Key < metrics[j].Key
+		}
+		return metrics[i].Type < metrics[j].Type
+	})
+	return metrics, nil
+}
+
+// skip reports if the package is the stats package or under it, whose calls
+// forward keys from their callers or are test fixtures, or the generated test
+// main package.
+func (s *Scanner) skip(pkg *packages.Package) bool {
+	return pkg.PkgPath == s.StatsPath ||
+		strings.HasPrefix(pkg.PkgPath, s.StatsPath+"/") ||
+		strings.HasSuffix(pkg.PkgPath, ".test")
+}
+
+// clientInterface returns the stats.Client interface as imported by the
+// package, or nil if the package doesn't import it.
+func (s *Scanner) clientInterface(pkg *packages.Package) *types.Interface {
+	for _, imp := range pkg.Types.Imports() {
+		if imp.Path() != s.StatsPath {
+			continue
+		}
+		if obj, ok := imp.Scope().Lookup("Client").(*types.TypeName); ok {
+			if iface, ok := obj.Type().Underlying().(*types.Interface); ok {
+				return iface
+			}
+		}
+	}
+	return nil
+}
+
+// sortLocations sorts file:line locations by file, then by line number.
+func sortLocations(locations []string) {
+	split := func(l string) (string, int) {
+		i := strings.LastIndexByte(l, ':')
+		line, _ := strconv.Atoi(l[i+1:])
+		return l[:i], line
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		fi, li := split(locations[i])
+		fj, lj := split(locations[j])
+		if fi != fj {
+			return fi < fj
+		}
+		return li < lj
+	})
+}
+
+// collectBindings finds every variable and field assigned a PrefixClient or
+// a ScopedClient.
+func (s *Scanner) collectBindings(pkg *packages.Package) {
+	info := pkg.TypesInfo
+	for _, f := range pkg.Syntax {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if len(n.Lhs) == len(n.Rhs) {
+					for i, rhs := range n.Rhs {
+						s.bind(info, objectOf(info, n.Lhs[i]), rhs)
+					}
+				}
+			case *ast.ValueSpec:
+				if len(n.Names) == len(n.Values) {
+					for i, v := range n.Values {
+						s.bind(info, info.Defs[n.Names[i]], v)
+					}
+				}
+			case *ast.KeyValueExpr:
+				// Only struct literal keys resolve to a field.
+				if key, ok := n.Key.(*ast.Ident); ok {
+					if field, ok := info.Uses[key].(*types.Var); ok && field.IsField() {
+						s.bind(info, field, n.Value)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+func (s *Scanner) bind(info *types.Info, obj types.Object, e ast.Expr) {
+	if b := s.wrapper(info, e); obj != nil && b != nil {
+		s.bindings[obj] = append(s.bindings[obj], b)
+	}
+}
+
+// wrapper returns the binding for a call wrapping a client, which is one of
+// stats.PrefixClient(prefixes, c), stats.Scope(c, name, ...) or
+// scoped.Scope(name, ...), or nil for any other expression.
+func (s *Scanner) wrapper(info *types.Info, e ast.Expr) *binding {
+	call, ok := ast.Unparen(e).(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	scope := func(name, inner ast.Expr) *binding {
+		v, ok := constString(info, name)
+		if !ok {
+			return nil
+		}
+		if v != "" {
+			v += "."
+		}
+		return &binding{prefixes: []string{v}, inner: inner, info: info}
+	}
+	switch {
+	case s.isStatsFunc(info, call.Fun, "PrefixClient") && len(call.Args) == 2:
+		lit, ok := ast.Unparen(call.Args[0]).(*ast.CompositeLit)
+		if !ok {
+			return nil
+		}
+		b := &binding{inner: call.Args[1], info: info}
+		for _, elt := range lit.Elts {
+			v, ok := constString(info, elt)
+			if !ok {
+				return nil
+			}
+			b.prefixes = append(b.prefixes, v)
+		}
+		return b
+	case s.isStatsFunc(info, call.Fun, "Scope") && len(call.Args) >= 2:
+		return scope(call.Args[1], call.Args[0])
+	case s.isScopeMethod(info, call.Fun) && len(call.Args) >= 1:
+		return scope(call.Args[0], call.Fun.(*ast.SelectorExpr).X)
+	}
+	return nil
+}
+
+// prefixes returns the full set of prefixes applied to keys bumped on the
+// client e, following wrapping clients through the variables and fields they
+// are assigned to.
+func (s *Scanner) prefixes(info *types.Info, e ast.Expr, depth int) []string {
+	if depth > 8 {
+		return []string{""}
+	}
+	bindings := s.bindings[objectOf(info, e)]
+	if b := s.wrapper(info, e); b != nil {
+		bindings = []*binding{b}
+	}
+	if len(bindings) == 0 {
+		return []string{""}
+	}
+	var result []string
+	for _, b := range bindings {
+		for _, inner := range s.prefixes(b.info, b.inner, depth+1) {
+			for _, p := range b.prefixes {
+				result = appendUnique(result, inner+p)
+			}
+		}
+	}
+	return result
+}
+
+func (s *Scanner) bump(pkg *packages.Package, f *ast.File, client *types.Interface, call *ast.CallExpr) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || client == nil {
+		return
+	}
+	typ, ok := bumpTypes[sel.Sel.Name]
+	if !ok {
+		return
+	}
+
+	// Either the method c.BumpAvg(key, ...) on a stats.Client, or the
+	// package helper stats.BumpAvg(c, key, ...).
+	info := pkg.TypesInfo
+	clientExpr := sel.X
+	args := call.Args
+	if selection, ok := info.Selections[sel]; ok {
+		if selection.Kind() != types.MethodVal || !implements(selection.Recv(), client) {
+			return
+		}
+	} else if s.isStatsFunc(info, sel, sel.Sel.Name) {
+		if len(args) == 0 {
+			return
+		}
+		clientExpr, args = args[0], args[1:]
+	} else {
+		return
+	}
+	if len(args) == 0 {
+		return
+	}
+	tagStart := 2
+	if typ == "time" {
+		tagStart = 1
+	}
+
+	keyExpr := args[0]
+	key, constant := constString(info, keyExpr)
+	if !constant {
+		key = types.ExprString(keyExpr)
+	}
+
+	var tags []string
+	var dynamicTags bool
+	if len(args) > tagStart {
+		for _, arg := range args[tagStart:] {
+			if v, ok := constString(info, arg); ok && !call.Ellipsis.IsValid() {
+				tags = append(tags, v)
+			} else {
+				dynamicTags = true
+			}
+		}
+	}
+
+	pos := pkg.Fset.Position(call.Pos())
+	rel, err := filepath.Rel(s.root, pos.Filename)
+	if err != nil {
+		rel = pos.Filename
+	}
+	location := fmt.Sprintf("%s:%d", filepath.ToSlash(rel), pos.Line)
+	doc := docFor(pkg.Fset, f, pos.Line)
+
+	type series struct{ key, typ string }
+	all := []series{{key, typ}}
+	if typ == "time" {
+		total := key + ".total"
+		if !constant {
+			total = key + ` + ".total"`
+		}
+		all = []series{{total, "sum"}, {key, "histogram"}}
+	}
+
+	prefixes := []string{""}
+	if constant {
+		prefixes = s.prefixes(info, clientExpr, 0)
+	}
+	for _, prefix := range prefixes {
+		for _, se := range all {
+			full := prefix + se.key
+			id := full + "\x00" + se.typ
+			m, ok := s.metrics[id]
+			if !ok {
+				m = &Metric{Key: full, Type: se.typ, Dynamic: !constant}
+				s.metrics[id] = m
+			}
+			m.Tags = appendUnique(m.Tags, tags...)
+			m.DynamicTags = m.DynamicTags || dynamicTags
+			m.Locations = appendUnique(m.Locations, location)
+			if doc != "" {
+				m.Docs = appendUnique(m.Docs, doc)
+			}
+		}
+	}
+}
+
+// implements reports if values of type t, or pointers to them, implement
+// the client interface.
+func implements(t types.Type, client *types.Interface) bool {
+	if types.Implements(t, client) {
+		return true
+	}
+	_, isPtr := t.Underlying().(*types.Pointer)
+	return !isPtr && !types.IsInterface(t) && types.Implements(types.NewPointer(t), client)
+}
+
+// docFor returns the comment ending on the line before the call, or trailing
+// it on the same line.
+func docFor(fset *token.FileSet, f *ast.File, line int) string {
+	for _, cg := range f.Comments {
+		start := fset.Position(cg.Pos()).Line
+		end := fset.Position(cg.End()).Line
+		if end == line-1 || (start == line && end == line) {
+			return strings.Join(strings.Fields(cg.Text()), " ")
+		}
+	}
+	return ""
+}
+
+// constString returns the value of a constant string expression, including
+// constants declared in other packages.
+func constString(info *types.Info, e ast.Expr) (string, bool) {
+	tv, ok := info.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
+// isStatsFunc reports if e refers to the named function in the stats package.
+func (s *Scanner) isStatsFunc(info *types.Info, e ast.Expr, name string) bool {
+	var id *ast.Ident
+	switch e := e.(type) {
+	case *ast.Ident:
+		id = e
+	case *ast.SelectorExpr:
+		id = e.Sel
+	default:
+		return false
+	}
+	fn, ok := info.Uses[id].(*types.Func)
+	return ok && fn.Name() == name && fn.Pkg() != nil && fn.Pkg().Path() == s.StatsPath &&
+		fn.Type().(*types.Signature).Recv() == nil
+}
+
+// isScopeMethod reports if e refers to the Scope method of the stats
+// ScopedClient.
+func (s *Scanner) isScopeMethod(info *types.Info, e ast.Expr) bool {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	selection, ok := info.Selections[sel]
+	if !ok || selection.Kind() != types.MethodVal {
+		return false
+	}
+	fn := selection.Obj().(*types.Func)
+	return fn.Name() == "Scope" && fn.Pkg() != nil && fn.Pkg().Path() == s.StatsPath
+}
+
+// objectOf returns the variable or field an expression refers to, which is
+// how clients are matched to their bindings.
+func objectOf(info *types.Info, e ast.Expr) types.Object {
+	switch e := e.(type) {
+	case *ast.Ident:
+		if obj := info.Uses[e]; obj != nil {
+			return obj
+		}
+		return info.Defs[e]
+	case *ast.SelectorExpr:
+		if selection, ok := info.Selections[e]; ok {
+			return selection.Obj()
+		}
+		return info.Uses[e.Sel]
+	case *ast.StarExpr:
+		return objectOf(info, e.X)
+	case *ast.ParenExpr:
+		return objectOf(info, e.X)
+	}
+	return nil
+}
+
+func appendUnique(list []string, vs ...string) []string {
+outer:
+	for _, v := range vs {
+		for _, existing := range list {
+			if existing == v {
+				continue outer
+			}
+		}
+		list = append(list, v)
+	}
+	return list
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+)
+
+const sampleMod = `module example.com/svc
+`
+
+const sampleKeys = `package keys
+
+const Prefix = "svc."
+`
+
+const sampleServer = `package server
+
+import (
+	"example.com/svc/keys"
+	"example.com/svc/stats"
+)
+
+const requests = "requests"
+
+type Server struct {
+	Stats stats.Client
+}
+
+func New(c stats.Client) *Server {
+	return &Server{
+		Stats: stats.PrefixClient([]string{keys.Prefix, "all."}, c),
+	}
+}
+
+func (s *Server) Handle(method string) {
+	// Number of requests handled.
+	s.Stats.BumpSum(requests, 1, "method")
+	defer s.Stats.BumpTime("handle." + "latency").End()
+	s.Stats.BumpAvg(method, 1)
+}
+
+func Helper(c stats.Client, tags ...string) {
+	stats.BumpHistogram(c, "helper.size", 3, tags...) // Payload size.
+}
+
+func Users(c stats.Client) {
+	db := stats.Scope(c, "db")
+	users := db.Scope("users", "shard:1")
+	users.BumpSum("reads", 1)
+	stats.Scope(db, "").BumpAvg("pool.size", 1)
+}
+`
+
+// sampleOther uses names matching the server's without being stats clients.
+const sampleOther = `package other
+
+import "example.com/svc/stats"
+
+type ledger struct{}
+
+func (ledger) BumpSum(key string, val float64, tags ...string) {}
+
+func Record(Stats stats.Client) {
+	var l ledger
+	l.BumpSum("not.a.metric", 1)
+	stats.BumpLast(Stats, "other.last", 1)
+}
+`
+
+// sampleStats is a stand in for the stats package, whose forwarding methods
+// aren't reported.
+const sampleStats = `package stats
+
+type Client interface {
+	BumpAvg(key string, val float64, tags ...string)
+	BumpSum(key string, val float64, tags ...string)
+	BumpHistogram(key string, val float64, tags ...string)
+	BumpTime(key string, tags ...string) interface{ End() }
+}
+
+type lastClient interface {
+	BumpLast(key string, val float64, tags ...string)
+}
+
+func BumpHistogram(c Client, key string, val float64, tags ...string) {
+	c.BumpHistogram(key, val, tags...)
+}
+
+func BumpLast(c Client, key string, val float64, tags ...string) {
+	c.(lastClient).BumpLast(key, val, tags...)
+}
+
+type prefixClient struct {
+	prefixes []string
+	client   Client
+}
+
+func PrefixClient(prefixes []string, client Client) Client {
+	return &prefixClient{prefixes: prefixes, client: client}
+}
+
+func (p *prefixClient) BumpAvg(key string, val float64, tags ...string) {
+	for _, prefix := range p.prefixes {
+		p.client.BumpAvg(prefix+key, val, tags...)
+	}
+}
+
+func (p *prefixClient) BumpSum(key string, val float64, tags ...string) {
+	for _, prefix := range p.prefixes {
+		p.client.BumpSum(prefix+key, val, tags...)
+	}
+}
+
+func (p *prefixClient) BumpHistogram(key string, val float64, tags ...string) {
+	for _, prefix := range p.prefixes {
+		p.client.BumpHistogram(prefix+key, val, tags...)
+	}
+}
+
+func (p *prefixClient) BumpTime(key string, tags ...string) interface{ End() } {
+	return p.client.BumpTime(p.prefixes[0]+key, tags...)
+}
+
+type ScopedClient struct {
+	client Client
+	prefix string
+}
+
+func Scope(c Client, name string, tags ...string) *ScopedClient {
+	return &ScopedClient{client: c, prefix: name + "."}
+}
+
+func (s *ScopedClient) Scope(name string, tags ...string) *ScopedClient {
+	return &ScopedClient{client: s.client, prefix: s.prefix + name + "."}
+}
+
+func (s *ScopedClient) BumpAvg(key string, val float64, tags ...string) {
+	s.client.BumpAvg(s.prefix+key, val, tags...)
+}
+
+func (s *ScopedClient) BumpSum(key string, val float64, tags ...string) {
+	s.client.BumpSum(s.prefix+key, val, tags...)
+}
+
+func (s *ScopedClient) BumpHistogram(key string, val float64, tags ...string) {
+	s.client.BumpHistogram(s.prefix+key, val, tags...)
+}
+
+func (s *ScopedClient) BumpTime(key string, tags ...string) interface{ End() } {
+	return s.client.BumpTime(s.prefix+key, tags...)
+}
+`
+
+const sampleClienttest = `package clienttest
+
+import "example.com/svc/stats"
+
+func Run(c stats.Client) {
+	c.BumpSum("clienttest.sum", 1)
+}
+`
+
+func writeSample(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":                     sampleMod,
+		"keys/keys.go":               sampleKeys,
+		"server/server.go":           sampleServer,
+		"other/other.go":             sampleOther,
+		"stats/stats.go":             sampleStats,
+		"stats/clienttest/client.go": sampleClienttest,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		ensure.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		ensure.Nil(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+	s := &Scanner{
+		Root:      writeSample(t),
+		StatsPath: "example.com/svc/stats",
+	}
+	metrics, err := s.Scan()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, metrics, []*Metric{
+		{
+			Key:       "all.handle.latency",
+			Type:      "histogram",
+			Locations: []string{"server/server.go:23"},
+		},
+		{
+			Key:       "all.handle.latency.total",
+			Type:      "sum",
+			Locations: []string{"server/server.go:23"},
+		},
+		{
+			Key:       "all.requests",
+			Type:      "sum",
+			Tags:      []string{"method"},
+			Locations: []string{"server/server.go:22"},
+			Docs:      []string{"Number of requests handled."},
+		},
+		{
+			Key:       "db.pool.size",
+			Type:      "avg",
+			Locations: []string{"server/server.go:35"},
+		},
+		{
+			Key:       "db.users.reads",
+			Type:      "sum",
+			Locations: []string{"server/server.go:34"},
+		},
+		{
+			Key:         "helper.size",
+			Type:        "histogram",
+			DynamicTags: true,
+			Locations:   []string{"server/server.go:28"},
+			Docs:        []string{"Payload size."},
+		},
+		{
+			Key:       "method",
+			Type:      "avg",
+			Dynamic:   true,
+			Locations: []string{"server/server.go:24"},
+		},
+		{
+			Key:       "other.last",
+			Type:      "last",
+			Locations: []string{"other/other.go:12"},
+		},
+		{
+			Key:       "svc.handle.latency",
+			Type:      "histogram",
+			Locations: []string{"server/server.go:23"},
+		},
+		{
+			Key:       "svc.handle.latency.total",
+			Type:      "sum",
+			Locations: []string{"server/server.go:23"},
+		},
+		{
+			Key:       "svc.requests",
+			Type:      "sum",
+			Tags:      []string{"method"},
+			Locations: []string{"server/server.go:22"},
+			Docs:      []string{"Number of requests handled."},
+		},
+	})
+}
+
+func TestSortLocations(t *testing.T) {
+	t.Parallel()
+	locations := []string{"x.go:10", "x.go:9", "a/x.go:12", "a.go:2"}
+	sortLocations(locations)
+	ensure.DeepEqual(t, locations, []string{"a.go:2", "a/x.go:12", "x.go:9", "x.go:10"})
+}
+
+func TestWriteOutputs(t *testing.T) {
+	t.Parallel()
+	metrics := []*Metric{{
+		Key:       "a|b",
+		Type:      "sum",
+		Tags:      []string{"x"},
+		Locations: []string{"a.go:1", "b.go:2"},
+	}}
+
+	var md bytes.Buffer
+	ensure.Nil(t, WriteMarkdown(&md, metrics))
+	ensure.DeepEqual(t, md.String(),
+		"| Key | Type | Tags | Locations | Description |\n"+
+			"| --- | --- | --- | --- | --- |\n"+
+			"| `a\\|b` | sum | x | a.go:1<br>b.go:2 |  |\n")
+
+	var js bytes.Buffer
+	ensure.Nil(t, WriteJSON(&js, metrics))
+	var decoded []*Metric
+	ensure.Nil(t, json.Unmarshal(js.Bytes(), &decoded))
+	ensure.DeepEqual(t, decoded, metrics)
+}
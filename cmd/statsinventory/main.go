@@ -0,0 +1,117 @@
+// Command statsinventory scans Go source for stats.Client usage and produces
+// an inventory of every key emitted, with its type, tags, source locations
+// and doc comments.
+//
+// The packages are loaded and type checked, so only calls on values
+// implementing stats.Client, and the stats package helpers taking one, are
+// reported. The stats package itself and the packages under it, such as
+// clienttest, are skipped. Keys are resolved from constant expressions,
+// including constants from other packages. Variables and fields assigned the
+// result of stats.PrefixClient with constant prefixes, or of stats.Scope and
+// ScopedClient.Scope with a constant name, have their keys expanded for each
+// prefix. Keys which can't be resolved are reported as dynamic with their
+// source expression. BumpTime is reported as the sum for key.total and the
+// histogram for key it records.
+//
+// Usage:
+//
+//	statsinventory -root . -md metrics.md -json metrics.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	root := flag.String("root", ".", "directory to scan")
+	statsPath := flag.String("stats", "github.com/facebookgo/stats", "import path of the stats package")
+	tests := flag.Bool("tests", false, "include _test.go files")
+	mdPath := flag.String("md", "", "write the Markdown inventory to this file")
+	jsonPath := flag.String("json", "", "write the JSON inventory to this file")
+	flag.Parse()
+
+	s := &Scanner{
+		Root:      *root,
+		StatsPath: *statsPath,
+		Tests:     *tests,
+	}
+	metrics, err := s.Scan()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *mdPath == "" && *jsonPath == "" {
+		if err := WriteMarkdown(os.Stdout, metrics); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *mdPath != "" {
+		if err := writeFile(*mdPath, metrics, WriteMarkdown); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *jsonPath != "" {
+		if err := writeFile(*jsonPath, metrics, WriteJSON); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func writeFile(name string, metrics []*Metric, write func(io.Writer, []*Metric) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f, metrics); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteJSON writes the inventory as an indented JSON array.
+func WriteJSON(w io.Writer, metrics []*Metric) error {
+	if metrics == nil {
+		metrics = []*Metric{}
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(metrics)
+}
+
+// WriteMarkdown writes the inventory as a Markdown table.
+func WriteMarkdown(w io.Writer, metrics []*Metric) error {
+	var b strings.Builder
+	b.WriteString("| Key | Type | Tags | Locations | Description |\n")
+	b.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, m := range metrics {
+		key := "`" + m.Key + "`"
+		if m.Dynamic {
+			key += " (dynamic)"
+		}
+		tags := m.Tags
+		if m.DynamicTags {
+			tags = append(append([]string{}, tags...), "(dynamic)")
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n",
+			markdownCell(key),
+			m.Type,
+			markdownCell(strings.Join(tags, ", ")),
+			markdownCell(strings.Join(m.Locations, "<br>")),
+			markdownCell(strings.Join(m.Docs, "<br>")),
+		)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func markdownCell(s string) string {
+	return strings.Replace(s, "|", `\|`, -1)
+}
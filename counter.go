@@ -1,6 +1,10 @@
 package stats
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 // Type is the type of aggregation of apply
 type Type int
@@ -9,8 +13,28 @@ const (
 	AggregateAvg Type = iota
 	AggregateSum
 	AggregateHistogram
+	AggregateMin
+	AggregateMax
+	AggregateLast
 )
 
+var typeNames = map[Type]string{
+	AggregateAvg:       "avg",
+	AggregateSum:       "sum",
+	AggregateHistogram: "histogram",
+	AggregateMin:       "min",
+	AggregateMax:       "max",
+	AggregateLast:      "last",
+}
+
+// String returns the lowercase name of the aggregation type
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
 var (
 	// HistogramPercentiles is used to determine which percentiles to return for
 	// SimpleCounter.Aggregate
@@ -23,25 +47,57 @@ var (
 	// MinSamplesForPercentiles is used by SimpleCounter.Aggregate to determine
 	// what the minimum number of samples is required for percentile analysis
 	MinSamplesForPercentiles = 10
+
+	// InvalidValuePolicy determines how NaN and ±Inf values are handled by
+	// Average, Sum, Percentiles, and the counters and clients whose Policy is
+	// ValueDefault. It is read without synchronization, so it must only be set
+	// during initialization; use the Policy fields to vary it
+	InvalidValuePolicy = ValueKeep
 )
 
 // Aggregates can be used to merge counters together. This is not goroutine safe
 type Aggregates map[string]Counter
 
-// Add adds the counter for aggregation. This is not goroutine safe
+// Add adds the counter for aggregation. Counters implementing Merger are
+// merged with Merge, otherwise the values are added to the existing
+// counter. Values are appended in the order counters are added, so for
+// AggregateLast the counter added last wins. This is not goroutine safe
 func (a Aggregates) Add(c Counter) error {
 	key := c.FullKey()
-	if counter, ok := a[key]; ok {
-		if counter.GetType() != c.GetType() {
-			return fmt.Errorf("stats: mismatched aggregation type for: %s", key)
+	counter, ok := a[key]
+	if !ok {
+		a[key] = c
+		return nil
+	}
+	if counter.GetType() != c.GetType() {
+		return fmt.Errorf("stats: mismatched aggregation type for: %s", key)
+	}
+	if m, ok := counter.(Merger); ok {
+		return m.Merge(c)
+	}
+	if m, ok := c.(Merger); ok {
+		if err := m.Merge(counter); err != nil {
+			return err
 		}
-		counter.AddValues(c.GetValues()...)
-	} else {
 		a[key] = c
+		return nil
+	}
+	counter.AddValues(c.GetValues()...)
+	if s, ok := counter.(*SimpleCounter); ok {
+		if o, ok := c.(*SimpleCounter); ok {
+			s.Invalid += o.Invalid
+		}
 	}
 	return nil
 }
 
+// Merger is implemented by counters whose values alone can't be merged,
+// such as samples which stand for more values than they hold
+type Merger interface {
+	// Merge merges the other counter into this one
+	Merge(other Counter) error
+}
+
 // Counter is the interface used by Aggregates to merge counters together
 type Counter interface {
 	// FullKey is used to uniquely identify the counter
@@ -62,13 +118,39 @@ type SimpleCounter struct {
 	Key    string
 	Values []float64
 	Type   Type
+	Tags   []string
+
+	// Policy is applied to the Values by Aggregate
+	Policy ValuePolicy
+
+	// Invalid is the number of invalid values already dropped from Values,
+	// such as by an Aggregator. It is added to the count reported by
+	// Aggregate under ValueCount
+	Invalid int
 }
 
-// FullKey is part of the Counter interace
+// FullKey is part of the Counter interace. It is the Key, followed by the
+// sorted Tags in braces if there are any, so series of the same key with
+// different tags are aggregated separately
 func (s *SimpleCounter) FullKey() string {
+	if len(s.Tags) == 0 {
+		return s.Key
+	}
+	tags := append([]string(nil), s.Tags...)
+	sort.Strings(tags)
+	return s.Key + "{" + strings.Join(tags, ",") + "}"
+}
+
+// GetKey returns the Key without the tags
+func (s *SimpleCounter) GetKey() string {
 	return s.Key
 }
 
+// GetTags returns the Tags
+func (s *SimpleCounter) GetTags() []string {
+	return s.Tags
+}
+
 // GetValues is part of the Counter interface
 func (s *SimpleCounter) GetValues() []float64 {
 	return s.Values
@@ -86,27 +168,40 @@ func (s *SimpleCounter) GetType() Type {
 
 // Aggregate aggregates the provided values appropriately, returning a map
 // from key to value. If AggregateHistogram is specified, the map will contain
-// the relevant percentiles as specified by HistogramPercentiles
+// the relevant percentiles as specified by HistogramPercentiles. If the
+// Policy is ValueCount, the map will also contain the number of invalid
+// values dropped
 func (s *SimpleCounter) Aggregate() map[string]float64 {
+	policy := s.Policy.resolve()
+	values, invalid := ValidValues(s.Values, policy)
+	var result map[string]float64
 	switch s.Type {
 	case AggregateAvg:
-		return map[string]float64{
-			s.Key: Average(s.Values),
+		result = map[string]float64{
+			s.Key: average(values, policy),
 		}
 	case AggregateSum:
-		return map[string]float64{
-			s.Key: Sum(s.Values),
+		result = map[string]float64{
+			s.Key: sum(values, policy),
+		}
+	case AggregateMin, AggregateMax, AggregateLast:
+		result = map[string]float64{
+			s.Key: aggregateValue(s.Type, values, policy),
 		}
 	case AggregateHistogram:
-		histogram := map[string]float64{
-			s.Key: Average(s.Values),
+		result = map[string]float64{
+			s.Key: average(values, policy),
 		}
-		if len(s.Values) > MinSamplesForPercentiles {
-			for k, v := range Percentiles(s.Values, HistogramPercentiles) {
-				histogram[fmt.Sprintf("%s.%s", s.Key, k)] = v
+		if len(values) > MinSamplesForPercentiles {
+			for k, v := range percentilesOf(values, HistogramPercentiles, policy) {
+				result[fmt.Sprintf("%s.%s", s.Key, k)] = v
 			}
 		}
-		return histogram
+	default:
+		panic("stats: unsupported aggregation type")
+	}
+	if policy == ValueCount {
+		result[s.Key+".invalid_values"] = float64(s.Invalid + invalid)
 	}
-	panic("stats: unsupported aggregation type")
+	return result
 }
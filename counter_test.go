@@ -1,6 +1,7 @@
 package stats_test
 
 import (
+	"math"
 	"testing"
 
 	"github.com/facebookgo/ensure"
@@ -58,3 +59,142 @@ func TestSimpleCounterAggregation(t *testing.T) {
 		"foo.time.p99": 10.0,
 	})
 }
+
+func TestSimpleCounterMinMaxLast(t *testing.T) {
+	t.Parallel()
+
+	a := stats.Aggregates{}
+	for _, values := range [][]float64{{3, 1, 4}, {1, 5, 9, 2}, {6}} {
+		for _, typ := range []stats.Type{stats.AggregateMin, stats.AggregateMax, stats.AggregateLast} {
+			ensure.Nil(t, a.Add(&stats.SimpleCounter{
+				Key:    "foo." + typ.String(),
+				Values: values,
+				Type:   typ,
+			}))
+		}
+	}
+
+	all := map[string]float64{}
+	for _, counter := range a {
+		for key, value := range counter.(*stats.SimpleCounter).Aggregate() {
+			all[key] = value
+		}
+	}
+	ensure.DeepEqual(t, all, map[string]float64{
+		"foo.min":  1,
+		"foo.max":  9,
+		"foo.last": 6,
+	})
+}
+
+// Changes the global policy, so it can't run in parallel.
+func TestSimpleCounterInvalidValues(t *testing.T) {
+	defer func(p stats.ValuePolicy) { stats.InvalidValuePolicy = p }(stats.InvalidValuePolicy)
+	stats.InvalidValuePolicy = stats.ValueCount
+
+	c := &stats.SimpleCounter{
+		Key:    "foo.avg",
+		Values: []float64{1, math.NaN(), 3, math.Inf(-1)},
+		Type:   stats.AggregateAvg,
+	}
+	ensure.DeepEqual(t, c.Aggregate(), map[string]float64{
+		"foo.avg":                2,
+		"foo.avg.invalid_values": 2,
+	})
+
+	stats.InvalidValuePolicy = stats.ValueClamp
+	c = &stats.SimpleCounter{
+		Key:    "foo.sum",
+		Values: []float64{1, math.NaN(), -3},
+		Type:   stats.AggregateSum,
+	}
+	ensure.DeepEqual(t, c.Aggregate(), map[string]float64{
+		"foo.sum": -2,
+	})
+}
+
+func TestTypeString(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, stats.AggregateAvg.String(), "avg")
+	ensure.DeepEqual(t, stats.AggregateSum.String(), "sum")
+	ensure.DeepEqual(t, stats.AggregateHistogram.String(), "histogram")
+	ensure.DeepEqual(t, stats.AggregateMin.String(), "min")
+	ensure.DeepEqual(t, stats.AggregateMax.String(), "max")
+	ensure.DeepEqual(t, stats.AggregateLast.String(), "last")
+	ensure.DeepEqual(t, stats.Type(42).String(), "Type(42)")
+}
+
+func TestSimpleCounterTags(t *testing.T) {
+	t.Parallel()
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "foo", Values: []float64{1}, Tags: []string{"b:2", "a:1"}})
+	a.Add(&stats.SimpleCounter{Key: "foo", Values: []float64{2}, Tags: []string{"a:1", "b:2"}})
+	a.Add(&stats.SimpleCounter{Key: "foo", Values: []float64{3}})
+	ensure.DeepEqual(t, len(a), 2)
+	c := a["foo{a:1,b:2}"].(*stats.SimpleCounter)
+	ensure.DeepEqual(t, c.GetKey(), "foo")
+	ensure.DeepEqual(t, c.GetTags(), []string{"b:2", "a:1"})
+	ensure.DeepEqual(t, c.Values, []float64{1, 2})
+	ensure.DeepEqual(t, a["foo"].GetValues(), []float64{3})
+}
+
+func TestSimpleCounterPolicy(t *testing.T) {
+	t.Parallel()
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{
+		Key:     "foo.sum",
+		Values:  []float64{1, math.NaN()},
+		Type:    stats.AggregateSum,
+		Policy:  stats.ValueCount,
+		Invalid: 2,
+	})
+	a.Add(&stats.SimpleCounter{
+		Key:     "foo.sum",
+		Values:  []float64{math.Inf(1), 2},
+		Type:    stats.AggregateSum,
+		Invalid: 1,
+	})
+	ensure.DeepEqual(t, a["foo.sum"].(*stats.SimpleCounter).Aggregate(), map[string]float64{
+		"foo.sum":                3,
+		"foo.sum.invalid_values": 5,
+	})
+
+	// Merging with a ReservoirCounter keeps the invalid count of either side.
+	for _, first := range []bool{true, false} {
+		r := stats.NewReservoirCounter("foo.hist", 10)
+		r.Policy = stats.ValueCount
+		r.AddValues(math.NaN(), 1)
+		s := &stats.SimpleCounter{
+			Key:     "foo.hist",
+			Values:  []float64{2, math.Inf(1)},
+			Type:    stats.AggregateHistogram,
+			Invalid: 2,
+		}
+		a := stats.Aggregates{}
+		if first {
+			ensure.Nil(t, a.Add(r))
+			ensure.Nil(t, a.Add(s))
+		} else {
+			ensure.Nil(t, a.Add(s))
+			ensure.Nil(t, a.Add(r))
+		}
+		ensure.DeepEqual(t, a["foo.hist"].(*stats.ReservoirCounter).Aggregate(), map[string]float64{
+			"foo.hist":                1.5,
+			"foo.hist.invalid_values": 4,
+		}, first)
+	}
+
+	// Clamped values don't overflow the aggregates.
+	for typ, want := range map[stats.Type]float64{
+		stats.AggregateSum: math.MaxFloat64,
+		stats.AggregateAvg: math.MaxFloat64,
+	} {
+		c := &stats.SimpleCounter{
+			Key:    "foo",
+			Values: []float64{math.Inf(1), math.Inf(1), math.MaxFloat64},
+			Type:   typ,
+			Policy: stats.ValueClamp,
+		}
+		ensure.DeepEqual(t, c.Aggregate(), map[string]float64{"foo": want}, typ)
+	}
+}
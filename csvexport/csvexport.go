@@ -0,0 +1,231 @@
+// Package csvexport writes aggregated counters as CSV with a stable column
+// schema, for loading into spreadsheets and SQL tools. Each row is one
+// counter at one flush:
+//
+//	timestamp,key,tags,type,count,value,p50,p95,p99
+//
+// The timestamp is RFC 3339 in UTC. Tags are "k=v" pairs sorted by key and
+// joined with ";", with "\", "=" and ";" inside keys and values escaped with a
+// backslash. The quantile columns follow the configured quantiles and are
+// empty for counters which aren't histograms or don't have enough samples.
+package csvexport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/stats"
+)
+
+var fixedColumns = []string{"timestamp", "key", "tags", "type", "count", "value"}
+
+// Exporter writes flushes to CSV files in a directory. A new file is started
+// when the current one exceeds MaxBytes or MaxAge, or when the quantiles
+// change, so every file has a single header matching its rows. It is not
+// safe for concurrent use.
+type Exporter struct {
+	// Dir is the directory the files are written to.
+	Dir string
+
+	// Prefix is the prefix of the file names, which is followed by the UTC
+	// time the file was started. It defaults to "stats".
+	Prefix string
+
+	// MaxBytes rotates the file once it is larger. Zero disables it.
+	MaxBytes int64
+
+	// MaxAge rotates the file once it is older. Zero disables it.
+	MaxAge time.Duration
+
+	// Quantiles are the quantile columns, defaulting to
+	// stats.HistogramPercentiles.
+	Quantiles map[string]float64
+
+	file    *os.File
+	w       *csv.Writer
+	size    int64
+	started time.Time
+	header  []string
+	labels  []string
+}
+
+// Write writes a row for every counter, rotating the file as needed.
+func (e *Exporter) Write(t time.Time, counters stats.Aggregates) error {
+	quantiles := e.Quantiles
+	if quantiles == nil {
+		quantiles = stats.HistogramPercentiles
+	}
+	labels := sortedLabels(quantiles)
+	if e.file == nil || e.shouldRotate(t, labels) {
+		if err := e.rotate(t, labels); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	timestamp := t.UTC().Format(time.RFC3339Nano)
+	for _, key := range keys {
+		row := Row(timestamp, counters[key], labels, quantiles)
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+		e.size += rowSize(row)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Row returns the CSV columns for a counter. Counters which don't implement
+// stats.Tagged are exported with empty tags.
+func Row(timestamp string, c stats.Counter, labels []string, quantiles map[string]float64) []string {
+	p := stats.NewPoint(c, quantiles)
+	row := []string{
+		timestamp,
+		p.Key,
+		FormatTags(p.Tags),
+		p.Type.String(),
+		strconv.Itoa(p.Count),
+		formatFloat(p.Value),
+	}
+	results := map[string]float64{}
+	for _, q := range p.Quantiles {
+		results[q.Label] = q.Value
+	}
+	for _, label := range labels {
+		if v, ok := results[label]; ok {
+			row = append(row, formatFloat(v))
+		} else {
+			row = append(row, "")
+		}
+	}
+	return row
+}
+
+// FormatTags returns the tags as escaped "k=v" pairs joined by ";", sorted
+// by key and then by value before escaping.
+func FormatTags(tags []string) string {
+	type pair struct{ k, v string }
+	sorted := make([]pair, len(tags))
+	for i, tag := range tags {
+		sorted[i].k, sorted[i].v = stats.SplitTag(tag)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].k != sorted[j].k {
+			return sorted[i].k < sorted[j].k
+		}
+		return sorted[i].v < sorted[j].v
+	})
+	pairs := make([]string, len(sorted))
+	for i, p := range sorted {
+		pairs[i] = escapeTag(p.k) + "=" + escapeTag(p.v)
+	}
+	return strings.Join(pairs, ";")
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`)
+
+func escapeTag(s string) string {
+	return tagEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// rowSize estimates the encoded size of a row, which only needs to be
+// accurate enough for rotation.
+func rowSize(row []string) int64 {
+	size := int64(len(row))
+	for _, col := range row {
+		size += int64(len(col))
+	}
+	return size
+}
+
+func sortedLabels(quantiles map[string]float64) []string {
+	labels := make([]string, 0, len(quantiles))
+	for label := range quantiles {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		qi, qj := quantiles[labels[i]], quantiles[labels[j]]
+		if qi != qj {
+			return qi < qj
+		}
+		return labels[i] < labels[j]
+	})
+	return labels
+}
+
+func (e *Exporter) shouldRotate(t time.Time, labels []string) bool {
+	if e.MaxBytes > 0 && e.size >= e.MaxBytes {
+		return true
+	}
+	if e.MaxAge > 0 && t.Sub(e.started) >= e.MaxAge {
+		return true
+	}
+	return strings.Join(labels, ",") != strings.Join(e.labels, ",")
+}
+
+func (e *Exporter) rotate(t time.Time, labels []string) error {
+	if err := e.Close(); err != nil {
+		return err
+	}
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "stats"
+	}
+	base := fmt.Sprintf("%s-%s", prefix, t.UTC().Format("20060102T150405.000000000Z"))
+	var f *os.File
+	var err error
+	for i := 0; ; i++ {
+		name := base + ".csv"
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d.csv", base, i)
+		}
+		f, err = os.OpenFile(filepath.Join(e.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	e.file = f
+	e.w = csv.NewWriter(f)
+	e.started = t
+	e.labels = labels
+	e.header = append(append([]string(nil), fixedColumns...), labels...)
+	e.size = rowSize(e.header)
+	return e.w.Write(e.header)
+}
+
+// Header returns the header of the current file.
+func (e *Exporter) Header() []string {
+	return e.header
+}
+
+// Close closes the current file. A later Write starts a new one.
+func (e *Exporter) Close() error {
+	if e.file == nil {
+		return nil
+	}
+	e.w.Flush()
+	err := e.w.Error()
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	e.file, e.w = nil, nil
+	return err
+}
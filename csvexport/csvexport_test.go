@@ -0,0 +1,129 @@
+package csvexport_test
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/csvexport"
+)
+
+var flushTime = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func readAll(t *testing.T, dir string) [][][]string {
+	names, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	ensure.Nil(t, err)
+	sort.Strings(names)
+	var files [][][]string
+	for _, name := range names {
+		f, err := os.Open(name)
+		ensure.Nil(t, err)
+		rows, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		ensure.Nil(t, err)
+		files = append(files, rows)
+	}
+	return files
+}
+
+func histogramValues() []float64 {
+	var values []float64
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(i))
+	}
+	return values
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	e := &csvexport.Exporter{Dir: dir}
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "a.sum", Values: []float64{1, 2.5}, Type: stats.AggregateSum})
+	a.Add(&stats.SimpleCounter{Key: "b.hist", Values: histogramValues(), Type: stats.AggregateHistogram})
+	a.Add(&stats.SimpleCounter{
+		Key:    "c.avg",
+		Values: []float64{1, 2},
+		Type:   stats.AggregateAvg,
+		Tags:   []string{"z:1", "path=a;b=c", `w:x\y`},
+	})
+	ensure.Nil(t, e.Write(flushTime, a))
+	ensure.Nil(t, e.Close())
+
+	ensure.DeepEqual(t, readAll(t, dir), [][][]string{{
+		{"timestamp", "key", "tags", "type", "count", "value", "p50", "p95", "p99"},
+		{"2017-01-02T03:04:05Z", "a.sum", "", "sum", "2", "3.5", "", "", ""},
+		{"2017-01-02T03:04:05Z", "b.hist", "", "histogram", "21", "10", "10", "19", "20"},
+		{"2017-01-02T03:04:05Z", "c.avg", `path=a\;b\=c;w=x\\y;z=1`, "avg", "2", "1.5", "", "", ""},
+	}})
+}
+
+// Tags sort by key, then by value, regardless of how they escape.
+func TestFormatTags(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, csvexport.FormatTags([]string{"a-b:1", "a:2", "a:1"}), "a=1;a=2;a-b=1")
+	ensure.DeepEqual(t, csvexport.FormatTags([]string{"a;b:1", "a:1"}), `a=1;a\;b=1`)
+	ensure.DeepEqual(t, csvexport.FormatTags(nil), "")
+}
+
+// Changing the quantiles starts a new file with the new header.
+func TestQuantileChangeRotates(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	e := &csvexport.Exporter{Dir: dir, Quantiles: map[string]float64{"p50": 0.5}}
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "h", Values: histogramValues(), Type: stats.AggregateHistogram})
+	ensure.Nil(t, e.Write(flushTime, a))
+	e.Quantiles = map[string]float64{"p90": 0.9, "p10": 0.1}
+	ensure.Nil(t, e.Write(flushTime.Add(time.Second), a))
+	ensure.DeepEqual(t, e.Header(), []string{"timestamp", "key", "tags", "type", "count", "value", "p10", "p90"})
+	ensure.Nil(t, e.Close())
+
+	files := readAll(t, dir)
+	ensure.DeepEqual(t, len(files), 2)
+	ensure.DeepEqual(t, files[0][0][6:], []string{"p50"})
+	ensure.DeepEqual(t, files[1][0][6:], []string{"p10", "p90"})
+	ensure.DeepEqual(t, files[1][1][6:], []string{"2", "18"})
+}
+
+func TestRotation(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	e := &csvexport.Exporter{Dir: dir, Prefix: "flush", MaxBytes: 60, MaxAge: time.Hour}
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "a", Values: []float64{1}, Type: stats.AggregateSum})
+
+	// The header and one row fit, the second write rotates on size.
+	ensure.Nil(t, e.Write(flushTime, a))
+	ensure.Nil(t, e.Write(flushTime.Add(time.Second), a))
+	// Only the last write is old enough to rotate on age.
+	e.MaxBytes = 0
+	ensure.Nil(t, e.Write(flushTime.Add(time.Minute), a))
+	ensure.Nil(t, e.Write(flushTime.Add(2*time.Hour), a))
+	ensure.Nil(t, e.Close())
+
+	names, err := filepath.Glob(filepath.Join(dir, "flush-*.csv"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(names), 3)
+	files := readAll(t, dir)
+	ensure.DeepEqual(t, len(files[0]), 2)
+	ensure.DeepEqual(t, len(files[1]), 3)
+	ensure.DeepEqual(t, len(files[2]), 2)
+}
+
+// Reservoirs are exported with their exact count rather than the sample's.
+func TestRowReservoir(t *testing.T) {
+	t.Parallel()
+	r := stats.NewReservoirCounter("r", 5)
+	for _, v := range histogramValues() {
+		r.AddValues(v)
+	}
+	row := csvexport.Row("now", r, []string{"p50"}, map[string]float64{"p50": 0.5})
+	ensure.DeepEqual(t, row[:6], []string{"now", "r", "", "histogram", "21", "10"})
+	ensure.DeepEqual(t, len(row), 7)
+}
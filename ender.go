@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OnceEnder wraps e so that only the first call to End is passed on.
+func OnceEnder(e interface {
+	End()
+}) interface {
+	End()
+} {
+	return &onceEnder{ender: e}
+}
+
+type onceEnder struct {
+	ender interface {
+		End()
+	}
+	ended int32
+}
+
+func (o *onceEnder) End() {
+	if atomic.CompareAndSwapInt32(&o.ended, 0, 1) {
+		o.ender.End()
+	}
+}
+
+// TimerLeak describes a timer which was started but not ended.
+type TimerLeak struct {
+	Key   string
+	Start time.Time
+	Stack string
+}
+
+func (l TimerLeak) String() string {
+	return fmt.Sprintf("stats: timer %s started %s ago was not ended:\n%s",
+		l.Key, time.Since(l.Start), l.Stack)
+}
+
+// TimerTracker is a debugging aid which tracks outstanding timers along with
+// the stack trace of where they were started, to find code paths which skip
+// calling End. Capturing stack traces is expensive, so it isn't meant to be
+// left on in production.
+type TimerTracker struct {
+	mu     sync.Mutex
+	timers map[*trackedTimer]struct{}
+}
+
+// NewTimerTracker creates a new TimerTracker.
+func NewTimerTracker() *TimerTracker {
+	return &TimerTracker{
+		timers: map[*trackedTimer]struct{}{},
+	}
+}
+
+type trackedTimer struct {
+	tracker *TimerTracker
+	leak    TimerLeak
+	ender   interface {
+		End()
+	}
+	ended int32
+}
+
+func (t *trackedTimer) End() {
+	if !atomic.CompareAndSwapInt32(&t.ended, 0, 1) {
+		return
+	}
+	t.tracker.mu.Lock()
+	delete(t.tracker.timers, t)
+	t.tracker.mu.Unlock()
+	t.ender.End()
+}
+
+// Track starts tracking the timer for the given key. The returned value must
+// be End'ed in place of e, and only the first call to End is passed on.
+func (t *TimerTracker) Track(key string, e interface {
+	End()
+}) interface {
+	End()
+} {
+	timer := &trackedTimer{
+		tracker: t,
+		leak: TimerLeak{
+			Key:   key,
+			Start: time.Now(),
+			Stack: string(debug.Stack()),
+		},
+		ender: e,
+	}
+	t.mu.Lock()
+	t.timers[timer] = struct{}{}
+	t.mu.Unlock()
+	return timer
+}
+
+// Client wraps c so that every timer started with BumpTime is tracked. If c
+// is an AliasClient so is the returned client, and timers started with
+// BumpTimeAliases are tracked under the keys joined by commas.
+func (t *TimerTracker) Client(c Client) Client {
+	tc := &trackingClient{Client: c, tracker: t}
+	if alias, ok := c.(AliasClient); ok {
+		return &trackingAliasClient{trackingClient: tc, alias: alias}
+	}
+	return tc
+}
+
+// Leaks returns the timers which have been outstanding for longer than the
+// timeout, oldest first.
+func (t *TimerTracker) Leaks(timeout time.Duration) []TimerLeak {
+	var leaks []TimerLeak
+	t.mu.Lock()
+	for timer := range t.timers {
+		if time.Since(timer.leak.Start) > timeout {
+			leaks = append(leaks, timer.leak)
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].Start.Before(leaks[j].Start)
+	})
+	return leaks
+}
+
+// Outstanding returns the number of timers which haven't been ended.
+func (t *TimerTracker) Outstanding() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.timers)
+}
+
+type trackingClient struct {
+	Client  Client
+	tracker *TimerTracker
+}
+
+func (c *trackingClient) BumpAvg(key string, val float64, tags ...string) {
+	BumpAvg(c.Client, key, val, tags...)
+}
+
+func (c *trackingClient) BumpSum(key string, val float64, tags ...string) {
+	BumpSum(c.Client, key, val, tags...)
+}
+
+func (c *trackingClient) BumpHistogram(key string, val float64, tags ...string) {
+	BumpHistogram(c.Client, key, val, tags...)
+}
+
+func (c *trackingClient) BumpMin(key string, val float64, tags ...string) {
+	BumpMin(c.Client, key, val, tags...)
+}
+
+func (c *trackingClient) BumpMax(key string, val float64, tags ...string) {
+	BumpMax(c.Client, key, val, tags...)
+}
+
+func (c *trackingClient) BumpLast(key string, val float64, tags ...string) {
+	BumpLast(c.Client, key, val, tags...)
+}
+
+func (c *trackingClient) BumpTime(key string, tags ...string) interface {
+	End()
+} {
+	return c.tracker.Track(key, BumpTime(c.Client, key, tags...))
+}
+
+type trackingAliasClient struct {
+	*trackingClient
+	alias AliasClient
+}
+
+func (c *trackingAliasClient) BumpAvgAliases(keys []string, val float64, tags ...string) {
+	c.alias.BumpAvgAliases(keys, val, tags...)
+}
+
+func (c *trackingAliasClient) BumpSumAliases(keys []string, val float64, tags ...string) {
+	c.alias.BumpSumAliases(keys, val, tags...)
+}
+
+func (c *trackingAliasClient) BumpHistogramAliases(keys []string, val float64, tags ...string) {
+	c.alias.BumpHistogramAliases(keys, val, tags...)
+}
+
+func (c *trackingAliasClient) BumpTimeAliases(keys []string, tags ...string) interface {
+	End()
+} {
+	return c.tracker.Track(strings.Join(keys, ","), c.alias.BumpTimeAliases(keys, tags...))
+}
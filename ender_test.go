@@ -0,0 +1,137 @@
+package stats_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+type countingEnder struct {
+	Ends int
+}
+
+func (c *countingEnder) End() {
+	c.Ends++
+}
+
+func TestOnceEnder(t *testing.T) {
+	t.Parallel()
+	var c countingEnder
+	e := stats.OnceEnder(&c)
+	e.End()
+	e.End()
+	ensure.DeepEqual(t, c.Ends, 1)
+}
+
+func TestStopperEndTwice(t *testing.T) {
+	t.Parallel()
+	var sums, histograms int
+	s := &stats.Stopper{
+		Key:   "foo",
+		Start: time.Now(),
+		Client: &stats.HookClient{
+			BumpSumHook: func(key string, val float64, tags ...string) {
+				sums++
+			},
+			BumpHistogramHook: func(key string, val float64, tags ...string) {
+				histograms++
+			},
+		},
+	}
+	s.End()
+	s.End()
+	ensure.DeepEqual(t, sums, 1)
+	ensure.DeepEqual(t, histograms, 1)
+}
+
+func TestTimerTracker(t *testing.T) {
+	t.Parallel()
+	var ends countingEnder
+	tracker := stats.NewTimerTracker()
+	c := tracker.Client(&stats.HookClient{
+		BumpTimeHook: func(key string, tags ...string) interface {
+			End()
+		} {
+			return &ends
+		},
+	})
+
+	ended := c.BumpTime("ended")
+	leaked := c.BumpTime("leaked")
+	ensure.DeepEqual(t, tracker.Outstanding(), 2)
+	ended.End()
+	ended.End()
+	ensure.DeepEqual(t, ends.Ends, 1)
+	ensure.DeepEqual(t, tracker.Outstanding(), 1)
+
+	ensure.DeepEqual(t, len(tracker.Leaks(time.Hour)), 0)
+	leaks := tracker.Leaks(0)
+	ensure.DeepEqual(t, len(leaks), 1)
+	ensure.DeepEqual(t, leaks[0].Key, "leaked")
+	ensure.StringContains(t, leaks[0].Stack, "TestTimerTracker")
+	ensure.StringContains(t, leaks[0].String(), "timer leaked started")
+
+	leaked.End()
+	ensure.DeepEqual(t, tracker.Outstanding(), 0)
+	ensure.DeepEqual(t, ends.Ends, 2)
+}
+
+// Ensure tracking works with a nil Client.
+func TestTimerTrackerNilClient(t *testing.T) {
+	t.Parallel()
+	tracker := stats.NewTimerTracker()
+	c := tracker.Client(nil)
+	c.BumpAvg("foo", 1)
+	c.BumpSum("foo", 1)
+	c.BumpHistogram("foo", 1)
+	c.BumpTime("foo").End()
+	ensure.DeepEqual(t, tracker.Outstanding(), 0)
+}
+
+func TestTimerTrackerAliases(t *testing.T) {
+	t.Parallel()
+	tracker := stats.NewTimerTracker()
+	a := &stats.Aggregator{}
+	c := tracker.Client(a)
+	_, ok := c.(stats.AliasClient)
+	ensure.True(t, ok)
+
+	pc := stats.PrefixClient([]string{"a.", "b."}, c)
+	pc.BumpSum("sum", 1)
+	e := pc.BumpTime("time")
+	leaks := tracker.Leaks(0)
+	ensure.DeepEqual(t, len(leaks), 1)
+	ensure.DeepEqual(t, leaks[0].Key, "a.time,b.time")
+	e.End()
+	e.End()
+	ensure.DeepEqual(t, tracker.Outstanding(), 0)
+
+	counters, err := a.Flush()
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(counters["a.sum"].GetValues()), 1)
+	ensure.DeepEqual(t, len(counters["b.time"].GetValues()), 1)
+	ensure.DeepEqual(t, len(counters["b.time.total"].GetValues()), 1)
+}
+
+func TestRecordDuration(t *testing.T) {
+	t.Parallel()
+	var bumps []string
+	c := &stats.HookClient{
+		BumpSumHook: func(key string, val float64, tags ...string) {
+			bumps = append(bumps, fmt.Sprintf("sum:%s:%v:%s", key, val, strings.Join(tags, ",")))
+		},
+		BumpHistogramHook: func(key string, val float64, tags ...string) {
+			bumps = append(bumps, fmt.Sprintf("histogram:%s:%v:%s", key, val, strings.Join(tags, ",")))
+		},
+	}
+	stats.RecordDuration(c, "foo", 1500*time.Microsecond, "t:x")
+	stats.RecordDuration(nil, "foo", time.Second)
+	ensure.DeepEqual(t, bumps, []string{
+		"sum:foo.total:1.5:t:x",
+		"histogram:foo:1.5:t:x",
+	})
+}
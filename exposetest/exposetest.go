@@ -0,0 +1,319 @@
+// Package exposetest checks the output of exposition handlers in tests. It
+// parses what a handler serves in the Prometheus text or OpenMetrics format
+// and verifies invariants every exposition should hold:
+//
+//   - Label names are valid and not reserved.
+//   - No series is exposed twice.
+//   - Counters, histogram buckets and histogram and summary counts aren't
+//     negative or NaN. Sums may be negative, since observations may be.
+//   - Quantiles of summaries, and of untyped families such as promtext.Write
+//     exposes for a flush, are within [0, 1] and their values don't decrease
+//     as the quantile increases, so p50 <= p95 <= p99.
+//   - Histogram buckets don't decrease as le increases, and the +Inf bucket
+//     equals _count.
+//   - Cumulative series other than sums don't decrease between two scrapes.
+//
+// A typical test:
+//
+//	before := exposetest.Scrape(t, handler)
+//	exposetest.Check(t, before)
+//	// exercise the code
+//	after := exposetest.Scrape(t, handler)
+//	exposetest.CheckMonotonic(t, before, after)
+//	exposetest.Equal(t, after, map[string]float64{
+//		`requests_total{code="200"}`: 1,
+//	})
+package exposetest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/stats/promtext"
+)
+
+// Exposition is a parsed scrape.
+type Exposition struct {
+	Format   promtext.Format
+	Families []*promtext.Family
+}
+
+// Scrape serves a GET request for /metrics with h and parses the response,
+// failing the test if the status isn't 200 or the body doesn't parse.
+func Scrape(t testing.TB, h http.Handler) *Exposition {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", promtext.OpenMetricsContentType+", text/plain;version=0.0.4;q=0.5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("exposetest: handler returned %d: %s", w.Code, w.Body)
+	}
+	e, err := Parse(w.Body.String(), promtext.FormatFromContentType(w.Header().Get("Content-Type")))
+	if err != nil {
+		t.Fatalf("exposetest: %v", err)
+	}
+	return e
+}
+
+// Parse parses an exposition.
+func Parse(body string, format promtext.Format) (*Exposition, error) {
+	families, err := promtext.ParseFamilies(strings.NewReader(body), format)
+	if err != nil {
+		return nil, err
+	}
+	return &Exposition{Format: format, Families: families}, nil
+}
+
+// Samples returns all samples.
+func (e *Exposition) Samples() []promtext.Sample {
+	var samples []promtext.Sample
+	for _, f := range e.Families {
+		samples = append(samples, f.Samples...)
+	}
+	return samples
+}
+
+// SeriesID identifies a series as its name with labels sorted by name, such
+// as `requests_total{code="200",method="get"}`.
+func SeriesID(name string, labels []promtext.Label) string {
+	if len(labels) == 0 {
+		return name
+	}
+	sorted := append([]promtext.Label(nil), labels...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	pairs := make([]string, len(sorted))
+	for i, l := range sorted {
+		pairs[i] = l.Name + "=" + strconv.Quote(l.Value)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Map returns the value of every series by SeriesID. If a series is exposed
+// more than once the last value wins.
+func (e *Exposition) Map() map[string]float64 {
+	m := map[string]float64{}
+	for _, s := range e.Samples() {
+		m[SeriesID(s.Name, s.Labels)] = s.Value
+	}
+	return m
+}
+
+// Value returns the value of a series given its name and "name:value"
+// tags, in any order.
+func (e *Exposition) Value(name string, tags ...string) (float64, bool) {
+	labels := make([]promtext.Label, len(tags))
+	for i, tag := range tags {
+		k, v, _ := strings.Cut(tag, ":")
+		labels[i] = promtext.Label{Name: k, Value: v}
+	}
+	v, ok := e.Map()[SeriesID(name, labels)]
+	return v, ok
+}
+
+// Verify returns the violations of the invariants within a scrape.
+func Verify(e *Exposition) []error {
+	var errs []error
+	errorf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	seen := map[string]bool{}
+	for _, s := range e.Samples() {
+		id := SeriesID(s.Name, s.Labels)
+		if seen[id] {
+			errorf("duplicate series %s", id)
+		}
+		seen[id] = true
+		for _, l := range s.Labels {
+			if strings.HasPrefix(l.Name, "__") {
+				errorf("reserved label name %s in %s", l.Name, id)
+			}
+		}
+		if cumulative(&s) && (s.Value < 0 || math.IsNaN(s.Value)) {
+			errorf("cumulative series %s is %v", id, s.Value)
+		}
+	}
+
+	for _, f := range e.Families {
+		switch f.Type {
+		case promtext.Summary, promtext.Untyped:
+			errs = append(errs, verifyQuantiles(f)...)
+		case promtext.Histogram:
+			errs = append(errs, verifyBuckets(f)...)
+		}
+	}
+	return errs
+}
+
+// cumulative reports if the sample must not be negative or decrease. The
+// _sum of a histogram or summary is cumulative but may do both when
+// negative values are observed.
+func cumulative(s *promtext.Sample) bool {
+	if (s.Type == promtext.Histogram || s.Type == promtext.Summary) && strings.HasSuffix(s.Name, "_sum") {
+		return false
+	}
+	return s.Cumulative()
+}
+
+type point struct {
+	x, v float64
+}
+
+// groups returns the points of samples with the name, grouped by their
+// labels other than the one holding the x value.
+func groups(f *promtext.Family, name, label string) (map[string][]point, []error) {
+	var errs []error
+	result := map[string][]point{}
+	for _, s := range f.Samples {
+		if s.Name != name || s.Label(label) == "" {
+			continue
+		}
+		x, err := promtext.ParseValue(s.Label(label))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q in %s", label, s.Label(label), SeriesID(s.Name, s.Labels)))
+			continue
+		}
+		var rest []promtext.Label
+		for _, l := range s.Labels {
+			if l.Name != label {
+				rest = append(rest, l)
+			}
+		}
+		id := SeriesID(name, rest)
+		result[id] = append(result[id], point{x, s.Value})
+	}
+	for _, points := range result {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].x < points[j].x
+		})
+	}
+	return result, errs
+}
+
+func sortedKeys(m map[string][]point) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func verifyQuantiles(f *promtext.Family) []error {
+	result, errs := groups(f, f.Name, "quantile")
+	for _, id := range sortedKeys(result) {
+		points := result[id]
+		for i, p := range points {
+			if p.x < 0 || p.x > 1 {
+				errs = append(errs, fmt.Errorf("quantile %v of %s is outside [0, 1]", p.x, id))
+			}
+			if i > 0 && p.v < points[i-1].v {
+				errs = append(errs, fmt.Errorf("quantile %v of %s is %v, less than %v for quantile %v",
+					p.x, id, p.v, points[i-1].v, points[i-1].x))
+			}
+		}
+	}
+	return errs
+}
+
+func verifyBuckets(f *promtext.Family) []error {
+	result, errs := groups(f, f.Name+"_bucket", "le")
+	counts := map[string]float64{}
+	for _, s := range f.Samples {
+		if s.Name == f.Name+"_count" {
+			counts[SeriesID(f.Name+"_bucket", s.Labels)] = s.Value
+		}
+	}
+	for _, id := range sortedKeys(result) {
+		points := result[id]
+		for i, p := range points {
+			if i > 0 && p.v < points[i-1].v {
+				errs = append(errs, fmt.Errorf("bucket le=%v of %s is %v, less than %v for le=%v",
+					p.x, id, p.v, points[i-1].v, points[i-1].x))
+			}
+		}
+		last := points[len(points)-1]
+		if !math.IsInf(last.x, 1) {
+			errs = append(errs, fmt.Errorf("%s has no +Inf bucket", id))
+		} else if count, ok := counts[id]; ok && count != last.v {
+			errs = append(errs, fmt.Errorf("+Inf bucket of %s is %v but _count is %v", id, last.v, count))
+		}
+	}
+	return errs
+}
+
+// VerifyMonotonic returns the cumulative series which decreased from before
+// to after, other than the _sum of histograms and summaries.
+func VerifyMonotonic(before, after *Exposition) []error {
+	values := map[string]float64{}
+	for _, s := range before.Samples() {
+		if cumulative(&s) {
+			values[SeriesID(s.Name, s.Labels)] = s.Value
+		}
+	}
+	var errs []error
+	for _, s := range after.Samples() {
+		id := SeriesID(s.Name, s.Labels)
+		if prev, ok := values[id]; ok && cumulative(&s) && s.Value < prev {
+			errs = append(errs, fmt.Errorf("cumulative series %s decreased from %v to %v", id, prev, s.Value))
+		}
+	}
+	return errs
+}
+
+// Diff returns the differences between the series of got and want, keyed
+// by SeriesID. NaN values are equal to each other.
+func Diff(got *Exposition, want map[string]float64) []string {
+	values := got.Map()
+	var diffs []string
+	for id, w := range want {
+		g, ok := values[id]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("missing %s, want %v", id, w))
+		case g != w && !(math.IsNaN(g) && math.IsNaN(w)):
+			diffs = append(diffs, fmt.Sprintf("%s is %v, want %v", id, g, w))
+		}
+	}
+	for id, g := range values {
+		if _, ok := want[id]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected %s = %v", id, g))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// Check fails the test for every violation reported by Verify.
+func Check(t testing.TB, e *Exposition) {
+	t.Helper()
+	for _, err := range Verify(e) {
+		t.Errorf("exposetest: %v", err)
+	}
+}
+
+// CheckMonotonic fails the test for every violation reported by
+// VerifyMonotonic.
+func CheckMonotonic(t testing.TB, before, after *Exposition) {
+	t.Helper()
+	for _, err := range VerifyMonotonic(before, after) {
+		t.Errorf("exposetest: %v", err)
+	}
+}
+
+// Equal fails the test for every difference reported by Diff.
+func Equal(t testing.TB, got *Exposition, want map[string]float64) {
+	t.Helper()
+	for _, diff := range Diff(got, want) {
+		t.Errorf("exposetest: %s", diff)
+	}
+}
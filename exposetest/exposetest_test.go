@@ -0,0 +1,236 @@
+package exposetest_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/exposetest"
+	"github.com/facebookgo/stats/promtext"
+)
+
+// handler exposes a histogram and a counter which grows with every scrape.
+func handler() http.Handler {
+	requests := 0.0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var values []float64
+		for i := 0; i <= 100; i++ {
+			values = append(values, float64(i))
+		}
+		a := stats.Aggregates{}
+		a.Add(&stats.SimpleCounter{Key: "latency", Values: values, Type: stats.AggregateHistogram})
+		a.Add(&stats.SimpleCounter{
+			Key:    "requests",
+			Values: []float64{requests},
+			Type:   stats.AggregateSum,
+			Tags:   []string{"method:get"},
+		})
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		promtext.Write(w, a, nil)
+	})
+}
+
+func parse(t *testing.T, body string) *exposetest.Exposition {
+	e, err := exposetest.Parse(body, promtext.TextFormat)
+	ensure.Nil(t, err)
+	return e
+}
+
+func errors(errs []error) []string {
+	var s []string
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+	return s
+}
+
+func TestScrape(t *testing.T) {
+	t.Parallel()
+	h := handler()
+	e := exposetest.Scrape(t, h)
+	exposetest.Check(t, e)
+	exposetest.Equal(t, e, map[string]float64{
+		`latency{quantile="0.5"}`:  50,
+		`latency{quantile="0.95"}`: 95,
+		`latency{quantile="0.99"}`: 99,
+		`latency_sum`:              5050,
+		`latency_count`:            101,
+		`requests{method="get"}`:   1,
+	})
+	v, ok := e.Value("requests", "method:get")
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, v, 1.0)
+	_, ok = e.Value("requests")
+	ensure.False(t, ok)
+}
+
+func TestScrapeOpenMetrics(t *testing.T) {
+	t.Parallel()
+	e := exposetest.Scrape(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", promtext.OpenMetricsContentType)
+		fmt.Fprint(w, "# TYPE a counter\na_total 1\n# EOF\n")
+	}))
+	ensure.DeepEqual(t, e.Format, promtext.OpenMetrics)
+	ensure.DeepEqual(t, e.Map(), map[string]float64{"a_total": 1})
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	e := parse(t, `# TYPE requests counter
+requests{code="200"} 1
+requests{code="200"} 2
+requests{code="500"} -1
+requests{__meta="x"} NaN
+# TYPE latency summary
+latency{quantile="0.5"} 10
+latency{quantile="0.95"} 30
+latency{quantile="0.99"} 20
+latency{quantile="1.5"} 40
+latency{quantile="high"} 40
+latency{quantile="0.5",path="/"} 1
+latency{quantile="0.99",path="/"} 2
+latency_sum -5
+# TYPE size histogram
+size_bucket{le="1"} 5
+size_bucket{le="10"} 4
+size_bucket{le="+Inf"} 6
+size_count 7
+size_sum -1
+size_bucket{le="1",path="/"} 1
+`)
+	ensure.DeepEqual(t, errors(exposetest.Verify(e)), []string{
+		`duplicate series requests{code="200"}`,
+		`cumulative series requests{code="500"} is -1`,
+		`reserved label name __meta in requests{__meta="x"}`,
+		`cumulative series requests{__meta="x"} is NaN`,
+		`invalid quantile "high" in latency{quantile="high"}`,
+		`quantile 0.99 of latency is 20, less than 30 for quantile 0.95`,
+		`quantile 1.5 of latency is outside [0, 1]`,
+		`bucket le=10 of size_bucket is 4, less than 5 for le=1`,
+		`+Inf bucket of size_bucket is 6 but _count is 7`,
+		`size_bucket{path="/"} has no +Inf bucket`,
+	})
+}
+
+func TestVerifyMonotonic(t *testing.T) {
+	t.Parallel()
+	h := handler()
+	before := exposetest.Scrape(t, h)
+	after := exposetest.Scrape(t, h)
+	exposetest.CheckMonotonic(t, before, after)
+
+	// Written as a counter, a decrease is a violation. Gauges may go down.
+	before = parse(t, "# TYPE a counter\na 2\n# TYPE b gauge\nb 2\n")
+	after = parse(t, "# TYPE a counter\na 1\n# TYPE b gauge\nb 1\n")
+	ensure.DeepEqual(t, errors(exposetest.VerifyMonotonic(before, after)), []string{
+		"cumulative series a decreased from 2 to 1",
+	})
+}
+
+// A flush written by promtext.Write covers an interval, so its values may
+// decrease or go negative in the next one without violating anything.
+func TestWriteFlushes(t *testing.T) {
+	t.Parallel()
+	write := func(sum float64, values ...float64) *exposetest.Exposition {
+		a := stats.Aggregates{}
+		a.Add(&stats.SimpleCounter{Key: "latency", Values: values, Type: stats.AggregateHistogram})
+		a.Add(&stats.SimpleCounter{Key: "requests", Values: []float64{sum}, Type: stats.AggregateSum})
+		var b bytes.Buffer
+		ensure.Nil(t, promtext.Write(&b, a, nil))
+		return parse(t, b.String())
+	}
+	var values []float64
+	for i := 0; i <= 100; i++ {
+		values = append(values, float64(i))
+	}
+	before := write(5, values...)
+	after := write(-2, -3, -1)
+	ensure.DeepEqual(t, errors(exposetest.Verify(before)), []string(nil))
+	ensure.DeepEqual(t, errors(exposetest.Verify(after)), []string(nil))
+	ensure.DeepEqual(t, errors(exposetest.VerifyMonotonic(before, after)), []string(nil))
+	ensure.DeepEqual(t, after.Map(), map[string]float64{
+		"latency_count": 2,
+		"latency_sum":   -4,
+		"requests":      -2,
+	})
+
+	// Quantiles are still checked.
+	ensure.DeepEqual(t, errors(exposetest.Verify(parse(t, `# TYPE latency untyped
+latency{quantile="0.5"} 2
+latency{quantile="0.99"} 1
+`))), []string{
+		"quantile 0.99 of latency is 1, less than 2 for quantile 0.5",
+	})
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+	e := parse(t, "a{x=\"1\",b=\"2\"} 1\nc NaN\nd 4\n")
+	ensure.DeepEqual(t, exposetest.Diff(e, map[string]float64{
+		`a{b="2",x="1"}`: 1,
+		"c":              math.NaN(),
+		"d":              5,
+		"e":              6,
+	}), []string{
+		"d is 4, want 5",
+		"missing e, want 6",
+	})
+	ensure.DeepEqual(t, exposetest.Diff(e, map[string]float64{}), []string{
+		`unexpected a{b="2",x="1"} = 1`,
+		"unexpected c = NaN",
+		"unexpected d = 4",
+	})
+}
+
+// recorder is a testing.TB which records errors.
+type recorder struct {
+	testing.TB
+	errors []string
+	fatal  string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.fatal = fmt.Sprintf(format, args...)
+	panic(r)
+}
+
+func TestReporting(t *testing.T) {
+	t.Parallel()
+	var r recorder
+	e := parse(t, "# TYPE a counter\na -1\n")
+	exposetest.Check(&r, e)
+	exposetest.Equal(&r, e, map[string]float64{"a": 1})
+	exposetest.CheckMonotonic(&r, parse(t, "# TYPE a counter\na 1\n"), e)
+	ensure.DeepEqual(t, r.errors, []string{
+		"exposetest: cumulative series a is -1",
+		"exposetest: a is -1, want 1",
+		"exposetest: cumulative series a decreased from 1 to -1",
+	})
+
+	fatal := func(h http.HandlerFunc) string {
+		var r recorder
+		func() {
+			defer func() { recover() }()
+			exposetest.Scrape(&r, h)
+		}()
+		return r.fatal
+	}
+	ensure.StringContains(t, fatal(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", 500)
+	}), "handler returned 500: boom")
+	ensure.True(t, regexp.MustCompile("promtext: line 1").MatchString(fatal(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "bad{")
+	})))
+}
@@ -0,0 +1,164 @@
+// Package federate scrapes the stats of another local process and re-emits
+// them through a stats.Client, so a sidecar and the process it serves can be
+// exposed as one view. The endpoint may serve the Prometheus text format,
+// OpenMetrics or expvar style JSON, picked by the response Content-Type.
+//
+// Samples are mapped to the Client as follows:
+//
+//	counter, histogram buckets, _sum and _count  BumpSum of the increase since the last scrape
+//	gauge, untyped, summary quantiles            BumpAvg of the value
+//	JSON numbers                                 BumpAvg of the value
+//
+// Labels become "name:value" tags and OpenMetrics _created samples are
+// skipped. The first scrape of a cumulative series only records its value,
+// and a decrease is treated as a reset.
+package federate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+)
+
+// Collector scrapes an endpoint into a Client.
+type Collector struct {
+	// URL is the endpoint to scrape.
+	URL string
+
+	// HTTPClient is used for requests, defaulting to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Client receives the samples.
+	Client stats.Client
+
+	// Prefix is prepended to every key.
+	Prefix string
+
+	// Relabel is called with every sample before it is emitted. It may
+	// change the name and labels, and returns false to drop the sample.
+	Relabel func(*promtext.Sample) bool
+
+	// OnError is called with errors of the scrapes by Run.
+	OnError func(error)
+
+	mu   sync.Mutex
+	last map[string]float64
+}
+
+// Collect scrapes the endpoint once.
+func (c *Collector) Collect() error {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(c.URL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("federate: %s returned %s", c.URL, res.Status)
+	}
+
+	var samples []promtext.Sample
+	contentType := res.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		samples, err = ParseJSON(res.Body)
+	} else {
+		samples, err = promtext.ParseFormat(res.Body, promtext.FormatFromContentType(contentType))
+	}
+	if err != nil {
+		return err
+	}
+	c.emit(samples)
+	return nil
+}
+
+func (c *Collector) emit(samples []promtext.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := make(map[string]float64, len(c.last))
+	for i := range samples {
+		s := &samples[i]
+		if s.Created() || (c.Relabel != nil && !c.Relabel(s)) {
+			continue
+		}
+		tags := s.Tags()
+		key := c.Prefix + s.Name
+
+		if !s.Cumulative() {
+			stats.BumpAvg(c.Client, key, s.Value, tags...)
+			continue
+		}
+		id := key + "\x00" + strings.Join(tags, "\x00")
+		last[id] = s.Value
+		prev, ok := c.last[id]
+		if !ok {
+			continue
+		}
+		delta := s.Value - prev
+		if delta < 0 {
+			delta = s.Value
+		}
+		stats.BumpSum(c.Client, key, delta, tags...)
+	}
+	c.last = last
+}
+
+// Run calls Collect every interval until stop is closed.
+func (c *Collector) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Collect(); err != nil && c.OnError != nil {
+				c.OnError(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ParseJSON parses expvar style JSON into gauge samples. Nested objects are
+// flattened with '.' separated names, and values other than numbers, such
+// as strings and arrays, are skipped.
+func ParseJSON(r io.Reader) ([]promtext.Sample, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var v map[string]interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("federate: invalid JSON: %v", err)
+	}
+	var samples []promtext.Sample
+	flatten(&samples, "", v)
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Name < samples[j].Name
+	})
+	return samples, nil
+}
+
+func flatten(samples *[]promtext.Sample, prefix string, v map[string]interface{}) {
+	for k, child := range v {
+		name := prefix + k
+		switch child := child.(type) {
+		case json.Number:
+			f, err := child.Float64()
+			if err == nil {
+				*samples = append(*samples, promtext.Sample{Name: name, Value: f, Type: promtext.Gauge})
+			}
+		case map[string]interface{}:
+			flatten(samples, name+".", child)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package federate_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/federate"
+	"github.com/facebookgo/stats/promtext"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	bumps []string
+}
+
+func (r *recorder) client() stats.Client {
+	record := func(kind string) func(string, float64, ...string) {
+		return func(key string, val float64, tags ...string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.bumps = append(r.bumps, fmt.Sprintf("%s %s %v %s", kind, key, val, strings.Join(tags, ",")))
+		}
+	}
+	return &stats.HookClient{
+		BumpAvgHook: record("avg"),
+		BumpSumHook: record("sum"),
+	}
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bumps := r.bumps
+	r.bumps = nil
+	sort.Strings(bumps)
+	return bumps
+}
+
+type endpoint struct {
+	mu          sync.Mutex
+	contentType string
+	body        string
+}
+
+func (e *endpoint) set(contentType, body string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.contentType, e.body = contentType, body
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Header().Set("Content-Type", e.contentType)
+	fmt.Fprint(w, e.body)
+}
+
+const textFormat = "text/plain; version=0.0.4"
+
+func scrape(requests, sum, count, p50 int) string {
+	return fmt.Sprintf(`# TYPE requests_total counter
+requests_total{code="200",method="get"} %d
+# TYPE latency summary
+latency{quantile="0.5"} %d
+latency_sum %d
+latency_count %d
+# TYPE temperature gauge
+temperature 21.5
+`, requests, p50, sum, count)
+}
+
+func TestCollectText(t *testing.T) {
+	t.Parallel()
+	var e endpoint
+	server := httptest.NewServer(&e)
+	defer server.Close()
+
+	var r recorder
+	c := &federate.Collector{URL: server.URL, Client: r.client(), Prefix: "app."}
+
+	// The first scrape only records cumulative values.
+	e.set(textFormat, scrape(10, 100, 5, 7))
+	ensure.Nil(t, c.Collect())
+	ensure.DeepEqual(t, r.take(), []string{
+		"avg app.latency 7 quantile:0.5",
+		"avg app.temperature 21.5 ",
+	})
+
+	e.set(textFormat, scrape(15, 130, 8, 9))
+	ensure.Nil(t, c.Collect())
+	ensure.DeepEqual(t, r.take(), []string{
+		"avg app.latency 9 quantile:0.5",
+		"avg app.temperature 21.5 ",
+		"sum app.latency_count 3 ",
+		"sum app.latency_sum 30 ",
+		"sum app.requests_total 5 code:200,method:get",
+	})
+
+	// A restarted process resets its counters.
+	e.set(textFormat, scrape(2, 130, 8, 9))
+	ensure.Nil(t, c.Collect())
+	ensure.DeepEqual(t, r.take()[4], "sum app.requests_total 2 code:200,method:get")
+}
+
+func TestRelabel(t *testing.T) {
+	t.Parallel()
+	var e endpoint
+	server := httptest.NewServer(&e)
+	defer server.Close()
+	e.set(textFormat, scrape(10, 100, 5, 7))
+
+	var r recorder
+	c := &federate.Collector{
+		URL:    server.URL,
+		Client: r.client(),
+		Relabel: func(s *promtext.Sample) bool {
+			if strings.HasPrefix(s.Name, "latency") {
+				return false
+			}
+			s.Name = strings.ToUpper(s.Name)
+			s.Labels = append(s.Labels, promtext.Label{Name: "source", Value: "sidecar"})
+			return true
+		},
+	}
+	ensure.Nil(t, c.Collect())
+	ensure.DeepEqual(t, r.take(), []string{"avg TEMPERATURE 21.5 source:sidecar"})
+}
+
+func TestCollectJSON(t *testing.T) {
+	t.Parallel()
+	var e endpoint
+	server := httptest.NewServer(&e)
+	defer server.Close()
+	e.set("application/json; charset=utf-8", `{
+		"cmdline": ["/bin/app"],
+		"goroutines": 12,
+		"memstats": {"Alloc": 1024, "BySize": [{"Size": 8}], "GC": {"Count": 3}},
+		"version": "1.2"
+	}`)
+
+	var r recorder
+	c := &federate.Collector{URL: server.URL, Client: r.client(), Prefix: "app."}
+	ensure.Nil(t, c.Collect())
+	ensure.DeepEqual(t, r.take(), []string{
+		"avg app.goroutines 12 ",
+		"avg app.memstats.Alloc 1024 ",
+		"avg app.memstats.GC.Count 3 ",
+	})
+}
+
+func TestCollectErrors(t *testing.T) {
+	t.Parallel()
+	var e endpoint
+	server := httptest.NewServer(&e)
+	defer server.Close()
+	c := &federate.Collector{URL: server.URL}
+
+	e.set(textFormat, "bad{ 1")
+	ensure.Err(t, c.Collect(), regexp.MustCompile("promtext: line 1"))
+	e.set("application/json", "[1]")
+	ensure.Err(t, c.Collect(), regexp.MustCompile("federate: invalid JSON"))
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	c.URL = notFound.URL
+	ensure.Err(t, c.Collect(), regexp.MustCompile("returned 404 Not Found"))
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	var e endpoint
+	server := httptest.NewServer(&e)
+	defer server.Close()
+	e.set(textFormat, "bad")
+
+	errs := make(chan error, 100)
+	c := &federate.Collector{
+		URL:     server.URL,
+		OnError: func(err error) { errs <- err },
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Run(time.Millisecond, stop)
+		close(done)
+	}()
+	ensure.Err(t, <-errs, regexp.MustCompile("expected value"))
+	close(stop)
+	<-done
+}
+
+func TestCollectOpenMetrics(t *testing.T) {
+	t.Parallel()
+	var e endpoint
+	server := httptest.NewServer(&e)
+	defer server.Close()
+
+	var r recorder
+	c := &federate.Collector{URL: server.URL, Client: r.client()}
+	for _, total := range []int{3, 5} {
+		e.set(promtext.OpenMetricsContentType, fmt.Sprintf(`# TYPE jobs counter
+jobs_total %d
+jobs_created 1605281325.0
+# EOF
+`, total))
+		ensure.Nil(t, c.Collect())
+	}
+	ensure.DeepEqual(t, r.take(), []string{"sum jobs_total 2 "})
+}
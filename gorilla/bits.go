@@ -0,0 +1,73 @@
+package gorilla
+
+import "errors"
+
+var errShortBlock = errors.New("gorilla: block is truncated")
+
+type bitWriter struct {
+	buf   []byte
+	count uint8 // bits free in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.count == 0 {
+		w.buf = append(w.buf, 0)
+		w.count = 8
+	}
+	w.count--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.count
+	}
+}
+
+// writeBits writes the nbits least significant bits of u, most significant
+// first.
+func (w *bitWriter) writeBits(u uint64, nbits int) {
+	for nbits > 0 {
+		if w.count == 0 {
+			w.buf = append(w.buf, 0)
+			w.count = 8
+		}
+		n := int(w.count)
+		if n > nbits {
+			n = nbits
+		}
+		chunk := byte(u >> uint(nbits-n) & (1<<uint(n) - 1))
+		w.count -= uint8(n)
+		w.buf[len(w.buf)-1] |= chunk << w.count
+		nbits -= n
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // in bits
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errShortBlock
+	}
+	bit := r.buf[r.pos/8]&(0x80>>uint(r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(nbits int) (uint64, error) {
+	if r.pos+nbits > len(r.buf)*8 {
+		return 0, errShortBlock
+	}
+	var u uint64
+	for nbits > 0 {
+		off := uint(r.pos % 8)
+		n := 8 - int(off)
+		if n > nbits {
+			n = nbits
+		}
+		b := r.buf[r.pos/8] << off >> uint(8-n)
+		u = u<<uint(n) | uint64(b)
+		r.pos += n
+		nbits -= n
+	}
+	return u, nil
+}
@@ -0,0 +1,285 @@
+// Package gorilla implements the time series compression described in
+// "Gorilla: A Fast, Scalable, In-Memory Time Series Database". Timestamps
+// are stored as delta-of-deltas and values as the XOR with the previous
+// value, so regular samples of slowly changing values take a few bits each.
+//
+// Timestamps are int64 in any unit, usually milliseconds since the epoch.
+// They don't need to be increasing, but regular intervals compress best.
+package gorilla
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// Encoder appends points to a compressed block.
+type Encoder struct {
+	w     bitWriter
+	count int
+
+	t      int64
+	tDelta int64
+
+	v        uint64
+	leading  int
+	trailing int
+}
+
+// NewEncoder creates an empty Encoder.
+func NewEncoder() *Encoder {
+	return &Encoder{}
+}
+
+// Len returns the number of points appended.
+func (e *Encoder) Len() int {
+	return e.count
+}
+
+// Append adds a point to the block.
+func (e *Encoder) Append(t int64, v float64) {
+	vbits := math.Float64bits(v)
+	if e.count == 0 {
+		e.w.writeBits(uint64(t), 64)
+		e.w.writeBits(vbits, 64)
+		e.t, e.v = t, vbits
+		e.leading = -1
+		e.count++
+		return
+	}
+
+	delta := t - e.t
+	e.writeDod(delta - e.tDelta)
+	e.t, e.tDelta = t, delta
+
+	e.writeXOR(vbits ^ e.v)
+	e.v = vbits
+	e.count++
+}
+
+// Delta-of-delta ranges, each with its control bits and the number of bits
+// used for the value.
+var dodBuckets = []struct {
+	control     uint64
+	controlBits int
+	valueBits   int
+}{
+	{0x2, 2, 7},
+	{0x6, 3, 9},
+	{0xe, 4, 12},
+}
+
+func (e *Encoder) writeDod(dod int64) {
+	if dod == 0 {
+		e.w.writeBit(false)
+		return
+	}
+	for _, b := range dodBuckets {
+		if dod >= -(1<<uint(b.valueBits-1))+1 && dod <= 1<<uint(b.valueBits-1) {
+			e.w.writeBits(b.control, b.controlBits)
+			e.w.writeBits(uint64(dod), b.valueBits)
+			return
+		}
+	}
+	e.w.writeBits(0xf, 4)
+	e.w.writeBits(uint64(dod), 64)
+}
+
+func (e *Encoder) writeXOR(xor uint64) {
+	if xor == 0 {
+		e.w.writeBit(false)
+		return
+	}
+	e.w.writeBit(true)
+	leading := bits.LeadingZeros64(xor)
+	trailing := bits.TrailingZeros64(xor)
+	if leading > 31 {
+		leading = 31
+	}
+
+	// Reuse the previous window if the meaningful bits fit in it.
+	if e.leading >= 0 && leading >= e.leading && trailing >= e.trailing {
+		e.w.writeBit(false)
+		e.w.writeBits(xor>>uint(e.trailing), 64-e.leading-e.trailing)
+		return
+	}
+	e.leading, e.trailing = leading, trailing
+	meaningful := 64 - leading - trailing
+	e.w.writeBit(true)
+	e.w.writeBits(uint64(leading), 5)
+	// 64 meaningful bits doesn't fit in 6 bits, but 0 can't happen so it's
+	// used in its place.
+	e.w.writeBits(uint64(meaningful&63), 6)
+	e.w.writeBits(xor>>uint(trailing), meaningful)
+}
+
+// Bytes returns the encoded block. The Encoder may continue to be appended
+// to, and later calls return the additional points.
+func (e *Encoder) Bytes() []byte {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(e.count))
+	b := make([]byte, n+len(e.w.buf))
+	copy(b, header[:n])
+	copy(b[n:], e.w.buf)
+	return b
+}
+
+var errInvalidBlock = errors.New("gorilla: invalid block")
+
+// Iterator iterates over the points of an encoded block.
+type Iterator struct {
+	r         bitReader
+	remaining uint64
+	read      int
+	err       error
+
+	t      int64
+	tDelta int64
+
+	v        uint64
+	leading  int
+	trailing int
+}
+
+// NewIterator returns an Iterator over the points in the block.
+func NewIterator(b []byte) (*Iterator, error) {
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errInvalidBlock
+	}
+	// The first point takes 128 bits and every other at least 2, which bounds
+	// the count of a valid block.
+	body := b[n:]
+	if count > 0 && (len(body)*8 < 128 || count-1 > uint64(len(body)*8-128)/2) {
+		return nil, errInvalidBlock
+	}
+	return &Iterator{
+		r:         bitReader{buf: body},
+		remaining: count,
+		leading:   -1,
+	}, nil
+}
+
+// Next advances to the next point, returning false when there are no more
+// points or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.remaining == 0 {
+		return false
+	}
+	if err := it.next(); err != nil {
+		it.err = err
+		return false
+	}
+	it.remaining--
+	it.read++
+	return true
+}
+
+func (it *Iterator) next() error {
+	if it.read == 0 {
+		t, err := it.r.readBits(64)
+		if err != nil {
+			return err
+		}
+		v, err := it.r.readBits(64)
+		if err != nil {
+			return err
+		}
+		it.t, it.v = int64(t), v
+		return nil
+	}
+
+	dod, err := it.readDod()
+	if err != nil {
+		return err
+	}
+	it.tDelta += dod
+	it.t += it.tDelta
+
+	return it.readXOR()
+}
+
+func (it *Iterator) readDod() (int64, error) {
+	// The number of leading ones in the control bits selects the bucket.
+	ones := 0
+	for ones < len(dodBuckets)+1 {
+		bit, err := it.r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+	switch ones {
+	case 0:
+		return 0, nil
+	case len(dodBuckets) + 1:
+		u, err := it.r.readBits(64)
+		return int64(u), err
+	}
+	return it.readSigned(dodBuckets[ones-1].valueBits)
+}
+
+// readSigned reads a two's complement value of nbits.
+func (it *Iterator) readSigned(nbits int) (int64, error) {
+	u, err := it.r.readBits(nbits)
+	if err != nil {
+		return 0, err
+	}
+	// Values up to 1<<(nbits-1) are positive, see writeDod.
+	if u > 1<<uint(nbits-1) {
+		return int64(u) - 1<<uint(nbits), nil
+	}
+	return int64(u), nil
+}
+
+func (it *Iterator) readXOR() error {
+	bit, err := it.r.readBit()
+	if err != nil || !bit {
+		return err
+	}
+	bit, err = it.r.readBit()
+	if err != nil {
+		return err
+	}
+	if bit {
+		leading, err := it.r.readBits(5)
+		if err != nil {
+			return err
+		}
+		meaningful, err := it.r.readBits(6)
+		if err != nil {
+			return err
+		}
+		if meaningful == 0 {
+			meaningful = 64
+		}
+		if int(leading)+int(meaningful) > 64 {
+			return errInvalidBlock
+		}
+		it.leading = int(leading)
+		it.trailing = 64 - int(leading) - int(meaningful)
+	} else if it.leading < 0 {
+		// A window can't be reused before one was written.
+		return errInvalidBlock
+	}
+	u, err := it.r.readBits(64 - it.leading - it.trailing)
+	if err != nil {
+		return err
+	}
+	it.v ^= u << uint(it.trailing)
+	return nil
+}
+
+// At returns the current point.
+func (it *Iterator) At() (int64, float64) {
+	return it.t, math.Float64frombits(it.v)
+}
+
+// Err returns the error which stopped iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,169 @@
+package gorilla_test
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"testing/quick"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats/gorilla"
+)
+
+func encode(points []gorilla.Point) []byte {
+	e := gorilla.NewEncoder()
+	for _, p := range points {
+		e.Append(p.T, p.V)
+	}
+	return e.Bytes()
+}
+
+func decode(b []byte) ([]gorilla.Point, error) {
+	it, err := gorilla.NewIterator(b)
+	if err != nil {
+		return nil, err
+	}
+	var points []gorilla.Point
+	for it.Next() {
+		t, v := it.At()
+		points = append(points, gorilla.Point{T: t, V: v})
+	}
+	return points, it.Err()
+}
+
+// equal compares bit for bit, so NaN and -0 round trip exactly.
+func equal(a, b []gorilla.Point) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].T != b[i].T || math.Float64bits(a[i].V) != math.Float64bits(b[i].V) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+	points := []gorilla.Point{
+		{T: 1000, V: 1},
+		{T: 2000, V: 1},
+		{T: 3000, V: 1.5},
+		{T: 4001, V: -2},
+		{T: 5000, V: math.NaN()},
+		{T: 5000, V: math.Inf(1)},
+		{T: 4000, V: math.Inf(-1)},
+		{T: math.MaxInt64, V: math.Copysign(0, -1)},
+		{T: math.MinInt64, V: math.MaxFloat64},
+		{T: 0, V: math.SmallestNonzeroFloat64},
+		{T: 63, V: 0},
+		{T: 190, V: 0},
+		{T: 573, V: 1e300},
+		{T: 2621, V: 1e-300},
+	}
+	decoded, err := decode(encode(points))
+	ensure.Nil(t, err)
+	ensure.True(t, equal(decoded, points), decoded)
+}
+
+func TestEmpty(t *testing.T) {
+	t.Parallel()
+	decoded, err := decode(gorilla.NewEncoder().Bytes())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(decoded), 0)
+}
+
+// Ensure appending after Bytes continues the same block.
+func TestBytesThenAppend(t *testing.T) {
+	t.Parallel()
+	e := gorilla.NewEncoder()
+	e.Append(1, 1)
+	first := e.Bytes()
+	e.Append(2, 2)
+	decoded, err := decode(first)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, decoded, []gorilla.Point{{T: 1, V: 1}})
+	decoded, err = decode(e.Bytes())
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, decoded, []gorilla.Point{{T: 1, V: 1}, {T: 2, V: 2}})
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+	var points []gorilla.Point
+	for i := 0; i < 1000; i++ {
+		points = append(points, gorilla.Point{T: int64(i) * 10000, V: float64(100 + i%3)})
+	}
+	b := encode(points)
+	// 16 bytes per point uncompressed.
+	ensure.True(t, len(b) < 1000*16/4, len(b))
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	t.Parallel()
+	f := func(ts []int64, vs []float64) bool {
+		var points []gorilla.Point
+		for i := 0; i < len(ts) && i < len(vs); i++ {
+			points = append(points, gorilla.Point{T: ts[i], V: vs[i]})
+		}
+		decoded, err := decode(encode(points))
+		return err == nil && equal(decoded, points)
+	}
+	ensure.Nil(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
+}
+
+// Regular timestamps with small jitter exercise the small delta-of-delta
+// buckets.
+func TestRoundTripJitterProperty(t *testing.T) {
+	t.Parallel()
+	f := func(start int64, jitter []int16, vs []float32) bool {
+		var points []gorilla.Point
+		ts := start
+		for i := 0; i < len(jitter) && i < len(vs); i++ {
+			ts += 1000 + int64(jitter[i]%3000)
+			points = append(points, gorilla.Point{T: ts, V: float64(vs[i])})
+		}
+		decoded, err := decode(encode(points))
+		return err == nil && equal(decoded, points)
+	}
+	ensure.Nil(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
+}
+
+func TestTruncated(t *testing.T) {
+	t.Parallel()
+	b := encode([]gorilla.Point{{T: 1, V: 1}, {T: 2, V: 2}, {T: 3, V: 3}})
+	for i := 0; i < len(b)-1; i++ {
+		if _, err := decode(b[:i]); err == nil {
+			t.Fatalf("expected error for truncation at %d", i)
+		}
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(make([]byte, 64))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var points []gorilla.Point
+		for len(data) >= 16 {
+			points = append(points, gorilla.Point{
+				T: int64(binary.LittleEndian.Uint64(data)),
+				V: math.Float64frombits(binary.LittleEndian.Uint64(data[8:])),
+			})
+			data = data[16:]
+		}
+		decoded, err := decode(encode(points))
+		if err != nil || !equal(decoded, points) {
+			t.Fatalf("round trip failed: %v %v %v", err, points, decoded)
+		}
+	})
+}
+
+// Arbitrary input must not panic.
+func FuzzIterator(f *testing.F) {
+	f.Add(encode([]gorilla.Point{{T: 1, V: 1}, {T: 2, V: 3}}))
+	f.Add([]byte{0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decode(data)
+	})
+}
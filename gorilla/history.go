@@ -0,0 +1,129 @@
+package gorilla
+
+import (
+	"sort"
+	"sync"
+)
+
+// Point is a single sample of a series.
+type Point struct {
+	T int64
+	V float64
+}
+
+// History keeps compressed per-series history of aggregated values, for
+// example the output of stats.SimpleCounter.Aggregate at every flush, as tsdb
+// does for the segment being appended to. Each series is a ring of blocks:
+// once the open block reaches PointsPerBlock it is sealed, and only the
+// newest MaxBlocks sealed blocks are kept. It is safe for concurrent use.
+type History struct {
+	pointsPerBlock int
+	maxBlocks      int
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	sealed [][]byte
+	open   *Encoder
+}
+
+// NewHistory creates a History. The history kept per series is between
+// pointsPerBlock*maxBlocks and pointsPerBlock*(maxBlocks+1) points. A
+// maxBlocks of zero or less keeps every block.
+func NewHistory(pointsPerBlock, maxBlocks int) *History {
+	if pointsPerBlock < 1 {
+		pointsPerBlock = 1
+	}
+	return &History{
+		pointsPerBlock: pointsPerBlock,
+		maxBlocks:      maxBlocks,
+		series:         map[string]*series{},
+	}
+}
+
+// Append adds a point to the series for key.
+func (h *History) Append(key string, t int64, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &series{open: NewEncoder()}
+		h.series[key] = s
+	}
+	s.open.Append(t, v)
+	if s.open.Len() >= h.pointsPerBlock {
+		s.sealed = append(s.sealed, s.open.Bytes())
+		if h.maxBlocks > 0 && len(s.sealed) > h.maxBlocks {
+			s.sealed = append(s.sealed[:0], s.sealed[len(s.sealed)-h.maxBlocks:]...)
+		}
+		s.open = NewEncoder()
+	}
+}
+
+// Record appends a point at time t for every key in values.
+func (h *History) Record(t int64, values map[string]float64) {
+	for key, v := range values {
+		h.Append(key, t, v)
+	}
+}
+
+// Keys returns the sorted keys with history.
+func (h *History) Keys() []string {
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	h.mu.Unlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// Query returns the points for key with from <= T < to, in the order they
+// were appended.
+func (h *History) Query(key string, from, to int64) ([]Point, error) {
+	h.mu.Lock()
+	s, ok := h.series[key]
+	var blocks [][]byte
+	if ok {
+		blocks = append(blocks, s.sealed...)
+		if s.open.Len() > 0 {
+			blocks = append(blocks, s.open.Bytes())
+		}
+	}
+	h.mu.Unlock()
+
+	var points []Point
+	for _, b := range blocks {
+		it, err := NewIterator(b)
+		if err != nil {
+			return nil, err
+		}
+		for it.Next() {
+			t, v := it.At()
+			if t >= from && t < to {
+				points = append(points, Point{T: t, V: v})
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// Size returns the total number of encoded bytes held.
+func (h *History) Size() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	size := 0
+	for _, s := range h.series {
+		for _, b := range s.sealed {
+			size += len(b)
+		}
+		size += len(s.open.w.buf)
+	}
+	return size
+}
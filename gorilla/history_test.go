@@ -0,0 +1,47 @@
+package gorilla_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats/gorilla"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+	h := gorilla.NewHistory(2, 2)
+	for i := int64(0); i < 7; i++ {
+		h.Record(i, map[string]float64{"a": float64(i), "b": -float64(i)})
+	}
+	ensure.DeepEqual(t, h.Keys(), []string{"a", "b"})
+
+	// Blocks of [0 1] were dropped, [2 3] [4 5] are sealed and [6] is open.
+	points, err := h.Query("a", math.MinInt64, math.MaxInt64)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, points, []gorilla.Point{
+		{T: 2, V: 2}, {T: 3, V: 3}, {T: 4, V: 4}, {T: 5, V: 5}, {T: 6, V: 6},
+	})
+
+	points, err = h.Query("b", 3, 6)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, points, []gorilla.Point{{T: 3, V: -3}, {T: 4, V: -4}, {T: 5, V: -5}})
+
+	points, err = h.Query("missing", 0, 10)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(points), 0)
+	ensure.True(t, h.Size() > 0)
+}
+
+func TestHistoryUnbounded(t *testing.T) {
+	t.Parallel()
+	for _, maxBlocks := range []int{0, -1} {
+		h := gorilla.NewHistory(2, maxBlocks)
+		for i := int64(0); i < 7; i++ {
+			h.Record(i, map[string]float64{"a": float64(i)})
+		}
+		points, err := h.Query("a", math.MinInt64, math.MaxInt64)
+		ensure.Nil(t, err)
+		ensure.DeepEqual(t, len(points), 7, maxBlocks)
+	}
+}
@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// CounterHandle bumps a fixed key with a fixed aggregation type. Handles are
+// usually populated by Init. The zero value is a no-op.
+type CounterHandle struct {
+	Key    string
+	Type   Type
+	Client Client
+}
+
+// Bump bumps the value using the aggregation type of the handle.
+func (h CounterHandle) Bump(val float64, tags ...string) {
+	switch h.Type {
+	case AggregateAvg:
+		BumpAvg(h.Client, h.Key, val, tags...)
+	case AggregateSum:
+		BumpSum(h.Client, h.Key, val, tags...)
+	case AggregateHistogram:
+		BumpHistogram(h.Client, h.Key, val, tags...)
+	case AggregateMin:
+		BumpMin(h.Client, h.Key, val, tags...)
+	case AggregateMax:
+		BumpMax(h.Client, h.Key, val, tags...)
+	case AggregateLast:
+		BumpLast(h.Client, h.Key, val, tags...)
+	}
+}
+
+// TimerHandle times operations for a fixed key. Handles are usually populated
+// by Init. The zero value is a no-op.
+type TimerHandle struct {
+	Key    string
+	Client Client
+}
+
+// Start starts the timer. Call End on the returned value to finish it.
+func (h TimerHandle) Start(tags ...string) interface {
+	End()
+} {
+	return BumpTime(h.Client, h.Key, tags...)
+}
+
+// Record records an already measured duration with RecordDuration, so it
+// matches what Start records.
+func (h TimerHandle) Record(d time.Duration, tags ...string) {
+	RecordDuration(h.Client, h.Key, d, tags...)
+}
+
+var (
+	counterHandleType = reflect.TypeOf(CounterHandle{})
+	timerHandleType   = reflect.TypeOf(TimerHandle{})
+
+	tagTypes = map[string]Type{
+		"avg":       AggregateAvg,
+		"sum":       AggregateSum,
+		"histogram": AggregateHistogram,
+		"min":       AggregateMin,
+		"max":       AggregateMax,
+		"last":      AggregateLast,
+	}
+)
+
+// Init populates the CounterHandle and TimerHandle fields of the struct
+// pointed to by v, using the "stats" struct tag of each field. The tag
+// contains the key and the aggregation type:
+//
+//	type ServerMetrics struct {
+//		Requests stats.CounterHandle `stats:"requests,sum"`
+//		Latency  stats.TimerHandle   `stats:"latency,histogram"`
+//		DB       DBMetrics           `stats:"db."`
+//	}
+//
+// The prefix is prepended to every key. Nested structs with a tag are
+// initialized with the tag added to the prefix. Fields without a tag, or
+// with the tag "-", are ignored.
+func Init(v interface{}, client Client, prefix string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("stats: Init requires a non-nil pointer to a struct, got %T", v)
+	}
+	return initStruct(rv.Elem(), client, prefix)
+}
+
+func initStruct(rv reflect.Value, client Client, prefix string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup("stats")
+		if !ok || tag == "-" {
+			continue
+		}
+		if field.PkgPath != "" {
+			return fmt.Errorf("stats: unexported field %s.%s has a stats tag", rt, field.Name)
+		}
+		name, typ := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, typ = tag[:i], tag[i+1:]
+		}
+		if name == "" {
+			return fmt.Errorf("stats: missing key in tag for field %s.%s", rt, field.Name)
+		}
+		key := prefix + name
+
+		switch field.Type {
+		case counterHandleType:
+			t, ok := tagTypes[typ]
+			if !ok {
+				return fmt.Errorf("stats: invalid aggregation type %q for field %s.%s", typ, rt, field.Name)
+			}
+			rv.Field(i).Set(reflect.ValueOf(CounterHandle{
+				Key:    key,
+				Type:   t,
+				Client: client,
+			}))
+		case timerHandleType:
+			if typ != "" && typ != "histogram" {
+				return fmt.Errorf("stats: invalid aggregation type %q for timer field %s.%s", typ, rt, field.Name)
+			}
+			rv.Field(i).Set(reflect.ValueOf(TimerHandle{
+				Key:    key,
+				Client: client,
+			}))
+		default:
+			if field.Type.Kind() != reflect.Struct || typ != "" {
+				return fmt.Errorf("stats: unsupported type %s for field %s.%s", field.Type, rt, field.Name)
+			}
+			if err := initStruct(rv.Field(i), client, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package stats_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+type dbMetrics struct {
+	Queries stats.CounterHandle `stats:"queries,sum"`
+}
+
+type serverMetrics struct {
+	Requests stats.CounterHandle `stats:"requests,sum"`
+	Load     stats.CounterHandle `stats:"load,avg"`
+	Size     stats.CounterHandle `stats:"size,histogram"`
+	Peak     stats.CounterHandle `stats:"peak,max"`
+	Latency  stats.TimerHandle   `stats:"latency,histogram"`
+	DB       dbMetrics           `stats:"db."`
+	Ignored  stats.CounterHandle `stats:"-"`
+	Other    int
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+	var bumps []string
+	record := func(kind string) func(string, float64, ...string) {
+		return func(key string, val float64, tags ...string) {
+			bumps = append(bumps, kind+":"+key)
+		}
+	}
+	hc := &stats.HookClient{
+		BumpAvgHook:       record("avg"),
+		BumpSumHook:       record("sum"),
+		BumpHistogramHook: record("histogram"),
+		BumpMaxHook:       record("max"),
+		BumpTimeHook: func(key string, tags ...string) interface {
+			End()
+		} {
+			bumps = append(bumps, "time:"+key)
+			return stats.NoOpEnd
+		},
+	}
+
+	var m serverMetrics
+	ensure.Nil(t, stats.Init(&m, hc, "server."))
+	m.Requests.Bump(1)
+	m.Load.Bump(1)
+	m.Size.Bump(1)
+	m.Peak.Bump(1)
+	m.Latency.Start().End()
+	m.Latency.Record(time.Second)
+	m.DB.Queries.Bump(1)
+	m.Ignored.Bump(1)
+
+	ensure.DeepEqual(t, bumps, []string{
+		"sum:server.requests",
+		"avg:server.load",
+		"histogram:server.size",
+		"max:server.peak",
+		"time:server.latency",
+		"sum:server.latency.total",
+		"histogram:server.latency",
+		"sum:server.db.queries",
+	})
+}
+
+func TestInitErrors(t *testing.T) {
+	t.Parallel()
+	var notStruct int
+	ensure.Err(t, stats.Init(&notStruct, nil, ""), regexp.MustCompile("pointer to a struct"))
+	ensure.Err(t, stats.Init(serverMetrics{}, nil, ""), regexp.MustCompile("pointer to a struct"))
+
+	var badType struct {
+		A stats.CounterHandle `stats:"a,gauge"`
+	}
+	ensure.Err(t, stats.Init(&badType, nil, ""), regexp.MustCompile(`invalid aggregation type "gauge"`))
+
+	var missingKey struct {
+		A stats.CounterHandle `stats:",sum"`
+	}
+	ensure.Err(t, stats.Init(&missingKey, nil, ""), regexp.MustCompile("missing key"))
+
+	var unsupported struct {
+		A int `stats:"a,sum"`
+	}
+	ensure.Err(t, stats.Init(&unsupported, nil, ""), regexp.MustCompile("unsupported type int"))
+}
+
+// Ensure zero value handles are no-ops.
+func TestZeroHandles(t *testing.T) {
+	t.Parallel()
+	var m serverMetrics
+	m.Requests.Bump(1)
+	m.Latency.Start().End()
+	m.Latency.Record(time.Second)
+}
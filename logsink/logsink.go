@@ -0,0 +1,271 @@
+// Package logsink emits aggregated counters as structured log lines, for
+// hosts which only have log shipping. Every counter in a flush becomes one
+// message, either RFC 5424 syslog with structured data on the local syslog
+// socket, or a systemd journal entry using the journal native protocol.
+//
+// A syslog message looks like:
+//
+//	<190>1 2017-01-02T03:04:05Z host app 123 stats [stats@32473 key="rpc.latency" type="histogram" count="21" value="10" p50="10" tag.method="get"] rpc.latency=10
+//
+// and a journal entry has the fields MESSAGE, PRIORITY, SYSLOG_IDENTIFIER,
+// STATS_KEY, STATS_TYPE, STATS_COUNT, STATS_VALUE, STATS_P50 and so on, and
+// STATS_TAG_METHOD and so on.
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/stats"
+)
+
+// Format is the wire format of the messages.
+type Format int
+
+const (
+	// Syslog is RFC 5424 with structured data.
+	Syslog Format = iota
+
+	// Journal is the systemd journal native protocol.
+	Journal
+)
+
+// Default socket paths.
+const (
+	DefaultSyslogPath  = "/dev/log"
+	DefaultJournalPath = "/run/systemd/journal/socket"
+)
+
+// SDID is the structured data ID used for syslog messages. 32473 is the
+// private enterprise number reserved for documentation in RFC 5612.
+const SDID = "stats@32473"
+
+// Sink writes flushes to a local unix datagram socket.
+type Sink struct {
+	// Format is the wire format.
+	Format Format
+
+	// AppName is the syslog APP-NAME and journal SYSLOG_IDENTIFIER. It
+	// defaults to the program name.
+	AppName string
+
+	// Hostname is the syslog HOSTNAME. It defaults to os.Hostname.
+	Hostname string
+
+	// Facility is the syslog facility, defaulting to 23 (local7).
+	Facility int
+
+	// Severity is the syslog severity and journal PRIORITY, defaulting to 6
+	// (informational).
+	Severity int
+
+	// Quantiles are reported for histograms, defaulting to
+	// stats.HistogramPercentiles.
+	Quantiles map[string]float64
+
+	conn net.Conn
+}
+
+// Dial connects to the socket at path, using the default path for the format
+// if path is empty.
+func Dial(format Format, path string) (*Sink, error) {
+	if path == "" {
+		path = DefaultSyslogPath
+		if format == Journal {
+			path = DefaultJournalPath
+		}
+	}
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Sink{
+		Format:   format,
+		AppName:  filepath.Base(os.Args[0]),
+		Hostname: hostname,
+		Facility: 23,
+		Severity: 6,
+		conn:     conn,
+	}, nil
+}
+
+// Write sends one message per counter.
+func (s *Sink) Write(t time.Time, counters stats.Aggregates) error {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e := s.entry(counters[key])
+		var msg []byte
+		if s.Format == Journal {
+			msg = s.journalMessage(e)
+		} else {
+			msg = s.syslogMessage(t, e)
+		}
+		if _, err := s.conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the socket.
+func (s *Sink) Close() error {
+	return s.conn.Close()
+}
+
+type field struct {
+	name, value string
+}
+
+type entry struct {
+	key    string
+	value  string
+	fields []field
+	tags   []field
+}
+
+func (s *Sink) entry(c stats.Counter) *entry {
+	p := stats.NewPoint(c, s.Quantiles)
+	e := &entry{
+		key:   p.Key,
+		value: formatFloat(p.Value),
+	}
+	e.fields = []field{
+		{"key", e.key},
+		{"type", p.Type.String()},
+		{"count", strconv.Itoa(p.Count)},
+		{"value", e.value},
+	}
+	for _, q := range p.Quantiles {
+		e.fields = append(e.fields, field{q.Label, formatFloat(q.Value)})
+	}
+	for _, tag := range p.Tags {
+		k, v := stats.SplitTag(tag)
+		e.tags = append(e.tags, field{k, v})
+	}
+	sort.Slice(e.tags, func(i, j int) bool {
+		return e.tags[i].name < e.tags[j].name
+	})
+	return e
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// nilValue is used for empty syslog header fields.
+const nilValue = "-"
+
+func (s *Sink) syslogMessage(t time.Time, e *entry) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d stats [%s",
+		s.Facility*8+s.Severity,
+		t.UTC().Format(time.RFC3339Nano),
+		headerField(s.Hostname, 255),
+		headerField(s.AppName, 48),
+		os.Getpid(),
+		SDID,
+	)
+	for _, f := range e.fields {
+		writeParam(&b, f.name, f.value)
+	}
+	for _, f := range e.tags {
+		writeParam(&b, "tag."+f.name, f.value)
+	}
+	fmt.Fprintf(&b, "] %s=%s", e.key, e.value)
+	return b.Bytes()
+}
+
+// headerField returns a valid syslog header field: printable ASCII without
+// spaces, truncated to max.
+func headerField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return nilValue
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+var paramEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// writeParam writes a structured data parameter. Names are limited to 32
+// printable ASCII characters excluding '=', ' ', ']' and '"'.
+func writeParam(b *bytes.Buffer, name, value string) {
+	name = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	fmt.Fprintf(b, ` %s="%s"`, name, paramEscaper.Replace(value))
+}
+
+func (s *Sink) journalMessage(e *entry) []byte {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", e.key+"="+e.value)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(s.Severity))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", s.AppName)
+	for _, f := range e.fields {
+		writeJournalField(&b, "STATS_"+journalName(f.name), f.value)
+	}
+	for _, f := range e.tags {
+		writeJournalField(&b, "STATS_TAG_"+journalName(f.name), f.value)
+	}
+	return b.Bytes()
+}
+
+// journalName converts a name to the journal field name alphabet of upper
+// case letters, digits and underscores.
+func journalName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// writeJournalField writes a field, using the binary form if the value
+// contains a newline.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.Write(size[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,106 @@
+package logsink_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/logsink"
+)
+
+var flushTime = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func listen(t *testing.T) (*net.UnixConn, string) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	ensure.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func receive(t *testing.T, conn *net.UnixConn, n int) []string {
+	var msgs []string
+	buf := make([]byte, 65536)
+	for i := 0; i < n; i++ {
+		ensure.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		size, err := conn.Read(buf)
+		ensure.Nil(t, err)
+		msgs = append(msgs, string(buf[:size]))
+	}
+	return msgs
+}
+
+func sample() stats.Aggregates {
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "a.sum", Values: []float64{1, 2}, Type: stats.AggregateSum})
+	a.Add(&stats.SimpleCounter{
+		Key:    "b.avg",
+		Values: []float64{3},
+		Type:   stats.AggregateAvg,
+		Tags:   []string{"quote:a\"b]c", "line=x\ny"},
+	})
+	return a
+}
+
+func TestSyslog(t *testing.T) {
+	t.Parallel()
+	conn, path := listen(t)
+	s, err := logsink.Dial(logsink.Syslog, path)
+	ensure.Nil(t, err)
+	defer s.Close()
+	s.AppName = "app"
+	s.Hostname = "my host"
+	ensure.Nil(t, s.Write(flushTime, sample()))
+
+	pid := os.Getpid()
+	ensure.DeepEqual(t, receive(t, conn, 2), []string{
+		fmt.Sprintf(`<190>1 2017-01-02T03:04:05Z myhost app %d stats [stats@32473 key="a.sum" type="sum" count="2" value="3"] a.sum=3`, pid),
+		fmt.Sprintf(`<190>1 2017-01-02T03:04:05Z myhost app %d stats [stats@32473 key="b.avg" type="avg" count="1" value="3" tag.line="x`+"\n"+`y" tag.quote="a\"b\]c"] b.avg=3`, pid),
+	})
+}
+
+func TestSyslogHistogram(t *testing.T) {
+	t.Parallel()
+	conn, path := listen(t)
+	s, err := logsink.Dial(logsink.Syslog, path)
+	ensure.Nil(t, err)
+	defer s.Close()
+	s.AppName = "app"
+	s.Hostname = ""
+	s.Quantiles = map[string]float64{"p99": 0.99, "p50": 0.5}
+
+	var values []float64
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(i))
+	}
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "h", Values: values, Type: stats.AggregateHistogram})
+	ensure.Nil(t, s.Write(flushTime, a))
+	ensure.DeepEqual(t, receive(t, conn, 1), []string{
+		fmt.Sprintf(`<190>1 2017-01-02T03:04:05Z - app %d stats [stats@32473 key="h" type="histogram" count="21" value="10" p50="10" p99="20"] h=10`, os.Getpid()),
+	})
+}
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+	conn, path := listen(t)
+	s, err := logsink.Dial(logsink.Journal, path)
+	ensure.Nil(t, err)
+	defer s.Close()
+	s.AppName = "app"
+	ensure.Nil(t, s.Write(flushTime, sample()))
+
+	ensure.DeepEqual(t, receive(t, conn, 2), []string{
+		"MESSAGE=a.sum=3\nPRIORITY=6\nSYSLOG_IDENTIFIER=app\n" +
+			"STATS_KEY=a.sum\nSTATS_TYPE=sum\nSTATS_COUNT=2\nSTATS_VALUE=3\n",
+		"MESSAGE=b.avg=3\nPRIORITY=6\nSYSLOG_IDENTIFIER=app\n" +
+			"STATS_KEY=b.avg\nSTATS_TYPE=avg\nSTATS_COUNT=1\nSTATS_VALUE=3\n" +
+			"STATS_TAG_LINE\n\x03\x00\x00\x00\x00\x00\x00\x00x\ny\n" +
+			"STATS_TAG_QUOTE=a\"b]c\n",
+	})
+}
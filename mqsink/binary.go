@@ -0,0 +1,136 @@
+package mqsink
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Binary encodes a message in a compact binary form, the same varint and
+// float layout the tsdb segments use, which keeps NaN and ±Inf as they are:
+//
+//	varint time in Unix nanoseconds
+//	string key
+//	uvarint tag count, count * string tag
+//	string type
+//	uvarint count
+//	uint64 float bits of the value
+//	uvarint quantile count, count * (string label, uint64 float bits)
+//
+// where a string is its uvarint length followed by its bytes. Quantiles are
+// sorted by label. DecodeBinary reverses it.
+func Binary(m *Message) ([]byte, error) {
+	b := make([]byte, 0, 64+len(m.Key))
+	b = binary.AppendVarint(b, m.Time.UnixNano())
+	b = appendString(b, m.Key)
+	b = binary.AppendUvarint(b, uint64(len(m.Tags)))
+	for _, tag := range m.Tags {
+		b = appendString(b, tag)
+	}
+	b = appendString(b, m.Type)
+	b = binary.AppendUvarint(b, uint64(m.Count))
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(m.Value))
+
+	labels := make([]string, 0, len(m.Quantiles))
+	for label := range m.Quantiles {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	b = binary.AppendUvarint(b, uint64(len(labels)))
+	for _, label := range labels {
+		b = appendString(b, label)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(m.Quantiles[label]))
+	}
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+var errCorruptMessage = errors.New("mqsink: corrupt binary message")
+
+// decoder reads the fields of a binary message, remembering the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errCorruptMessage
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errCorruptMessage
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// count reads a length, which can't exceed the remaining bytes.
+func (d *decoder) count() int {
+	l := d.uvarint()
+	if l > uint64(len(d.b)) {
+		d.err = errCorruptMessage
+		return 0
+	}
+	return int(l)
+}
+
+func (d *decoder) string() string {
+	l := d.count()
+	s := string(d.b[:l])
+	d.b = d.b[l:]
+	return s
+}
+
+func (d *decoder) float() float64 {
+	if len(d.b) < 8 {
+		d.err = errCorruptMessage
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+// DecodeBinary decodes a message encoded by Binary.
+func DecodeBinary(b []byte) (*Message, error) {
+	d := &decoder{b: b}
+	m := &Message{Time: time.Unix(0, d.varint()).UTC(), Key: d.string()}
+	if n := d.count(); n > 0 {
+		m.Tags = make([]string, n)
+		for i := range m.Tags {
+			m.Tags[i] = d.string()
+		}
+	}
+	m.Type = d.string()
+	m.Count = int(d.uvarint())
+	m.Value = d.float()
+	if n := d.count(); n > 0 {
+		m.Quantiles = make(map[string]float64, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			label := d.string()
+			m.Quantiles[label] = d.float()
+		}
+	}
+	if d.err == nil && len(d.b) != 0 {
+		d.err = errCorruptMessage
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
@@ -0,0 +1,279 @@
+// Package mqsink publishes aggregated counters to a message queue such as
+// Kafka or NATS. It only depends on the small Publisher interface, so the
+// queue client of choice is plugged in by the caller:
+//
+//	sink := &mqsink.Sink{Publisher: myKafkaPublisher, Topic: "stats"}
+//	err := sink.Write(ctx, time.Now(), aggregates)
+//
+// Every counter in a flush becomes one message, encoded as JSON or with the
+// compact Binary encoding, and keyed by the FullKey of the counter, its key
+// and tags, so queues which partition by message key keep each series in
+// order on one partition.
+package mqsink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stats"
+)
+
+// Publisher publishes a message to a topic. The key is used by the queue to
+// pick a partition.
+type Publisher interface {
+	Publish(ctx context.Context, topic, key string, value []byte) error
+}
+
+// Message is the serialized form of one counter at one flush.
+type Message struct {
+	Time      time.Time          `json:"time"`
+	Key       string             `json:"key"`
+	Tags      []string           `json:"tags,omitempty"`
+	Type      string             `json:"type"`
+	Count     int                `json:"count"`
+	Value     float64            `json:"value"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// jsonMessage is the JSON form of a Message.
+type jsonMessage struct {
+	Time      time.Time         `json:"time"`
+	Key       string            `json:"key"`
+	Tags      []string          `json:"tags,omitempty"`
+	Type      string            `json:"type"`
+	Count     int               `json:"count"`
+	Value     number            `json:"value"`
+	Quantiles map[string]number `json:"quantiles,omitempty"`
+}
+
+// number is a float64 which JSON encodes NaN and ±Inf as the strings "NaN",
+// "+Inf" and "-Inf", since JSON numbers can't hold them.
+type number float64
+
+func (n number) MarshalJSON() ([]byte, error) {
+	v := float64(n)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte(strconv.Quote(strconv.FormatFloat(v, 'g', -1, 64))), nil
+	}
+	return json.Marshal(v)
+}
+
+func (n *number) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '"' {
+		return json.Unmarshal(b, (*float64)(n))
+	}
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(math.IsNaN(v) || math.IsInf(v, 0)) {
+		return fmt.Errorf("mqsink: invalid value %s", b)
+	}
+	*n = number(v)
+	return nil
+}
+
+// MarshalJSON encodes the message as a JSON object, with NaN and ±Inf
+// values as strings.
+func (m Message) MarshalJSON() ([]byte, error) {
+	j := jsonMessage{
+		Time:  m.Time,
+		Key:   m.Key,
+		Tags:  m.Tags,
+		Type:  m.Type,
+		Count: m.Count,
+		Value: number(m.Value),
+	}
+	if m.Quantiles != nil {
+		j.Quantiles = make(map[string]number, len(m.Quantiles))
+		for label, v := range m.Quantiles {
+			j.Quantiles[label] = number(v)
+		}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON.
+func (m *Message) UnmarshalJSON(b []byte) error {
+	var j jsonMessage
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*m = Message{
+		Time:  j.Time,
+		Key:   j.Key,
+		Tags:  j.Tags,
+		Type:  j.Type,
+		Count: j.Count,
+		Value: float64(j.Value),
+	}
+	if j.Quantiles != nil {
+		m.Quantiles = make(map[string]float64, len(j.Quantiles))
+		for label, v := range j.Quantiles {
+			m.Quantiles[label] = float64(v)
+		}
+	}
+	return nil
+}
+
+// Encoder serializes a message.
+type Encoder func(*Message) ([]byte, error)
+
+// JSON encodes a message as a JSON object. NaN and ±Inf values are encoded
+// as the strings "NaN", "+Inf" and "-Inf".
+func JSON(m *Message) ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Sink publishes flushes to a Publisher.
+type Sink struct {
+	// Publisher receives the messages.
+	Publisher Publisher
+
+	// Topic is the topic messages are published to.
+	Topic string
+
+	// Encoder serializes messages, defaulting to JSON. Binary is the
+	// compact alternative.
+	Encoder Encoder
+
+	// Quantiles are reported for histograms, defaulting to
+	// stats.HistogramPercentiles.
+	Quantiles map[string]float64
+}
+
+// Write publishes one message per counter in key order. A message which
+// fails to encode or publish doesn't stop the others, and the errors are
+// returned joined. It stops early if ctx is done.
+func (s *Sink) Write(ctx context.Context, t time.Time, counters stats.Aggregates) error {
+	encode := s.Encoder
+	if encode == nil {
+		encode = JSON
+	}
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		b, err := encode(s.message(t, counters[key]))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mqsink: encoding %s: %w", key, err))
+			continue
+		}
+		if err := s.Publisher.Publish(ctx, s.Topic, key, b); err != nil {
+			errs = append(errs, fmt.Errorf("mqsink: publishing %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sink) message(t time.Time, c stats.Counter) *Message {
+	p := stats.NewPoint(c, s.Quantiles)
+	m := &Message{
+		Time:  t.UTC(),
+		Key:   p.Key,
+		Tags:  p.Tags,
+		Type:  p.Type.String(),
+		Count: p.Count,
+		Value: p.Value,
+	}
+	if len(p.Quantiles) > 0 {
+		m.Quantiles = map[string]float64{}
+		for _, q := range p.Quantiles {
+			m.Quantiles[q.Label] = q.Value
+		}
+	}
+	return m
+}
+
+// Partition maps a key to one of n partitions with a stable hash, for
+// publishers which need to pick the partition themselves.
+func Partition(key string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// Record is a message published to a MemoryPublisher.
+type Record struct {
+	Topic     string
+	Key       string
+	Value     []byte
+	Partition int
+}
+
+// MemoryPublisher is a Publisher which keeps messages in memory, partitioned
+// by key, for tests. It is safe for concurrent use.
+type MemoryPublisher struct {
+	// Partitions is the number of partitions per topic, defaulting to 1.
+	Partitions int
+
+	// Err is returned by Publish if set, in which case the message isn't
+	// kept.
+	Err error
+
+	mu      sync.Mutex
+	records []Record
+}
+
+// Publish is part of the Publisher interface.
+func (p *MemoryPublisher) Publish(ctx context.Context, topic, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.records = append(p.records, Record{
+		Topic:     topic,
+		Key:       key,
+		Value:     append([]byte(nil), value...),
+		Partition: Partition(key, p.Partitions),
+	})
+	return nil
+}
+
+// Records returns the messages published to topic in publish order.
+func (p *MemoryPublisher) Records(topic string) []Record {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var records []Record
+	for _, r := range p.records {
+		if r.Topic == topic {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Partition returns the messages published to one partition of topic in
+// publish order.
+func (p *MemoryPublisher) Partition(topic string, partition int) []Record {
+	var records []Record
+	for _, r := range p.Records(topic) {
+		if r.Partition == partition {
+			records = append(records, r)
+		}
+	}
+	return records
+}
@@ -0,0 +1,201 @@
+package mqsink_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/mqsink"
+)
+
+var flushTime = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func sample() stats.Aggregates {
+	var values []float64
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(i))
+	}
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "a.sum", Values: []float64{1, 2}, Type: stats.AggregateSum})
+	a.Add(&stats.SimpleCounter{Key: "b.avg", Values: []float64{3}, Type: stats.AggregateAvg, Tags: []string{"host:a"}})
+	a.Add(&stats.SimpleCounter{Key: "c.hist", Values: values, Type: stats.AggregateHistogram})
+	return a
+}
+
+func decode(t *testing.T, records []mqsink.Record) []mqsink.Message {
+	var msgs []mqsink.Message
+	for _, r := range records {
+		var m mqsink.Message
+		ensure.Nil(t, json.Unmarshal(r.Value, &m))
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Parallel()
+	var p mqsink.MemoryPublisher
+	s := &mqsink.Sink{
+		Publisher: &p,
+		Topic:     "stats",
+		Quantiles: map[string]float64{"p50": 0.5},
+	}
+	ensure.Nil(t, s.Write(context.Background(), flushTime, sample()))
+	ensure.DeepEqual(t, len(p.Records("other")), 0)
+	ensure.DeepEqual(t, p.Records("stats")[1].Key, "b.avg{host:a}")
+	ensure.DeepEqual(t, decode(t, p.Records("stats")), []mqsink.Message{
+		{Time: flushTime, Key: "a.sum", Type: "sum", Count: 2, Value: 3},
+		{Time: flushTime, Key: "b.avg", Tags: []string{"host:a"}, Type: "avg", Count: 1, Value: 3},
+		{Time: flushTime, Key: "c.hist", Type: "histogram", Count: 21, Value: 10, Quantiles: map[string]float64{"p50": 10}},
+	})
+}
+
+func TestPartitioning(t *testing.T) {
+	t.Parallel()
+	p := mqsink.MemoryPublisher{Partitions: 4}
+	s := &mqsink.Sink{Publisher: &p, Topic: "stats"}
+	for i := 0; i < 3; i++ {
+		ensure.Nil(t, s.Write(context.Background(), flushTime.Add(time.Duration(i)*time.Second), sample()))
+	}
+	total := 0
+	for partition := 0; partition < 4; partition++ {
+		records := p.Partition("stats", partition)
+		total += len(records)
+		for _, r := range records {
+			ensure.DeepEqual(t, mqsink.Partition(r.Key, 4), partition)
+		}
+	}
+	ensure.DeepEqual(t, total, 9)
+
+	// Each series stays in order on its partition.
+	var times []time.Time
+	for _, m := range decode(t, p.Partition("stats", mqsink.Partition("b.avg{host:a}", 4))) {
+		if m.Key == "b.avg" {
+			times = append(times, m.Time)
+		}
+	}
+	ensure.DeepEqual(t, times, []time.Time{flushTime, flushTime.Add(time.Second), flushTime.Add(2 * time.Second)})
+
+	ensure.DeepEqual(t, mqsink.Partition("anything", 0), 0)
+	ensure.DeepEqual(t, mqsink.Partition("anything", 1), 0)
+}
+
+func TestCustomEncoder(t *testing.T) {
+	t.Parallel()
+	var p mqsink.MemoryPublisher
+	s := &mqsink.Sink{
+		Publisher: &p,
+		Topic:     "stats",
+		Encoder: func(m *mqsink.Message) ([]byte, error) {
+			return []byte(m.Key), nil
+		},
+	}
+	ensure.Nil(t, s.Write(context.Background(), flushTime, sample()))
+	records := p.Records("stats")
+	ensure.DeepEqual(t, len(records), 3)
+	ensure.DeepEqual(t, string(records[0].Value), "a.sum")
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+	p := mqsink.MemoryPublisher{Err: errors.New("boom")}
+	s := &mqsink.Sink{Publisher: &p, Topic: "stats"}
+	err := s.Write(context.Background(), flushTime, sample())
+	ensure.True(t, errors.Is(err, p.Err))
+	ensure.StringContains(t, err.Error(), "mqsink: publishing c.hist: boom")
+
+	// A message which fails to encode doesn't stop the others.
+	encodeErr := errors.New("encode")
+	var ok mqsink.MemoryPublisher
+	s = &mqsink.Sink{
+		Publisher: &ok,
+		Topic:     "stats",
+		Encoder: func(m *mqsink.Message) ([]byte, error) {
+			if m.Key == "b.avg" {
+				return nil, encodeErr
+			}
+			return mqsink.JSON(m)
+		},
+	}
+	err = s.Write(context.Background(), flushTime, sample())
+	ensure.True(t, errors.Is(err, encodeErr))
+	ensure.DeepEqual(t, err.Error(), "mqsink: encoding b.avg{host:a}: encode")
+	ensure.DeepEqual(t, len(ok.Records("stats")), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s = &mqsink.Sink{Publisher: &mqsink.MemoryPublisher{}}
+	ensure.True(t, errors.Is(s.Write(ctx, flushTime, sample()), context.Canceled))
+}
+
+func nonFinite() stats.Aggregates {
+	a := sample()
+	a.Add(&stats.SimpleCounter{Key: "d.nan", Values: []float64{math.NaN()}, Type: stats.AggregateLast})
+	a.Add(&stats.SimpleCounter{Key: "e.inf", Values: []float64{math.Inf(-1), math.Inf(1)}, Type: stats.AggregateMax})
+	values := []float64{math.Inf(-1)}
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(i))
+	}
+	a.Add(&stats.SimpleCounter{Key: "f.inf", Values: values, Type: stats.AggregateHistogram})
+	return a
+}
+
+func TestWriteNonFinite(t *testing.T) {
+	t.Parallel()
+	var p mqsink.MemoryPublisher
+	s := &mqsink.Sink{Publisher: &p, Topic: "stats", Quantiles: map[string]float64{"p0": 0}}
+	ensure.Nil(t, s.Write(context.Background(), flushTime, nonFinite()))
+	records := p.Records("stats")
+	ensure.DeepEqual(t, len(records), 6)
+	ensure.StringContains(t, string(records[3].Value), `"value":"NaN"`)
+	ensure.StringContains(t, string(records[4].Value), `"value":"+Inf"`)
+	ensure.StringContains(t, string(records[5].Value), `"quantiles":{"p0":"-Inf"}`)
+
+	msgs := decode(t, records)
+	ensure.True(t, math.IsNaN(msgs[3].Value))
+	ensure.DeepEqual(t, msgs[4].Value, math.Inf(1))
+	ensure.DeepEqual(t, msgs[5].Quantiles, map[string]float64{"p0": math.Inf(-1)})
+
+	var m mqsink.Message
+	ensure.Err(t, json.Unmarshal([]byte(`{"value":"1"}`), &m), regexp.MustCompile("invalid value"))
+}
+
+func TestBinary(t *testing.T) {
+	t.Parallel()
+	var p mqsink.MemoryPublisher
+	s := &mqsink.Sink{
+		Publisher: &p,
+		Topic:     "stats",
+		Encoder:   mqsink.Binary,
+		Quantiles: map[string]float64{"p50": 0.5, "p0": 0},
+	}
+	ensure.Nil(t, s.Write(context.Background(), flushTime, nonFinite()))
+	var msgs []mqsink.Message
+	for _, r := range p.Records("stats") {
+		m, err := mqsink.DecodeBinary(r.Value)
+		ensure.Nil(t, err)
+		msgs = append(msgs, *m)
+	}
+	ensure.DeepEqual(t, len(msgs), 6)
+	ensure.DeepEqual(t, msgs[:3], []mqsink.Message{
+		{Time: flushTime, Key: "a.sum", Type: "sum", Count: 2, Value: 3},
+		{Time: flushTime, Key: "b.avg", Tags: []string{"host:a"}, Type: "avg", Count: 1, Value: 3},
+		{Time: flushTime, Key: "c.hist", Type: "histogram", Count: 21, Value: 10, Quantiles: map[string]float64{"p0": 0, "p50": 10}},
+	})
+	ensure.True(t, math.IsNaN(msgs[3].Value))
+	ensure.DeepEqual(t, msgs[4].Value, math.Inf(1))
+	ensure.DeepEqual(t, msgs[5].Quantiles["p0"], math.Inf(-1))
+
+	// Truncated or padded messages are rejected.
+	b := p.Records("stats")[2].Value
+	for _, corrupt := range [][]byte{nil, b[:len(b)-1], append(b[:len(b):len(b)], 0)} {
+		_, err := mqsink.DecodeBinary(corrupt)
+		ensure.Err(t, err, regexp.MustCompile("corrupt binary message"))
+	}
+}
@@ -0,0 +1,68 @@
+package stats
+
+// Point is a counter aggregated for export, the form sinks write.
+type Point struct {
+	// Key is the key without the tags.
+	Key  string
+	Tags []string
+	Type Type
+
+	// Count is the number of values after applying the value policy.
+	Count int
+
+	// Value is the single value reported for the aggregation type, as
+	// returned by AggregateValue.
+	Value float64
+
+	// Sum is the sum of the values, which histograms report alongside the
+	// average.
+	Sum float64
+
+	// Quantiles are only set for histograms with more than
+	// MinSamplesForPercentiles values. They are sorted by P.
+	Quantiles []Quantile
+}
+
+// Summarizer is implemented by histogram counters whose values are only a
+// sample, such as ReservoirCounter, so their exact count and sum are
+// exported.
+type Summarizer interface {
+	Summary(percentiles map[string]float64) *Summary
+}
+
+// NewPoint aggregates a counter for export. Tagged counters are exported
+// under GetKey with their tags, others under FullKey without tags. The
+// Policy of a SimpleCounter is applied, otherwise InvalidValuePolicy.
+// Percentiles default to HistogramPercentiles.
+func NewPoint(c Counter, percentiles map[string]float64) *Point {
+	if percentiles == nil {
+		percentiles = HistogramPercentiles
+	}
+	p := &Point{Key: c.FullKey(), Type: c.GetType()}
+	if t, ok := c.(Tagged); ok {
+		p.Key, p.Tags = t.GetKey(), t.GetTags()
+	}
+	policy := ValueDefault
+	if s, ok := c.(*SimpleCounter); ok {
+		policy = s.Policy
+	}
+	values, _ := ValidValues(c.GetValues(), policy)
+
+	if p.Type != AggregateHistogram {
+		p.Count = len(values)
+		p.Value = aggregateValue(p.Type, values, policy)
+		p.Sum = sum(values, policy)
+		return p
+	}
+	var s *Summary
+	if summarizer, ok := c.(Summarizer); ok {
+		s = summarizer.Summary(percentiles)
+	} else {
+		s = summarize(values, percentiles, policy)
+	}
+	p.Count, p.Value, p.Sum = s.Count, s.Mean, s.Sum
+	if s.Count > MinSamplesForPercentiles {
+		p.Quantiles = s.Quantiles
+	}
+	return p
+}
@@ -0,0 +1,66 @@
+package stats_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+func TestNewPoint(t *testing.T) {
+	t.Parallel()
+	p := stats.NewPoint(&stats.SimpleCounter{
+		Key:    "sum",
+		Values: []float64{1, math.NaN(), 2},
+		Type:   stats.AggregateSum,
+		Tags:   []string{"b:2", "a:1"},
+		Policy: stats.ValueDrop,
+	}, nil)
+	ensure.DeepEqual(t, p, &stats.Point{
+		Key:   "sum",
+		Tags:  []string{"b:2", "a:1"},
+		Type:  stats.AggregateSum,
+		Count: 2,
+		Value: 3,
+		Sum:   3,
+	})
+
+	var values []float64
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(i))
+	}
+	p = stats.NewPoint(&stats.SimpleCounter{Key: "h", Values: values, Type: stats.AggregateHistogram},
+		map[string]float64{"p50": 0.5, "max": 1})
+	ensure.DeepEqual(t, p, &stats.Point{
+		Key:   "h",
+		Type:  stats.AggregateHistogram,
+		Count: 21,
+		Value: 10,
+		Sum:   210,
+		Quantiles: []stats.Quantile{
+			{Label: "p50", P: 0.5, Value: 10},
+			{Label: "max", P: 1, Value: 20},
+		},
+	})
+
+	// Too few values for quantiles.
+	p = stats.NewPoint(&stats.SimpleCounter{Key: "h", Values: values[:3], Type: stats.AggregateHistogram}, nil)
+	ensure.DeepEqual(t, len(p.Quantiles), 0)
+	ensure.DeepEqual(t, p.Count, 3)
+}
+
+// Reservoirs export their exact count and sum rather than the sample's.
+func TestNewPointReservoir(t *testing.T) {
+	t.Parallel()
+	r := stats.NewReservoirCounter("r", 10)
+	for i := 1; i <= 100; i++ {
+		r.AddValues(float64(i))
+	}
+	p := stats.NewPoint(r, nil)
+	ensure.DeepEqual(t, p.Key, "r")
+	ensure.DeepEqual(t, p.Count, 100)
+	ensure.DeepEqual(t, p.Sum, 5050.0)
+	ensure.DeepEqual(t, p.Value, 50.5)
+	ensure.DeepEqual(t, len(p.Quantiles), 3)
+}
@@ -0,0 +1,75 @@
+package promtext
+
+import (
+	"strings"
+
+	"github.com/facebookgo/stats"
+)
+
+// Cumulative reports if the sample is a monotonic total: a counter, or the
+// buckets, _sum or _count of a histogram or summary.
+func (s *Sample) Cumulative() bool {
+	if s.Created() {
+		return false
+	}
+	switch s.Type {
+	case Counter, Histogram:
+		return true
+	case Summary:
+		return s.Label("quantile") == ""
+	}
+	return false
+}
+
+// Created reports if the sample is the OpenMetrics creation time of a
+// counter, summary or histogram rather than a value.
+func (s *Sample) Created() bool {
+	switch s.Type {
+	case Counter, Summary, Histogram:
+		return strings.HasSuffix(s.Name, "_created")
+	}
+	return false
+}
+
+// Feed bumps the samples on the Client, with labels as tags. Cumulative
+// samples are bumped with BumpSum and others with BumpAvg. Created samples
+// are skipped.
+func Feed(c stats.Client, samples []Sample) {
+	for i := range samples {
+		s := &samples[i]
+		switch {
+		case s.Created():
+		case s.Cumulative():
+			stats.BumpSum(c, s.Name, s.Value, s.Tags()...)
+		default:
+			stats.BumpAvg(c, s.Name, s.Value, s.Tags()...)
+		}
+	}
+}
+
+// ToAggregates converts samples to counters, with the same mapping as Feed:
+// cumulative samples are sums and others averages, keyed by name with the
+// labels as tags. Repeated series are merged.
+func ToAggregates(samples []Sample) (stats.Aggregates, error) {
+	a := stats.Aggregates{}
+	for i := range samples {
+		s := &samples[i]
+		if s.Created() {
+			continue
+		}
+		typ := stats.AggregateAvg
+		if s.Cumulative() {
+			typ = stats.AggregateSum
+		}
+		err := a.Add(&stats.SimpleCounter{
+			Key:    s.Name,
+			Values: []float64{s.Value},
+			Type:   typ,
+			Tags:   s.Tags(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,82 @@
+package promtext_test
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+)
+
+func TestFeed(t *testing.T) {
+	t.Parallel()
+	samples, err := promtext.ParseFormat(strings.NewReader(openMetrics), promtext.OpenMetrics)
+	ensure.Nil(t, err)
+
+	var bumps []string
+	record := func(kind string) func(string, float64, ...string) {
+		return func(key string, val float64, tags ...string) {
+			bumps = append(bumps, fmt.Sprintf("%s %s %v %s", kind, key, val, strings.Join(tags, ",")))
+		}
+	}
+	promtext.Feed(&stats.HookClient{BumpAvgHook: record("avg"), BumpSumHook: record("sum")}, samples)
+	ensure.DeepEqual(t, bumps, []string{
+		"sum acme_http_router_request_seconds_sum 9036.32 method:GET,path:/api/v1",
+		"sum acme_http_router_request_seconds_count 807283 method:GET,path:/api/v1",
+		"avg go_goroutines 69 ",
+		"sum process_cpu_seconds_total 4.2 ",
+		"avg build_info 1 version:1.2",
+		"sum request_size_bucket 3 le:100",
+		"sum request_size_bucket 5 le:+Inf",
+		"sum request_size_count 5 ",
+		"sum request_size_sum 420 ",
+		"avg stray 1 ",
+	})
+
+	// A nil Client is ignored.
+	promtext.Feed(nil, samples)
+}
+
+func TestToAggregates(t *testing.T) {
+	t.Parallel()
+	samples, err := promtext.Parse(strings.NewReader(exposition))
+	ensure.Nil(t, err)
+	a, err := promtext.ToAggregates(samples)
+	ensure.Nil(t, err)
+
+	var keys []string
+	for key, c := range a {
+		keys = append(keys, fmt.Sprintf("%s %s %v", key, c.GetType(), c.GetValues()))
+	}
+	sort.Strings(keys)
+	ensure.DeepEqual(t, keys, []string{
+		`http_request_duration_seconds_bucket{le:+Inf} sum [144320]`,
+		`http_request_duration_seconds_bucket{le:0.05} sum [24054]`,
+		`http_request_duration_seconds_count sum [144320]`,
+		`http_request_duration_seconds_sum sum [53423]`,
+		`http_requests_total{code:200,method:post} sum [1027]`,
+		`http_requests_total{code:400,method:post} sum [3]`,
+		`metric_without_timestamp_and_labels avg [12.47]`,
+		"msdos_file_access_time_seconds{error:Cannot find file:\n\"FILE.TXT\",path:C:\\DIR\\FILE.TXT} avg [1.458255915e+09]",
+		`rpc_duration_seconds_count sum [2693]`,
+		`rpc_duration_seconds_sum sum [1.7560473e+07]`,
+		`rpc_duration_seconds{quantile:0.5} avg [4773]`,
+		`something_weird{problem:division by zero} avg [+Inf]`,
+		`temperature avg [NaN]`,
+	})
+
+	// Written back, series keep their names and labels.
+	var b bytes.Buffer
+	ensure.Nil(t, promtext.Write(&b, stats.Aggregates{
+		"a": a["http_requests_total{code:200,method:post}"],
+		"b": a["http_requests_total{code:400,method:post}"],
+	}, nil))
+	ensure.DeepEqual(t, b.String(), `# TYPE http_requests_total untyped
+http_requests_total{code="200",method="post"} 1027
+http_requests_total{code="400",method="post"} 3
+`)
+}
@@ -0,0 +1,473 @@
+package promtext
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a metric family.
+type Type string
+
+// Metric family types. GaugeHistogram, Info, StateSet and Unknown only
+// exist in OpenMetrics.
+const (
+	Counter        Type = "counter"
+	Gauge          Type = "gauge"
+	Summary        Type = "summary"
+	Histogram      Type = "histogram"
+	Untyped        Type = "untyped"
+	GaugeHistogram Type = "gaugehistogram"
+	Info           Type = "info"
+	StateSet       Type = "stateset"
+	Unknown        Type = "unknown"
+)
+
+// suffixes are the sample name suffixes of each type, after the family name.
+var suffixes = []struct {
+	typ      Type
+	suffixes []string
+}{
+	{Counter, []string{"_total", "_created"}},
+	{Summary, []string{"_sum", "_count", "_created"}},
+	{Histogram, []string{"_bucket", "_sum", "_count", "_created"}},
+	{GaugeHistogram, []string{"_bucket", "_gsum", "_gcount"}},
+	{Info, []string{"_info"}},
+}
+
+// Format is an exposition format.
+type Format int
+
+const (
+	// TextFormat is the Prometheus text format, version 0.0.4.
+	TextFormat Format = iota
+
+	// OpenMetrics is the OpenMetrics text format, version 1.0.0. It
+	// requires a final "# EOF" line, has timestamps in seconds and allows
+	// exemplars, which are skipped.
+	OpenMetrics
+)
+
+// OpenMetricsContentType is the content type of the OpenMetrics format.
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// FormatFromContentType returns the format of a response Content-Type.
+func FormatFromContentType(contentType string) Format {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/openmetrics-text" {
+		return OpenMetrics
+	}
+	return TextFormat
+}
+
+// Sample is a parsed sample.
+type Sample struct {
+	// Name is the metric name, including suffixes such as _sum.
+	Name string
+
+	// Labels are the labels in the order they were written.
+	Labels []Label
+
+	// Value is the sample value.
+	Value float64
+
+	// Timestamp is in milliseconds since the epoch, or 0 if absent.
+	Timestamp int64
+
+	// Type is the type of the family the sample belongs to, from its TYPE
+	// line, or Untyped.
+	Type Type
+}
+
+// Label returns the value of the label with name, or "" if absent.
+func (s *Sample) Label(name string) string {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// Tags returns the labels as sorted "name:value" tags.
+func (s *Sample) Tags() []string {
+	tags := make([]string, len(s.Labels))
+	for i, l := range s.Labels {
+		tags[i] = l.Name + ":" + l.Value
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Family is a metric family with its metadata and samples.
+type Family struct {
+	Name    string
+	Type    Type
+	Help    string
+	Unit    string
+	Samples []Sample
+}
+
+// Parse parses the Prometheus text format.
+func Parse(r io.Reader) ([]Sample, error) {
+	return ParseFormat(r, TextFormat)
+}
+
+// ParseFormat parses samples in the given format.
+func ParseFormat(r io.Reader, format Format) ([]Sample, error) {
+	families, err := ParseFamilies(r, format)
+	if err != nil {
+		return nil, err
+	}
+	var samples []Sample
+	for _, f := range families {
+		samples = append(samples, f.Samples...)
+	}
+	return samples, nil
+}
+
+// ParseFamilies parses families in the given format. Families are returned
+// in the order they first appear, and samples without metadata are put in
+// an Untyped family of their own name. Samples of a family need not be
+// contiguous, and are returned grouped with their family.
+func ParseFamilies(r io.Reader, format Format) ([]*Family, error) {
+	p := parser{format: format, families: map[string]*Family{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(strings.TrimRight(scanner.Text(), "\r")); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if format == OpenMetrics && !p.eof {
+		return nil, fmt.Errorf("promtext: missing # EOF")
+	}
+	return p.order, nil
+}
+
+type parser struct {
+	format   Format
+	line     int
+	eof      bool
+	families map[string]*Family
+	typed    map[string]bool
+	order    []*Family
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("promtext: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) family(name string) *Family {
+	f, ok := p.families[name]
+	if !ok {
+		typ := Untyped
+		if p.format == OpenMetrics {
+			typ = Unknown
+		}
+		f = &Family{Name: name, Type: typ}
+		p.families[name] = f
+		p.order = append(p.order, f)
+	}
+	return f
+}
+
+func (p *parser) parseLine(line string) error {
+	if p.eof {
+		return p.errorf("content after # EOF")
+	}
+	line = strings.TrimLeft(line, " \t")
+	if line == "" {
+		return nil
+	}
+	if line[0] == '#' {
+		return p.parseComment(line[1:])
+	}
+	return p.parseSample(line)
+}
+
+func (p *parser) parseComment(line string) error {
+	fields := strings.Fields(line)
+	if p.format == OpenMetrics && len(fields) == 1 && fields[0] == "EOF" {
+		p.eof = true
+		return nil
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+	switch fields[0] {
+	case "TYPE", "HELP":
+	case "UNIT":
+		if p.format != OpenMetrics {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if !validMetricName(fields[1]) {
+		return p.errorf("invalid metric name %q", fields[1])
+	}
+	f := p.family(fields[1])
+	switch fields[0] {
+	case "HELP":
+		// The help text is everything after the name, with escapes.
+		_, rest, _ := strings.Cut(strings.TrimLeft(line, " \t"), " ")
+		_, rest, _ = strings.Cut(strings.TrimLeft(rest, " \t"), " ")
+		help, err := p.unescape(rest, false)
+		if err != nil {
+			return err
+		}
+		f.Help = help
+	case "UNIT":
+		if len(fields) == 3 {
+			f.Unit = fields[2]
+		}
+	case "TYPE":
+		if len(fields) != 3 {
+			return p.errorf("malformed TYPE line")
+		}
+		typ := Type(fields[2])
+		switch typ {
+		case Counter, Gauge, Summary, Histogram:
+		case Untyped:
+			if p.format == OpenMetrics {
+				return p.errorf("unknown type %q", fields[2])
+			}
+		case GaugeHistogram, Info, StateSet, Unknown:
+			if p.format != OpenMetrics {
+				return p.errorf("unknown type %q", fields[2])
+			}
+		default:
+			return p.errorf("unknown type %q", fields[2])
+		}
+		if p.typed == nil {
+			p.typed = map[string]bool{}
+		}
+		if p.typed[fields[1]] {
+			return p.errorf("duplicate TYPE line for %s", fields[1])
+		}
+		if len(f.Samples) > 0 {
+			return p.errorf("TYPE line for %s after its samples", fields[1])
+		}
+		p.typed[fields[1]] = true
+		f.Type = typ
+	}
+	return nil
+}
+
+// familyOf returns the family a sample name belongs to.
+func (p *parser) familyOf(name string) *Family {
+	if f, ok := p.families[name]; ok && p.typed[name] {
+		return f
+	}
+	for _, t := range suffixes {
+		for _, suffix := range t.suffixes {
+			base := strings.TrimSuffix(name, suffix)
+			if base == name {
+				continue
+			}
+			if f, ok := p.families[base]; ok && f.Type == t.typ {
+				return f
+			}
+		}
+	}
+	return p.family(name)
+}
+
+func (p *parser) parseSample(line string) error {
+	var s Sample
+	i := 0
+	for i < len(line) && isNameChar(line[i], i == 0, true) {
+		i++
+	}
+	s.Name = line[:i]
+	if s.Name == "" {
+		return p.errorf("missing metric name")
+	}
+	rest := strings.TrimLeft(line[i:], " \t")
+	if strings.HasPrefix(rest, "{") {
+		labels, n, err := p.parseLabels(rest)
+		if err != nil {
+			return err
+		}
+		s.Labels = labels
+		rest = rest[n:]
+	}
+	if p.format == OpenMetrics {
+		if i := strings.Index(rest, " # "); i >= 0 {
+			rest = rest[:i]
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return p.errorf("expected value and optional timestamp after %s", s.Name)
+	}
+	v, err := ParseValue(fields[0])
+	if err != nil {
+		return p.errorf("invalid value %q", fields[0])
+	}
+	s.Value = v
+	if len(fields) == 2 {
+		if s.Timestamp, err = p.parseTimestamp(fields[1]); err != nil {
+			return p.errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	f := p.familyOf(s.Name)
+	s.Type = f.Type
+	f.Samples = append(f.Samples, s)
+	return nil
+}
+
+// parseTimestamp returns a timestamp in milliseconds. OpenMetrics
+// timestamps are in seconds and may have a fraction.
+func (p *parser) parseTimestamp(s string) (int64, error) {
+	if p.format != OpenMetrics {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid timestamp")
+	}
+	return int64(math.Round(f * 1000)), nil
+}
+
+// unescape handles the escapes of label values, and of help text where
+// '"' isn't escaped in the text format.
+func (p *parser) unescape(s string, quote bool) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case '\\':
+				c = '\\'
+			case '"':
+				if !quote && p.format != OpenMetrics {
+					b.WriteByte('\\')
+				}
+				c = '"'
+			default:
+				if quote || p.format == OpenMetrics {
+					return "", p.errorf("invalid escape %q", s[i-1:i+1])
+				}
+				b.WriteByte('\\')
+				c = s[i]
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// parseLabels parses a label set starting at the '{' and returns the number
+// of bytes consumed.
+func (p *parser) parseLabels(line string) ([]Label, int, error) {
+	labels := []Label{}
+	seen := map[string]bool{}
+	i := 1
+	skipSpace := func() {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+	}
+	for {
+		skipSpace()
+		if i < len(line) && line[i] == '}' {
+			return labels, i + 1, nil
+		}
+		start := i
+		for i < len(line) && isNameChar(line[i], i == start, false) {
+			i++
+		}
+		name := line[start:i]
+		if name == "" {
+			return nil, 0, p.errorf("invalid label name")
+		}
+		if seen[name] {
+			return nil, 0, p.errorf("duplicate label %s", name)
+		}
+		seen[name] = true
+		skipSpace()
+		if i >= len(line) || line[i] != '=' {
+			return nil, 0, p.errorf("expected '=' after label %s", name)
+		}
+		i++
+		skipSpace()
+		if i >= len(line) || line[i] != '"' {
+			return nil, 0, p.errorf("expected '\"' for value of label %s", name)
+		}
+		i++
+		start = i
+		for i < len(line) && line[i] != '"' {
+			if line[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(line) {
+			return nil, 0, p.errorf("unterminated value of label %s", name)
+		}
+		value, err := p.unescape(line[start:i], true)
+		if err != nil {
+			return nil, 0, err
+		}
+		i++
+		labels = append(labels, Label{name, value})
+		skipSpace()
+		if i < len(line) && line[i] == ',' {
+			i++
+			continue
+		}
+		if i < len(line) && line[i] == '}' {
+			return labels, i + 1, nil
+		}
+		return nil, 0, p.errorf("expected ',' or '}' after label %s", name)
+	}
+}
+
+func isNameChar(c byte, first, colons bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(colons && c == ':') || (!first && c >= '0' && c <= '9')
+}
+
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i], i == 0, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseValue parses a sample value, accepting the +Inf, -Inf and NaN
+// spellings.
+func ParseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf", "Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
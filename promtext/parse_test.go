@@ -0,0 +1,224 @@
+package promtext_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+)
+
+const exposition = `# HELP http_requests_total The total number of requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{ method = "post" , code="400", } 3   1395066363000
+
+# A normal comment.
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+metric_without_timestamp_and_labels 12.47
+something_weird{problem="division by zero"} +Inf -3982045
+
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054
+http_request_duration_seconds_bucket{le="+Inf"} 144320
+http_request_duration_seconds_sum 53423
+http_request_duration_seconds_count 144320
+# TYPE temperature gauge
+temperature{} NaN
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	samples, err := promtext.Parse(strings.NewReader(strings.Replace(exposition, "\n", "\r\n", 3)))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(samples), 13)
+	ensure.True(t, math.IsNaN(samples[12].Value))
+	samples[12].Value = 0
+
+	l := func(pairs ...string) []promtext.Label {
+		var labels []promtext.Label
+		for i := 0; i < len(pairs); i += 2 {
+			labels = append(labels, promtext.Label{Name: pairs[i], Value: pairs[i+1]})
+		}
+		return labels
+	}
+	ensure.DeepEqual(t, samples, []promtext.Sample{
+		{Name: "http_requests_total", Labels: l("method", "post", "code", "200"), Value: 1027, Timestamp: 1395066363000, Type: promtext.Counter},
+		{Name: "http_requests_total", Labels: l("method", "post", "code", "400"), Value: 3, Timestamp: 1395066363000, Type: promtext.Counter},
+		{Name: "msdos_file_access_time_seconds", Labels: l("path", `C:\DIR\FILE.TXT`, "error", "Cannot find file:\n\"FILE.TXT\""), Value: 1.458255915e9, Type: promtext.Untyped},
+		{Name: "metric_without_timestamp_and_labels", Value: 12.47, Type: promtext.Untyped},
+		{Name: "something_weird", Labels: l("problem", "division by zero"), Value: math.Inf(1), Timestamp: -3982045, Type: promtext.Untyped},
+		{Name: "rpc_duration_seconds", Labels: l("quantile", "0.5"), Value: 4773, Type: promtext.Summary},
+		{Name: "rpc_duration_seconds_sum", Value: 1.7560473e+07, Type: promtext.Summary},
+		{Name: "rpc_duration_seconds_count", Value: 2693, Type: promtext.Summary},
+		{Name: "http_request_duration_seconds_bucket", Labels: l("le", "0.05"), Value: 24054, Type: promtext.Histogram},
+		{Name: "http_request_duration_seconds_bucket", Labels: l("le", "+Inf"), Value: 144320, Type: promtext.Histogram},
+		{Name: "http_request_duration_seconds_sum", Value: 53423, Type: promtext.Histogram},
+		{Name: "http_request_duration_seconds_count", Value: 144320, Type: promtext.Histogram},
+		{Name: "temperature", Labels: []promtext.Label{}, Type: promtext.Gauge},
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Input, Error string
+	}{
+		{"1abc 1", "line 1: missing metric name"},
+		{"a{b=\"c\"", "line 1: expected ',' or '}' after label b"},
+		{"a{b=\"c} 1", "unterminated value of label b"},
+		{"a{b=c} 1", "expected '\"' for value of label b"},
+		{"a{b=\"\\x\"} 1", "invalid escape"},
+		{"a{b=\"1\",b=\"2\"} 1", "duplicate label b"},
+		{"a{=\"1\"} 1", "invalid label name"},
+		{"a", "expected value"},
+		{"a 1 2 3", "expected value"},
+		{"a one", `invalid value "one"`},
+		{"a 1 soon", `invalid timestamp "soon"`},
+		{"\n# TYPE a thing", "line 2: unknown type"},
+		{"# TYPE a counter\n# TYPE a gauge", "duplicate TYPE line"},
+		{"# TYPE a", "malformed TYPE line"},
+		{"# HELP 1a help", "invalid metric name"},
+	}
+	for _, c := range cases {
+		_, err := promtext.Parse(strings.NewReader(c.Input))
+		ensure.Err(t, err, regexp.MustCompile(regexp.QuoteMeta(c.Error)), c.Input)
+	}
+}
+
+func TestWriteParse(t *testing.T) {
+	t.Parallel()
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{
+		Key:    "rpc.requests",
+		Values: []float64{1, 2},
+		Type:   stats.AggregateSum,
+		Tags:   []string{"path:/a\"b\\c\nd"},
+	})
+	a.Add(&stats.SimpleCounter{Key: "latency", Values: histogramValues(), Type: stats.AggregateHistogram})
+
+	var b bytes.Buffer
+	ensure.Nil(t, promtext.Write(&b, a, map[string]float64{"p50": 0.5}))
+	samples, err := promtext.Parse(&b)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, samples, []promtext.Sample{
+		{Name: "latency", Labels: []promtext.Label{{Name: "quantile", Value: "0.5"}}, Value: 10, Type: promtext.Untyped},
+		{Name: "latency_count", Value: 21, Type: promtext.Untyped},
+		{Name: "latency_sum", Value: 210, Type: promtext.Untyped},
+		{Name: "rpc_requests", Labels: []promtext.Label{{Name: "path", Value: "/a\"b\\c\nd"}}, Value: 3, Type: promtext.Untyped},
+	})
+	ensure.DeepEqual(t, samples[3].Label("path"), "/a\"b\\c\nd")
+	ensure.DeepEqual(t, samples[3].Label("missing"), "")
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(exposition)
+	f.Add(openMetrics)
+	f.Add("a{b=\"\\n\"} 1 2\n")
+	f.Fuzz(func(t *testing.T, input string) {
+		promtext.Parse(strings.NewReader(input))
+		promtext.ParseFormat(strings.NewReader(input), promtext.OpenMetrics)
+	})
+}
+
+const openMetrics = `# TYPE acme_http_router_request_seconds summary
+# UNIT acme_http_router_request_seconds seconds
+# HELP acme_http_router_request_seconds Latency "though" all of ACME's\nrouters.
+acme_http_router_request_seconds_sum{path="/api/v1",method="GET"} 9036.32
+acme_http_router_request_seconds_count{path="/api/v1",method="GET"} 807283.0
+acme_http_router_request_seconds_created{path="/api/v1",method="GET"} 1605281325.0
+# TYPE go_goroutines gauge
+go_goroutines 69 1605281325.5
+# TYPE process_cpu_seconds counter
+# UNIT process_cpu_seconds seconds
+process_cpu_seconds_total 4.2
+# TYPE build info
+build_info{version="1.2"} 1
+# TYPE request_size histogram
+request_size_bucket{le="100"} 3 # {trace_id="KOO5S4vxi0o"} 67.5 1601587180.8
+request_size_bucket{le="+Inf"} 5
+request_size_count 5
+request_size_sum 420
+stray 1
+# EOF
+`
+
+func TestParseOpenMetrics(t *testing.T) {
+	t.Parallel()
+	families, err := promtext.ParseFamilies(strings.NewReader(openMetrics), promtext.OpenMetrics)
+	ensure.Nil(t, err)
+
+	type summary struct {
+		Name, Help, Unit string
+		Type             promtext.Type
+		Samples          []string
+	}
+	var got []summary
+	for _, f := range families {
+		s := summary{Name: f.Name, Help: f.Help, Unit: f.Unit, Type: f.Type}
+		for _, sample := range f.Samples {
+			s.Samples = append(s.Samples, fmt.Sprintf("%s %v %d %s",
+				sample.Name, sample.Value, sample.Timestamp, strings.Join(sample.Tags(), ",")))
+		}
+		got = append(got, s)
+	}
+	ensure.DeepEqual(t, got, []summary{
+		{"acme_http_router_request_seconds", "Latency \"though\" all of ACME's\nrouters.", "seconds", promtext.Summary, []string{
+			"acme_http_router_request_seconds_sum 9036.32 0 method:GET,path:/api/v1",
+			"acme_http_router_request_seconds_count 807283 0 method:GET,path:/api/v1",
+			"acme_http_router_request_seconds_created 1.605281325e+09 0 method:GET,path:/api/v1",
+		}},
+		{"go_goroutines", "", "", promtext.Gauge, []string{"go_goroutines 69 1605281325500 "}},
+		{"process_cpu_seconds", "", "seconds", promtext.Counter, []string{"process_cpu_seconds_total 4.2 0 "}},
+		{"build", "", "", promtext.Info, []string{"build_info 1 0 version:1.2"}},
+		{"request_size", "", "", promtext.Histogram, []string{
+			"request_size_bucket 3 0 le:100",
+			"request_size_bucket 5 0 le:+Inf",
+			"request_size_count 5 0 ",
+			"request_size_sum 420 0 ",
+		}},
+		{"stray", "", "", promtext.Unknown, []string{"stray 1 0 "}},
+	})
+}
+
+func TestParseOpenMetricsErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Input, Error string
+	}{
+		{"a 1\n", "missing # EOF"},
+		{"# EOF\na 1\n", "line 2: content after # EOF"},
+		{"# TYPE a untyped\n# EOF\n", "unknown type"},
+		{`a{b="\x"} 1` + "\n# EOF\n", "invalid escape"},
+		{"a 1 soon\n# EOF\n", "invalid timestamp"},
+		{"a 1\n# TYPE a gauge\n# EOF\n", "TYPE line for a after its samples"},
+	}
+	for _, c := range cases {
+		_, err := promtext.ParseFormat(strings.NewReader(c.Input), promtext.OpenMetrics)
+		ensure.Err(t, err, regexp.MustCompile(regexp.QuoteMeta(c.Error)), c.Input)
+	}
+
+	// Types and metadata only valid in one format.
+	_, err := promtext.Parse(strings.NewReader("# TYPE a info\n"))
+	ensure.Err(t, err, regexp.MustCompile("unknown type"))
+	families, err := promtext.ParseFamilies(strings.NewReader("# UNIT a seconds\n# HELP a x\\y \\\"z\\\"\n"), promtext.TextFormat)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, families[0].Unit, "")
+	ensure.DeepEqual(t, families[0].Help, `x\y \"z\"`)
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, promtext.FormatFromContentType(promtext.OpenMetricsContentType), promtext.OpenMetrics)
+	ensure.DeepEqual(t, promtext.FormatFromContentType("text/plain; version=0.0.4"), promtext.TextFormat)
+	ensure.DeepEqual(t, promtext.FormatFromContentType(""), promtext.TextFormat)
+}
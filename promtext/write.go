@@ -0,0 +1,214 @@
+// Package promtext implements the Prometheus text exposition format. Write
+// exposes aggregated counters and Parse reads the output of any exporter.
+//
+// When writing, counter keys become metric names and tags become labels.
+// Sums are written as untyped, since a flush holds the sum over an interval
+// rather than a monotonic total, averages, minimums, maximums and last
+// values as gauges. Histograms are written as untyped as well, with a series
+// per quantile plus key_sum and key_count families, since their count and
+// sum also cover a single flush where a summary's would be cumulative.
+package promtext
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/facebookgo/stats"
+)
+
+// MetricName converts a key to a valid metric name by replacing invalid
+// characters with '_'.
+func MetricName(key string) string {
+	return sanitize(key, true)
+}
+
+// LabelName converts a tag key to a valid label name by replacing invalid
+// characters with '_'. Names starting with "__" are reserved by Prometheus
+// and get an extra "_tag" prefix.
+func LabelName(name string) string {
+	name = sanitize(name, false)
+	if strings.HasPrefix(name, "__") {
+		name = "_tag" + name
+	}
+	return name
+}
+
+func sanitize(s string, colons bool) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(colons && c == ':') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// Label is a label pair.
+type Label struct {
+	Name, Value string
+}
+
+// Labels converts tags to sorted labels. Tags with an empty value are
+// skipped, as Prometheus treats them as absent, and if two tags map to the
+// same label name the first wins.
+func Labels(tags []string) []Label {
+	var labels []Label
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		k, v := stats.SplitTag(tag)
+		k = LabelName(k)
+		if v == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		labels = append(labels, Label{k, v})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
+
+type family struct {
+	typ   Type
+	lines []string
+
+	// owner is the metric name of the counters writing the family, which
+	// differs from the family name for the _sum and _count of histograms.
+	owner string
+}
+
+// Write writes the counters in the text format, grouping series of the same
+// metric name into one family. Quantiles default to
+// stats.HistogramPercentiles. It returns an error if counters of different
+// types map to the same metric name, if the _sum or _count family of a
+// histogram is also the name of another counter, or if a histogram has a
+// quantile tag.
+func Write(w io.Writer, counters stats.Aggregates, quantiles map[string]float64) error {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	families := map[string]*family{}
+	var names []string
+	for _, key := range keys {
+		c := counters[key]
+		p := stats.NewPoint(c, quantiles)
+		name := MetricName(p.Key)
+		typ := Untyped
+		switch p.Type {
+		case stats.AggregateAvg, stats.AggregateMin, stats.AggregateMax, stats.AggregateLast:
+			typ = Gauge
+		}
+		add := func(name string, labels []Label, value float64) error {
+			owner := MetricName(p.Key)
+			f, ok := families[name]
+			if !ok {
+				f = &family{typ: typ, owner: owner}
+				families[name] = f
+				names = append(names, name)
+			} else if f.typ != typ {
+				return fmt.Errorf("promtext: conflicting types %s and %s for %s", f.typ, typ, name)
+			} else if f.owner != owner {
+				return fmt.Errorf("promtext: %s and %s both write %s", f.owner, owner, name)
+			}
+			f.lines = append(f.lines, line(name, labels, value))
+			return nil
+		}
+
+		base := Labels(p.Tags)
+		if p.Type != stats.AggregateHistogram {
+			if err := add(name, base, p.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, l := range base {
+			if l.Name == "quantile" {
+				return fmt.Errorf("promtext: histogram %s has a quantile tag", p.Key)
+			}
+		}
+		for _, q := range p.Quantiles {
+			quantile := Label{"quantile", strconv.FormatFloat(q.P, 'g', -1, 64)}
+			if err := add(name, withLabel(base, quantile), q.Value); err != nil {
+				return err
+			}
+		}
+		if err := add(name+"_sum", base, p.Sum); err != nil {
+			return err
+		}
+		if err := add(name+"_count", base, float64(p.Count)); err != nil {
+			return err
+		}
+	}
+
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.typ)
+		for _, l := range f.lines {
+			bw.WriteString(l)
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// withLabel returns the sorted labels with l added.
+func withLabel(labels []Label, l Label) []Label {
+	result := append([]Label{l}, labels...)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func line(name string, labels []Label, value float64) string {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.Name)
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(l.Value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(FormatValue(value))
+	return b.String()
+}
+
+// FormatValue formats a sample value, using the +Inf, -Inf and NaN
+// spellings of the text format.
+func FormatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
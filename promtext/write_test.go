@@ -0,0 +1,91 @@
+package promtext_test
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+)
+
+func histogramValues() []float64 {
+	var values []float64
+	for i := 0; i <= 20; i++ {
+		values = append(values, float64(i))
+	}
+	return values
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{
+		Key:    "rpc.requests",
+		Values: []float64{1, 2},
+		Type:   stats.AggregateSum,
+		Tags:   []string{"method:get", "path:/a\"b\\c\nd"},
+	})
+	a.Add(&stats.SimpleCounter{
+		Key:    "rpc.requests",
+		Values: []float64{5},
+		Type:   stats.AggregateSum,
+		Tags:   []string{"method:post"},
+	})
+	a.Add(&stats.SimpleCounter{Key: "load", Values: []float64{math.Inf(1)}, Type: stats.AggregateAvg})
+	a.Add(&stats.SimpleCounter{Key: "latency", Values: histogramValues(), Type: stats.AggregateHistogram})
+	a.Add(&stats.SimpleCounter{Key: "small", Values: []float64{1}, Type: stats.AggregateHistogram})
+
+	var b bytes.Buffer
+	ensure.Nil(t, promtext.Write(&b, a, map[string]float64{"p50": 0.5, "p99": 0.99}))
+	ensure.DeepEqual(t, b.String(), `# TYPE latency untyped
+latency{quantile="0.5"} 10
+latency{quantile="0.99"} 20
+# TYPE latency_count untyped
+latency_count 21
+# TYPE latency_sum untyped
+latency_sum 210
+# TYPE load gauge
+load +Inf
+# TYPE rpc_requests untyped
+rpc_requests{method="get",path="/a\"b\\c\nd"} 3
+rpc_requests{method="post"} 5
+# TYPE small_count untyped
+small_count 1
+# TYPE small_sum untyped
+small_sum 1
+`)
+}
+
+func TestWriteConflict(t *testing.T) {
+	t.Parallel()
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "a.b", Values: []float64{1}, Type: stats.AggregateSum})
+	a.Add(&stats.SimpleCounter{Key: "a-b", Values: []float64{1}, Type: stats.AggregateAvg})
+	ensure.Err(t, promtext.Write(&bytes.Buffer{}, a, nil), regexp.MustCompile("conflicting types"))
+}
+
+func TestWriteHistogramCollisions(t *testing.T) {
+	t.Parallel()
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "x", Values: []float64{1}, Type: stats.AggregateHistogram})
+	a.Add(&stats.SimpleCounter{Key: "x_sum", Values: []float64{1}, Type: stats.AggregateSum})
+	ensure.Err(t, promtext.Write(&bytes.Buffer{}, a, nil), regexp.MustCompile("x and x_sum both write x_sum"))
+
+	a = stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "x", Values: []float64{1}, Type: stats.AggregateHistogram, Tags: []string{"quantile:high"}})
+	ensure.Err(t, promtext.Write(&bytes.Buffer{}, a, nil), regexp.MustCompile("histogram x has a quantile tag"))
+}
+
+func TestNames(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, promtext.MetricName("rpc.latency-ms:x"), "rpc_latency_ms:x")
+	ensure.DeepEqual(t, promtext.MetricName("9lives"), "_lives")
+	ensure.DeepEqual(t, promtext.MetricName(""), "_")
+	ensure.DeepEqual(t, promtext.LabelName("a:b"), "a_b")
+	ensure.DeepEqual(t, promtext.LabelName("__name__"), "_tag__name__")
+	ensure.DeepEqual(t, promtext.Labels([]string{"b:2", "a:1", "a:3", "empty:"}),
+		[]promtext.Label{{"a", "1"}, {"b", "2"}})
+}
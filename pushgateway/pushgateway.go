@@ -0,0 +1,163 @@
+// Package pushgateway pushes aggregated counters to a Prometheus Pushgateway,
+// for batch jobs which exit before they could be scraped. Counters are sent
+// in the text format of the promtext package under a grouping key made of
+// the job name and grouping labels:
+//
+//	p := &pushgateway.Pusher{
+//		URL:      "http://pushgateway:9091",
+//		Job:      "nightly-import",
+//		Grouping: map[string]string{"instance": host},
+//		Snapshot: collect,
+//	}
+//	go p.Run(time.Minute, stop)
+//
+// Run pushes every interval and once more when stop is closed, so the last
+// values of a job are pushed during a graceful shutdown.
+package pushgateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+)
+
+// ContentType is the content type of pushed bodies.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Pusher pushes to a Pushgateway.
+type Pusher struct {
+	// URL is the base URL of the Pushgateway.
+	URL string
+
+	// Job is the job label of the grouping key.
+	Job string
+
+	// Grouping are additional labels of the grouping key.
+	Grouping map[string]string
+
+	// Replace makes Push replace all metrics in the group using PUT. By
+	// default POST is used, which only replaces metrics with the same
+	// names.
+	Replace bool
+
+	// Client is used for requests, defaulting to http.DefaultClient.
+	Client *http.Client
+
+	// Quantiles are pushed for histograms, defaulting to
+	// stats.HistogramPercentiles.
+	Quantiles map[string]float64
+
+	// Snapshot returns the counters pushed by Run.
+	Snapshot func() stats.Aggregates
+
+	// OnError is called with errors of the periodic pushes by Run.
+	OnError func(error)
+}
+
+// GroupURL returns the URL of the grouping key. Values which are empty or
+// contain a '/' use the base64 form.
+func (p *Pusher) GroupURL() string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(p.URL, "/"))
+	b.WriteString("/metrics")
+	writeSegment(&b, "job", p.Job)
+	names := make([]string, 0, len(p.Grouping))
+	for name := range p.Grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeSegment(&b, name, p.Grouping[name])
+	}
+	return b.String()
+}
+
+func writeSegment(b *strings.Builder, name, value string) {
+	b.WriteByte('/')
+	if value == "" || strings.Contains(value, "/") {
+		b.WriteString(name)
+		b.WriteString("@base64/")
+		if value == "" {
+			b.WriteString("=")
+		} else {
+			b.WriteString(base64.RawURLEncoding.EncodeToString([]byte(value)))
+		}
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('/')
+	b.WriteString(url.PathEscape(value))
+}
+
+// Push pushes the counters.
+func (p *Pusher) Push(counters stats.Aggregates) error {
+	if p.Job == "" {
+		return fmt.Errorf("pushgateway: missing job")
+	}
+	var body bytes.Buffer
+	if err := promtext.Write(&body, counters, p.Quantiles); err != nil {
+		return err
+	}
+	method := "POST"
+	if p.Replace {
+		method = "PUT"
+	}
+	return p.do(method, &body)
+}
+
+// Delete deletes all metrics of the group, which is useful once a job has
+// finished and its results were collected.
+func (p *Pusher) Delete() error {
+	return p.do("DELETE", nil)
+}
+
+func (p *Pusher) do(method string, body io.Reader) error {
+	req, err := http.NewRequest(method, p.GroupURL(), body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", ContentType)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway: %s %s returned %s: %s",
+			method, req.URL, res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Run pushes Snapshot every interval until stop is closed, then pushes a
+// final time and returns the result of that push.
+func (p *Pusher) Run(interval time.Duration, stop <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Push(p.Snapshot()); err != nil && p.OnError != nil {
+				p.OnError(err)
+			}
+		case <-stop:
+			return p.Push(p.Snapshot())
+		}
+	}
+}
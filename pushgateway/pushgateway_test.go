@@ -0,0 +1,134 @@
+package pushgateway_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+	"github.com/facebookgo/stats/pushgateway"
+)
+
+type request struct {
+	Method, Path, ContentType, Body string
+}
+
+type gateway struct {
+	mu       sync.Mutex
+	requests []request
+	status   int
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests = append(g.requests, request{
+		Method:      r.Method,
+		Path:        r.URL.EscapedPath(),
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        string(body),
+	})
+	if g.status != 0 {
+		http.Error(w, "bad metrics", g.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *gateway) received() []request {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]request(nil), g.requests...)
+}
+
+func sample(v float64) stats.Aggregates {
+	a := stats.Aggregates{}
+	a.Add(&stats.SimpleCounter{Key: "rows.imported", Values: []float64{v}, Type: stats.AggregateSum})
+	return a
+}
+
+func TestGroupURL(t *testing.T) {
+	t.Parallel()
+	p := &pushgateway.Pusher{
+		URL:      "http://gw:9091/",
+		Job:      "import",
+		Grouping: map[string]string{"path": "/var/tmp", "instance": "host 1", "empty": ""},
+	}
+	ensure.DeepEqual(t, p.GroupURL(),
+		"http://gw:9091/metrics/job/import/empty@base64/=/instance/host%201/path@base64/L3Zhci90bXA")
+}
+
+func TestPush(t *testing.T) {
+	t.Parallel()
+	var g gateway
+	server := httptest.NewServer(&g)
+	defer server.Close()
+
+	p := &pushgateway.Pusher{URL: server.URL, Job: "import", Grouping: map[string]string{"instance": "a"}}
+	ensure.Nil(t, p.Push(sample(3)))
+	p.Replace = true
+	ensure.Nil(t, p.Push(sample(4)))
+	ensure.Nil(t, p.Delete())
+
+	body := "# TYPE rows_imported untyped\nrows_imported "
+	ensure.DeepEqual(t, g.received(), []request{
+		{"POST", "/metrics/job/import/instance/a", pushgateway.ContentType, body + "3\n"},
+		{"PUT", "/metrics/job/import/instance/a", pushgateway.ContentType, body + "4\n"},
+		{"DELETE", "/metrics/job/import/instance/a", "", ""},
+	})
+}
+
+func TestPushErrors(t *testing.T) {
+	t.Parallel()
+	g := gateway{status: http.StatusBadRequest}
+	server := httptest.NewServer(&g)
+	defer server.Close()
+
+	p := &pushgateway.Pusher{URL: server.URL, Job: "import"}
+	ensure.Err(t, p.Push(sample(1)), regexp.MustCompile("400 Bad Request: bad metrics"))
+	p.Job = ""
+	ensure.Err(t, p.Push(sample(1)), regexp.MustCompile("missing job"))
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	var g gateway
+	server := httptest.NewServer(&g)
+	defer server.Close()
+
+	var mu sync.Mutex
+	v := 0.0
+	p := &pushgateway.Pusher{
+		URL: server.URL,
+		Job: "import",
+		Snapshot: func() stats.Aggregates {
+			mu.Lock()
+			defer mu.Unlock()
+			v++
+			return sample(v)
+		},
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- p.Run(time.Millisecond, stop) }()
+	for len(g.received()) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	ensure.Nil(t, <-done)
+
+	// The final push on shutdown has the last snapshot.
+	requests := g.received()
+	mu.Lock()
+	last := v
+	mu.Unlock()
+	ensure.DeepEqual(t, requests[len(requests)-1].Body,
+		"# TYPE rows_imported untyped\nrows_imported "+promtext.FormatValue(last)+"\n")
+}
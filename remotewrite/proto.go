@@ -0,0 +1,75 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/facebookgo/stats/promtext"
+)
+
+// Label is a Prometheus label pair.
+type Label = promtext.Label
+
+// Sample is a value at a millisecond timestamp.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Series is one time series of a WriteRequest.
+type Series struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Protobuf wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireBytes  = 2
+)
+
+// marshalWriteRequest encodes the prometheus.WriteRequest message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func marshalWriteRequest(series []Series) []byte {
+	var b []byte
+	for _, s := range series {
+		b = appendBytesField(b, 1, marshalSeries(s))
+	}
+	return b
+}
+
+func marshalSeries(s Series) []byte {
+	var b []byte
+	for _, l := range s.Labels {
+		var lb []byte
+		lb = appendBytesField(lb, 1, []byte(l.Name))
+		lb = appendBytesField(lb, 2, []byte(l.Value))
+		b = appendBytesField(b, 1, lb)
+	}
+	for _, sample := range s.Samples {
+		var sb []byte
+		sb = appendTag(sb, 1, wireI64)
+		sb = binary.LittleEndian.AppendUint64(sb, math.Float64bits(sample.Value))
+		if sample.Timestamp != 0 {
+			sb = appendTag(sb, 2, wireVarint)
+			sb = binary.AppendUvarint(sb, uint64(sample.Timestamp))
+		}
+		b = appendBytesField(b, 2, sb)
+	}
+	return b
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
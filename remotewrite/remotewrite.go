@@ -0,0 +1,293 @@
+// Package remotewrite sends aggregated counters to a Prometheus remote_write
+// v1 endpoint, such as Prometheus itself, Cortex, Mimir or VictoriaMetrics.
+// Requests are snappy compressed protobuf WriteRequests produced by a small
+// hand-rolled encoder, so the package has no dependencies outside the
+// standard library.
+//
+// Counter keys become metric names and tags become labels, named like
+// promtext names them. A histogram is sent as a summary: a series per
+// quantile with a "quantile" label, plus key_sum and key_count.
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/promtext"
+)
+
+// Labels returns the sorted labels of a metric with tags, which are
+// converted by promtext.Labels.
+func Labels(name string, tags []string) []Label {
+	labels := append([]Label{{Name: "__name__", Value: name}}, promtext.Labels(tags)...)
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
+
+// ToSeries converts counters to series with a single sample at t. Histograms
+// without enough samples for percentiles only get key_sum and key_count.
+func ToSeries(t time.Time, counters stats.Aggregates, quantiles map[string]float64) []Series {
+	if quantiles == nil {
+		quantiles = stats.HistogramPercentiles
+	}
+	ts := t.UnixNano() / int64(time.Millisecond)
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var series []Series
+	add := func(name string, tags []string, value float64) {
+		series = append(series, Series{
+			Labels:  Labels(name, tags),
+			Samples: []Sample{{Value: value, Timestamp: ts}},
+		})
+	}
+	for _, key := range keys {
+		p := stats.NewPoint(counters[key], quantiles)
+		name := promtext.MetricName(p.Key)
+		switch p.Type {
+		case stats.AggregateHistogram:
+			for _, q := range p.Quantiles {
+				add(name, append(p.Tags[:len(p.Tags):len(p.Tags)], "quantile:"+formatQuantile(q.P)), q.Value)
+			}
+			add(name+"_sum", p.Tags, p.Sum)
+			add(name+"_count", p.Tags, float64(p.Count))
+		default:
+			add(name, p.Tags, p.Value)
+		}
+	}
+	return series
+}
+
+func formatQuantile(p float64) string {
+	return strconv.FormatFloat(p, 'g', -1, 64)
+}
+
+// Writer sends flushes to a remote_write endpoint. Series are spread over
+// shards by their labels, so each series is always sent in order by the same
+// shard, and each shard sends its queue in batches. It is safe for
+// concurrent use.
+type Writer struct {
+	// URL is the remote_write endpoint.
+	URL string
+
+	// Client is used for requests, defaulting to http.DefaultClient.
+	Client *http.Client
+
+	// Header is added to every request, for example for authentication.
+	Header http.Header
+
+	// Shards is the number of concurrent senders, defaulting to 4.
+	Shards int
+
+	// QueueSize is the number of series buffered per shard, defaulting to
+	// 10000. Series are dropped when a queue is full.
+	QueueSize int
+
+	// MaxBatch is the maximum number of series per request, defaulting to
+	// 500.
+	MaxBatch int
+
+	// MaxRetries is the number of retries of a request failing with a 5xx
+	// or 429 status or a network error, defaulting to 3.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// retries, defaulting to 30ms and 5s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Quantiles are sent for histograms, defaulting to
+	// stats.HistogramPercentiles.
+	Quantiles map[string]float64
+
+	mu     sync.RWMutex
+	queues []chan Series
+	closed bool
+	wg     sync.WaitGroup
+	errMu  sync.Mutex
+	err    error
+}
+
+func (w *Writer) start() {
+	if w.Shards <= 0 {
+		w.Shards = 4
+	}
+	if w.QueueSize <= 0 {
+		w.QueueSize = 10000
+	}
+	if w.MaxBatch <= 0 {
+		w.MaxBatch = 500
+	}
+	if w.MaxRetries <= 0 {
+		w.MaxRetries = 3
+	}
+	if w.MinBackoff <= 0 {
+		w.MinBackoff = 30 * time.Millisecond
+	}
+	if w.MaxBackoff <= 0 {
+		w.MaxBackoff = 5 * time.Second
+	}
+	w.queues = make([]chan Series, w.Shards)
+	for i := range w.queues {
+		w.queues[i] = make(chan Series, w.QueueSize)
+		w.wg.Add(1)
+		go w.run(w.queues[i])
+	}
+}
+
+// Write queues the counters to be sent. It returns an error if the Writer is
+// closed or if series were dropped because a queue was full.
+func (w *Writer) Write(t time.Time, counters stats.Aggregates) error {
+	series := ToSeries(t, counters, w.Quantiles)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return fmt.Errorf("remotewrite: writer closed")
+	}
+	if w.queues == nil {
+		w.start()
+	}
+	w.mu.Unlock()
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return fmt.Errorf("remotewrite: writer closed")
+	}
+	dropped := 0
+	for _, s := range series {
+		select {
+		case w.queues[shard(s.Labels, len(w.queues))] <- s:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("remotewrite: queue full, dropped %d series", dropped)
+	}
+	return nil
+}
+
+// Close sends everything queued and stops the shards. It returns the first
+// error sending a request.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, q := range w.queues {
+			close(q)
+		}
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err
+}
+
+func shard(labels []Label, n int) int {
+	h := fnv.New32a()
+	for _, l := range labels {
+		io.WriteString(h, l.Name)
+		h.Write([]byte{0})
+		io.WriteString(h, l.Value)
+		h.Write([]byte{0})
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
+func (w *Writer) run(queue chan Series) {
+	defer w.wg.Done()
+	for s := range queue {
+		batch := []Series{s}
+	fill:
+		for len(batch) < w.MaxBatch {
+			select {
+			case s, ok := <-queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, s)
+			default:
+				break fill
+			}
+		}
+		if err := w.send(batch); err != nil {
+			w.errMu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.errMu.Unlock()
+		}
+	}
+}
+
+func (w *Writer) send(batch []Series) error {
+	body := snappyEncode(marshalWriteRequest(batch))
+	backoff := w.MinBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := w.post(body)
+		if err == nil || retryAfter < 0 || attempt >= w.MaxRetries {
+			return err
+		}
+		if retryAfter > 0 {
+			backoff = retryAfter
+		}
+		if backoff > w.MaxBackoff {
+			backoff = w.MaxBackoff
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one request. A negative retryAfter means the request must not
+// be retried, and a positive one is the delay requested by the server.
+func (w *Writer) post(body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode/100 == 2 {
+		return 0, nil
+	}
+	err = fmt.Errorf("remotewrite: server returned %s: %s", res.Status, bytes.TrimSpace(msg))
+	if res.StatusCode/100 != 5 && res.StatusCode != http.StatusTooManyRequests {
+		return -1, err
+	}
+	if secs, perr := strconv.Atoi(res.Header.Get("Retry-After")); perr == nil && secs > 0 {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	return retryAfter, err
+}
@@ -0,0 +1,312 @@
+package stats
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// DefaultReservoirSize is the number of samples kept by a ReservoirCounter
+// when Size isn't set. It gives 99.9% confidence of a 5% margin of error
+// for a normal distribution.
+const DefaultReservoirSize = 1028
+
+// ReservoirCounter is a histogram Counter which keeps a bounded sample of
+// its values instead of all of them. The count, sum, minimum and maximum are
+// exact while percentiles are estimated from the sample.
+//
+// With Decay zero the sample is uniform over all values, using Algorithm R.
+// With Decay set it is a forward-decaying priority sample, where a value
+// added at time t has weight exp(Decay * t), biasing the sample toward
+// recent values. A Decay of 0.015 per second makes a value five minutes old
+// weigh about 1% of a new one.
+//
+// Reservoirs merge through Aggregates.Add: uniform samples are merged by
+// drawing every kept value from either sample in proportion to the number of
+// values each stands for, and decaying samples by keeping the highest
+// priorities. Sinks export the exact count and sum through Summary. This is
+// not goroutine safe.
+type ReservoirCounter struct {
+	Key string
+
+	// Size is the number of values kept, defaulting to
+	// DefaultReservoirSize.
+	Size int
+
+	// Decay is the forward decay rate per second, or zero for a uniform
+	// sample.
+	Decay float64
+
+	// Rand is the source of randomness, defaulting to the math/rand
+	// functions.
+	Rand *rand.Rand
+
+	// Policy is applied as values are added.
+	Policy ValuePolicy
+
+	count   int
+	invalid int
+	sum     float64
+	min     float64
+	max     float64
+	items   reservoirItems
+}
+
+type reservoirItem struct {
+	value float64
+
+	// priority is the log of the A-Res key, which is only kept for decaying
+	// samples.
+	priority float64
+}
+
+// reservoirItems is a min heap on priority.
+type reservoirItems []reservoirItem
+
+func (r reservoirItems) Len() int            { return len(r) }
+func (r reservoirItems) Less(i, j int) bool  { return r[i].priority < r[j].priority }
+func (r reservoirItems) Swap(i, j int)       { r[i], r[j] = r[j], r[i] }
+func (r *reservoirItems) Push(x interface{}) { *r = append(*r, x.(reservoirItem)) }
+func (r *reservoirItems) Pop() interface{} {
+	old := *r
+	item := old[len(old)-1]
+	*r = old[:len(old)-1]
+	return item
+}
+
+// NewReservoirCounter creates a uniform ReservoirCounter keeping size
+// values.
+func NewReservoirCounter(key string, size int) *ReservoirCounter {
+	return &ReservoirCounter{Key: key, Size: size}
+}
+
+func (r *ReservoirCounter) size() int {
+	if r.Size <= 0 {
+		return DefaultReservoirSize
+	}
+	return r.Size
+}
+
+func (r *ReservoirCounter) float64() float64 {
+	if r.Rand != nil {
+		return r.Rand.Float64()
+	}
+	return rand.Float64()
+}
+
+func (r *ReservoirCounter) intn(n int) int {
+	if r.Rand != nil {
+		return r.Rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// priority returns the log of the A-Res key u^(1/w) for a value of weight
+// exp(logWeight), which is logWeight - log(-log(u)). Staying in log space
+// avoids overflowing the weights of forward decay.
+func (r *ReservoirCounter) priority(logWeight float64) float64 {
+	u := r.float64()
+	for u == 0 {
+		u = r.float64()
+	}
+	return logWeight - math.Log(-math.Log(u))
+}
+
+// FullKey is part of the Counter interface
+func (r *ReservoirCounter) FullKey() string {
+	return r.Key
+}
+
+// GetType is part of the Counter interface, and is always
+// AggregateHistogram
+func (r *ReservoirCounter) GetType() Type {
+	return AggregateHistogram
+}
+
+// GetValues is part of the Counter interface. It returns the sample, which
+// can be smaller than the number of values added
+func (r *ReservoirCounter) GetValues() []float64 {
+	values := make([]float64, len(r.items))
+	for i, item := range r.items {
+		values[i] = item.value
+	}
+	return values
+}
+
+// AddValues is part of the Counter interface. The Policy is applied as
+// values are added
+func (r *ReservoirCounter) AddValues(vs ...float64) {
+	r.addValuesAt(time.Now(), vs)
+}
+
+// AddValuesAt adds values as of t, which only matters for decaying samples
+func (r *ReservoirCounter) AddValuesAt(t time.Time, vs ...float64) {
+	r.addValuesAt(t, vs)
+}
+
+func (r *ReservoirCounter) addValuesAt(t time.Time, vs []float64) {
+	vs, invalid := ValidValues(vs, r.Policy)
+	r.invalid += invalid
+	logWeight := r.Decay * float64(t.UnixNano()) / float64(time.Second)
+	for _, v := range vs {
+		r.observe(v)
+		if r.Decay != 0 {
+			r.offer(reservoirItem{value: v, priority: r.priority(logWeight)})
+			continue
+		}
+		// Algorithm R: the n-th value replaces a random item with
+		// probability size / n.
+		if len(r.items) < r.size() {
+			r.items = append(r.items, reservoirItem{value: v})
+		} else if i := r.intn(r.count); i < len(r.items) {
+			r.items[i] = reservoirItem{value: v}
+		}
+	}
+}
+
+func (r *ReservoirCounter) observe(v float64) {
+	if r.count == 0 || v < r.min {
+		r.min = v
+	}
+	if r.count == 0 || v > r.max {
+		r.max = v
+	}
+	r.count++
+	r.addSum(v)
+}
+
+// addSum adds to the sum, which is clamped under ValueClamp rather than
+// overflowing.
+func (r *ReservoirCounter) addSum(v float64) {
+	r.sum += v
+	if r.Policy.resolve() == ValueClamp {
+		r.sum = math.Max(-math.MaxFloat64, math.Min(math.MaxFloat64, r.sum))
+	}
+}
+
+// mean returns the average, kept within the minimum and maximum.
+func (r *ReservoirCounter) mean() float64 {
+	if r.count == 0 {
+		return 0
+	}
+	return math.Max(r.min, math.Min(r.max, r.sum/float64(r.count)))
+}
+
+// offer keeps the item if it is among the size highest priorities.
+func (r *ReservoirCounter) offer(item reservoirItem) {
+	if len(r.items) < r.size() {
+		heap.Push(&r.items, item)
+	} else if item.priority > r.items[0].priority {
+		r.items[0] = item
+		heap.Fix(&r.items, 0)
+	}
+}
+
+// GetCount returns the exact number of values added
+func (r *ReservoirCounter) GetCount() int {
+	return r.count
+}
+
+// GetSum returns the exact sum of the values added
+func (r *ReservoirCounter) GetSum() float64 {
+	return r.sum
+}
+
+// Merge is part of the Merger interface. Other counters are merged by
+// adding their values, along with the Invalid count of a SimpleCounter.
+func (r *ReservoirCounter) Merge(other Counter) error {
+	o, ok := other.(*ReservoirCounter)
+	if !ok {
+		if s, ok := other.(*SimpleCounter); ok {
+			r.invalid += s.Invalid
+		}
+		r.AddValues(other.GetValues()...)
+		return nil
+	}
+	if o.Decay != r.Decay {
+		return fmt.Errorf("stats: mismatched reservoir decay for: %s", r.Key)
+	}
+	if o.count == 0 {
+		return nil
+	}
+	if r.count == 0 || o.min < r.min {
+		r.min = o.min
+	}
+	if r.count == 0 || o.max > r.max {
+		r.max = o.max
+	}
+	r.invalid += o.invalid
+	r.addSum(o.sum)
+
+	if r.Decay != 0 {
+		r.count += o.count
+		for _, item := range o.items {
+			r.offer(item)
+		}
+		return nil
+	}
+
+	// Sample without replacement from the union of the values both sides
+	// stand for: each kept value comes from a side with probability
+	// proportional to the values of that side not yet drawn.
+	sides := [2]reservoirItems{
+		append(reservoirItems(nil), r.items...),
+		append(reservoirItems(nil), o.items...),
+	}
+	remaining := [2]int{r.count, o.count}
+	n := len(sides[0]) + len(sides[1])
+	if n > r.size() {
+		n = r.size()
+	}
+	items := make(reservoirItems, 0, n)
+	for len(items) < n {
+		side := 0
+		if r.intn(remaining[0]+remaining[1]) >= remaining[0] {
+			side = 1
+		}
+		// A smaller reservoir can run out of kept values while still
+		// standing for more, in which case the other side supplies the rest.
+		if len(sides[side]) == 0 {
+			side = 1 - side
+		}
+		remaining[side]--
+		// Take a random item not taken yet, keeping the rest in front.
+		s := sides[side]
+		i := r.intn(len(s))
+		items = append(items, s[i])
+		s[i] = s[len(s)-1]
+		sides[side] = s[:len(s)-1]
+	}
+	r.count += o.count
+	r.items = items
+	return nil
+}
+
+// Aggregate returns the exact average under Key and, given enough values,
+// the percentiles in HistogramPercentiles estimated from the sample, like
+// SimpleCounter.Aggregate
+func (r *ReservoirCounter) Aggregate() map[string]float64 {
+	result := map[string]float64{r.Key: r.mean()}
+	if r.count > MinSamplesForPercentiles {
+		for k, v := range percentilesOf(r.GetValues(), HistogramPercentiles, r.Policy) {
+			result[fmt.Sprintf("%s.%s", r.Key, k)] = v
+		}
+	}
+	if r.Policy.resolve() == ValueCount {
+		result[r.Key+".invalid_values"] = float64(r.invalid)
+	}
+	return result
+}
+
+// Summary returns a Summary with the exact count, sum, minimum, maximum and
+// mean, and the percentiles estimated from the sample.
+func (r *ReservoirCounter) Summary(percentiles map[string]float64) *Summary {
+	s := Summarize(r.GetValues(), percentiles)
+	s.Count = r.count
+	s.Sum = r.sum
+	s.Min, s.Max = r.min, r.max
+	s.Mean = r.mean()
+	return s
+}
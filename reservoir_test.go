@@ -0,0 +1,209 @@
+package stats_test
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+func newReservoir(size int, decay float64, seed int64) *stats.ReservoirCounter {
+	return &stats.ReservoirCounter{
+		Key:   "r",
+		Size:  size,
+		Decay: decay,
+		Rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+func mean(values []float64) float64 {
+	return stats.Sum(values) / float64(len(values))
+}
+
+func TestReservoirExact(t *testing.T) {
+	t.Parallel()
+	r := stats.NewReservoirCounter("r", 10)
+	ensure.DeepEqual(t, r.Aggregate(), map[string]float64{"r": 0})
+	for i := 1; i <= 1000; i++ {
+		r.AddValues(float64(i))
+	}
+	ensure.DeepEqual(t, len(r.GetValues()), 10)
+	ensure.DeepEqual(t, r.GetCount(), 1000)
+	ensure.DeepEqual(t, r.GetSum(), 500500.0)
+	ensure.DeepEqual(t, r.GetType(), stats.AggregateHistogram)
+	ensure.DeepEqual(t, r.FullKey(), "r")
+
+	s := r.Summary(stats.HistogramPercentiles)
+	ensure.Nil(t, s.Validate())
+	ensure.DeepEqual(t, s.Count, 1000)
+	ensure.DeepEqual(t, s.Min, 1.0)
+	ensure.DeepEqual(t, s.Max, 1000.0)
+	ensure.DeepEqual(t, s.Mean, 500.5)
+
+	result := r.Aggregate()
+	ensure.DeepEqual(t, result["r"], 500.5)
+	ensure.DeepEqual(t, len(result), 4)
+}
+
+func TestReservoirUniform(t *testing.T) {
+	t.Parallel()
+	// The sample of 0..9999 should be spread over the whole range rather
+	// than biased to the first or last values.
+	const runs = 200
+	var total float64
+	for seed := int64(0); seed < runs; seed++ {
+		r := newReservoir(100, 0, seed)
+		for i := 0; i < 10000; i++ {
+			r.AddValues(float64(i))
+		}
+		total += mean(r.GetValues())
+	}
+	ensure.True(t, math.Abs(total/runs-4999.5) < 100, total/runs)
+}
+
+func TestReservoirSmall(t *testing.T) {
+	t.Parallel()
+	r := newReservoir(0, 0, 1)
+	r.AddValues(3, 1, 2)
+	ensure.DeepEqual(t, r.GetValues(), []float64{3, 1, 2})
+}
+
+func TestReservoirDecay(t *testing.T) {
+	t.Parallel()
+	// An hour of zeros followed an hour later by a minute of ones: with
+	// decay the sample keeps every one, while a uniform sample is mostly
+	// zeros.
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	decayed := newReservoir(100, 0.015, 1)
+	uniform := newReservoir(100, 0, 1)
+	for i := 0; i < 3600; i++ {
+		decayed.AddValuesAt(start.Add(time.Duration(i)*time.Second), 0)
+		uniform.AddValues(0)
+	}
+	for i := 0; i < 60; i++ {
+		decayed.AddValuesAt(start.Add(2*time.Hour+time.Duration(i)*time.Second), 1)
+		uniform.AddValues(1)
+	}
+	ensure.DeepEqual(t, stats.Sum(decayed.GetValues()), 60.0)
+	ensure.True(t, mean(uniform.GetValues()) < 0.1, mean(uniform.GetValues()))
+	ensure.DeepEqual(t, decayed.GetCount(), 3660)
+	ensure.DeepEqual(t, decayed.GetSum(), 60.0)
+}
+
+func TestReservoirMergeWeighted(t *testing.T) {
+	t.Parallel()
+	// 1000 zeros and 9000 ones sampled into equal sized reservoirs should
+	// merge into a sample of about 90% ones.
+	var total float64
+	for seed := int64(0); seed < 50; seed++ {
+		a := newReservoir(100, 0, seed)
+		b := newReservoir(100, 0, seed+1000)
+		for i := 0; i < 1000; i++ {
+			a.AddValues(0)
+		}
+		for i := 0; i < 9000; i++ {
+			b.AddValues(1)
+		}
+		agg := stats.Aggregates{}
+		ensure.Nil(t, agg.Add(a))
+		ensure.Nil(t, agg.Add(b))
+		merged := agg["r"].(*stats.ReservoirCounter)
+		ensure.DeepEqual(t, len(merged.GetValues()), 100)
+		ensure.DeepEqual(t, merged.GetCount(), 10000)
+		ensure.DeepEqual(t, merged.GetSum(), 9000.0)
+		total += mean(merged.GetValues())
+	}
+	ensure.True(t, math.Abs(total/50-0.9) < 0.02, total/50)
+}
+
+// Merging reservoirs of different sizes used to draw from a side with no
+// kept values left.
+func TestReservoirMergeSizes(t *testing.T) {
+	t.Parallel()
+	for seed := int64(0); seed < 20; seed++ {
+		large := newReservoir(100, 0, seed)
+		for i := 0; i < 50; i++ {
+			large.AddValues(0)
+		}
+		small := newReservoir(10, 0, seed+1000)
+		for i := 0; i < 1000; i++ {
+			small.AddValues(1)
+		}
+		agg := stats.Aggregates{}
+		ensure.Nil(t, agg.Add(large))
+		ensure.Nil(t, agg.Add(small))
+		ensure.DeepEqual(t, len(large.GetValues()), 60)
+		ensure.DeepEqual(t, large.GetCount(), 1050)
+		ensure.DeepEqual(t, large.GetSum(), 1000.0)
+	}
+}
+
+func TestReservoirMergeDecay(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := newReservoir(10, 0.015, 1)
+	recent := newReservoir(10, 0.015, 2)
+	for i := 0; i < 100; i++ {
+		old.AddValuesAt(start.Add(time.Duration(i)*time.Second), 0)
+		recent.AddValuesAt(start.Add(time.Hour+time.Duration(i)*time.Second), 1)
+	}
+	agg := stats.Aggregates{}
+	ensure.Nil(t, agg.Add(old))
+	ensure.Nil(t, agg.Add(recent))
+	ensure.DeepEqual(t, agg["r"].GetValues(), []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
+	ensure.DeepEqual(t, old.GetCount(), 200)
+
+	ensure.Err(t, agg.Add(newReservoir(10, 0, 1)), regexp.MustCompile("mismatched reservoir decay for: r"))
+}
+
+func TestReservoirMergeSimpleCounter(t *testing.T) {
+	t.Parallel()
+	r := newReservoir(10, 0, 1)
+	r.AddValues(1)
+	simple := &stats.SimpleCounter{Key: "r", Values: []float64{2, 3}, Type: stats.AggregateHistogram}
+
+	// A reservoir already in the Aggregates takes the values.
+	agg := stats.Aggregates{}
+	ensure.Nil(t, agg.Add(r))
+	ensure.Nil(t, agg.Add(&stats.SimpleCounter{Key: "r", Values: []float64{2, 3}, Type: stats.AggregateHistogram}))
+	ensure.DeepEqual(t, agg["r"], stats.Counter(r))
+	ensure.DeepEqual(t, r.GetCount(), 3)
+
+	// A reservoir added after a SimpleCounter replaces it.
+	r = newReservoir(10, 0, 1)
+	r.AddValues(1)
+	agg = stats.Aggregates{}
+	ensure.Nil(t, agg.Add(simple))
+	ensure.Nil(t, agg.Add(r))
+	ensure.DeepEqual(t, agg["r"], stats.Counter(r))
+	ensure.DeepEqual(t, r.GetCount(), 3)
+	ensure.DeepEqual(t, r.GetSum(), 6.0)
+
+	// Types still have to match.
+	ensure.Err(t, agg.Add(&stats.SimpleCounter{Key: "r", Type: stats.AggregateSum}),
+		regexp.MustCompile("mismatched aggregation type"))
+}
+
+// Changes the global policy, so it can't run in parallel.
+func TestReservoirInvalidValues(t *testing.T) {
+	defer func(p stats.ValuePolicy) { stats.InvalidValuePolicy = p }(stats.InvalidValuePolicy)
+	stats.InvalidValuePolicy = stats.ValueCount
+	r := newReservoir(10, 0, 1)
+	r.AddValues(1, math.NaN(), 3, math.Inf(1))
+	ensure.DeepEqual(t, r.Aggregate(), map[string]float64{"r": 2, "r.invalid_values": 2})
+	ensure.DeepEqual(t, r.GetCount(), 2)
+}
+
+func TestReservoirPolicy(t *testing.T) {
+	t.Parallel()
+	r := newReservoir(10, 0, 1)
+	r.Policy = stats.ValueClamp
+	r.AddValues(math.Inf(1), math.Inf(1), math.NaN())
+	ensure.DeepEqual(t, r.GetCount(), 2)
+	ensure.DeepEqual(t, r.GetSum(), math.MaxFloat64)
+	ensure.DeepEqual(t, r.Aggregate(), map[string]float64{"r": math.MaxFloat64})
+}
@@ -0,0 +1,108 @@
+package stats
+
+// ScopedClient namespaces keys under a dotted prefix and adds a fixed set of
+// tags to every call on the underlying client. Scopes nest, so a library
+// accepting a Client can namespace itself under the caller's scope:
+//
+//	users := stats.Scope(client, "db").Scope("users", "shard:1")
+//	users.BumpSum("reads", 1) // bumps "db.users.reads" with tag "shard:1"
+type ScopedClient struct {
+	client Client
+	prefix string
+	tags   []string
+}
+
+// Scope returns a client which prefixes keys with name and a dot, and appends
+// tags to the tags of every call. The Client may be nil, including a nil
+// *ScopedClient, in which case the returned client is a no-op. Scoping a
+// ScopedClient doesn't add another layer of wrapping, the prefix and tags are
+// combined instead.
+func Scope(c Client, name string, tags ...string) *ScopedClient {
+	if s, ok := c.(*ScopedClient); ok {
+		if s != nil {
+			return s.Scope(name, tags...)
+		}
+		c = nil
+	}
+	s := &ScopedClient{client: c}
+	if name != "" {
+		s.prefix = name + "."
+	}
+	if len(tags) > 0 {
+		s.tags = append([]string(nil), tags...)
+	}
+	return s
+}
+
+// Scope returns a nested scope.
+func (s *ScopedClient) Scope(name string, tags ...string) *ScopedClient {
+	n := &ScopedClient{
+		client: s.client,
+		prefix: s.prefix,
+		tags:   s.tags,
+	}
+	if name != "" {
+		n.prefix += name + "."
+	}
+	if len(tags) > 0 {
+		n.tags = append(append(make([]string, 0, len(s.tags)+len(tags)), s.tags...), tags...)
+	}
+	return n
+}
+
+// Prefix returns the key prefix of the scope.
+func (s *ScopedClient) Prefix() string {
+	return s.prefix
+}
+
+// Tags returns the tags added by the scope.
+func (s *ScopedClient) Tags() []string {
+	return append([]string(nil), s.tags...)
+}
+
+func (s *ScopedClient) withTags(tags []string) []string {
+	if len(tags) == 0 {
+		return s.tags
+	}
+	if len(s.tags) == 0 {
+		return tags
+	}
+	return append(append(make([]string, 0, len(s.tags)+len(tags)), s.tags...), tags...)
+}
+
+// BumpAvg is part of the Client interface.
+func (s *ScopedClient) BumpAvg(key string, val float64, tags ...string) {
+	BumpAvg(s.client, s.prefix+key, val, s.withTags(tags)...)
+}
+
+// BumpSum is part of the Client interface.
+func (s *ScopedClient) BumpSum(key string, val float64, tags ...string) {
+	BumpSum(s.client, s.prefix+key, val, s.withTags(tags)...)
+}
+
+// BumpHistogram is part of the Client interface.
+func (s *ScopedClient) BumpHistogram(key string, val float64, tags ...string) {
+	BumpHistogram(s.client, s.prefix+key, val, s.withTags(tags)...)
+}
+
+// BumpMin is part of the ExtendedClient interface.
+func (s *ScopedClient) BumpMin(key string, val float64, tags ...string) {
+	BumpMin(s.client, s.prefix+key, val, s.withTags(tags)...)
+}
+
+// BumpMax is part of the ExtendedClient interface.
+func (s *ScopedClient) BumpMax(key string, val float64, tags ...string) {
+	BumpMax(s.client, s.prefix+key, val, s.withTags(tags)...)
+}
+
+// BumpLast is part of the ExtendedClient interface.
+func (s *ScopedClient) BumpLast(key string, val float64, tags ...string) {
+	BumpLast(s.client, s.prefix+key, val, s.withTags(tags)...)
+}
+
+// BumpTime is part of the Client interface.
+func (s *ScopedClient) BumpTime(key string, tags ...string) interface {
+	End()
+} {
+	return BumpTime(s.client, s.prefix+key, s.withTags(tags)...)
+}
@@ -0,0 +1,84 @@
+package stats_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+func TestScope(t *testing.T) {
+	t.Parallel()
+	var bumps []string
+	record := func(kind string) func(string, float64, ...string) {
+		return func(key string, val float64, tags ...string) {
+			bumps = append(bumps, kind+":"+key+":"+strings.Join(tags, ","))
+		}
+	}
+	hc := &stats.HookClient{
+		BumpAvgHook:       record("avg"),
+		BumpSumHook:       record("sum"),
+		BumpHistogramHook: record("histogram"),
+		BumpLastHook:      record("last"),
+		BumpTimeHook: func(key string, tags ...string) interface {
+			End()
+		} {
+			bumps = append(bumps, "time:"+key+":"+strings.Join(tags, ","))
+			return stats.NoOpEnd
+		},
+	}
+
+	db := stats.Scope(hc, "db", "region:us")
+	users := db.Scope("users", "table:users")
+	users.BumpAvg("avg", 1)
+	users.BumpSum("sum", 1, "op:read")
+	db.BumpHistogram("histogram", 1)
+	stats.BumpLast(users, "last", 1)
+	stats.Scope(users, "").BumpTime("time").End()
+	stats.Scope(hc, "").BumpSum("plain", 1)
+
+	ensure.DeepEqual(t, bumps, []string{
+		"avg:db.users.avg:region:us,table:users",
+		"sum:db.users.sum:region:us,table:users,op:read",
+		"histogram:db.histogram:region:us",
+		"last:db.users.last:region:us,table:users",
+		"time:db.users.time:region:us,table:users",
+		"sum:plain:",
+	})
+	ensure.DeepEqual(t, users.Prefix(), "db.users.")
+	ensure.DeepEqual(t, users.Tags(), []string{"region:us", "table:users"})
+}
+
+// Ensure sibling scopes don't share the backing array of the parent tags.
+func TestScopeSiblingTags(t *testing.T) {
+	t.Parallel()
+	parent := stats.Scope(nil, "p", "a")
+	b := parent.Scope("b", "b")
+	c := parent.Scope("c", "c")
+	ensure.DeepEqual(t, b.Tags(), []string{"a", "b"})
+	ensure.DeepEqual(t, c.Tags(), []string{"a", "c"})
+}
+
+// Ensure a scope on a nil Client is a no-op.
+func TestScopeNilClient(t *testing.T) {
+	t.Parallel()
+	s := stats.Scope(nil, "db")
+	s.BumpAvg("a", 1)
+	s.BumpSum("a", 1)
+	s.BumpHistogram("a", 1)
+	s.BumpTime("a").End()
+}
+
+// Ensure a scope on a nil *ScopedClient is a no-op too.
+func TestScopeNilScopedClient(t *testing.T) {
+	t.Parallel()
+	var parent *stats.ScopedClient
+	s := stats.Scope(parent, "db", "a")
+	s.BumpAvg("a", 1)
+	s.BumpSum("a", 1)
+	s.BumpHistogram("a", 1)
+	s.BumpTime("a").End()
+	ensure.DeepEqual(t, s.Prefix(), "db.")
+	ensure.DeepEqual(t, s.Tags(), []string{"a"})
+}
@@ -25,33 +25,98 @@ type Client interface {
 	}
 }
 
+// AliasClient is implemented by clients which can record a single value for
+// many keys at once, for example by recording it once and expanding the keys
+// at flush time like Aggregator. PrefixClient uses it to avoid repeating the
+// work for every prefix.
+type AliasClient interface {
+	Client
+
+	// BumpAvgAliases bumps the average for all the given keys.
+	BumpAvgAliases(keys []string, val float64, tags ...string)
+
+	// BumpSumAliases bumps the sum for all the given keys.
+	BumpSumAliases(keys []string, val float64, tags ...string)
+
+	// BumpHistogramAliases bumps the histogram for all the given keys.
+	BumpHistogramAliases(keys []string, val float64, tags ...string)
+
+	// BumpTimeAliases starts a single timer which is reported to all the
+	// given keys when End() is called.
+	BumpTimeAliases(keys []string, tags ...string) interface {
+		End()
+	}
+}
+
+// ExtendedClient is implemented by clients which support the min, max and
+// last aggregations. Use the BumpMin, BumpMax and BumpLast helpers to record
+// them on any Client. Other clients get a histogram for the key instead,
+// which summarizes the values including their minimum and maximum.
+type ExtendedClient interface {
+	Client
+
+	// BumpMin bumps the minimum for the given key.
+	BumpMin(key string, val float64, tags ...string)
+
+	// BumpMax bumps the maximum for the given key.
+	BumpMax(key string, val float64, tags ...string)
+
+	// BumpLast records the last value for the given key.
+	BumpLast(key string, val float64, tags ...string)
+}
+
 // PrefixClient adds multiple keys for the same value, with each prefix
-// added to the key and calls the underlying client.
+// added to the key and calls the underlying client. If the client is an
+// AliasClient the value is recorded once for all the prefixed keys, and
+// BumpTime measures once for all of them.
 func PrefixClient(prefixes []string, client Client) Client {
-	return &prefixClient{
+	p := &prefixClient{
 		Prefixes: prefixes,
 		Client:   client,
 	}
+	p.alias, _ = client.(AliasClient)
+	return p
 }
 
 type prefixClient struct {
 	Prefixes []string
 	Client   Client
+	alias    AliasClient
+}
+
+func (p *prefixClient) keys(key string) []string {
+	keys := make([]string, len(p.Prefixes))
+	for i, prefix := range p.Prefixes {
+		keys[i] = prefix + key
+	}
+	return keys
 }
 
 func (p *prefixClient) BumpAvg(key string, val float64, tags ...string) {
+	if p.alias != nil {
+		p.alias.BumpAvgAliases(p.keys(key), val, tags...)
+		return
+	}
 	for _, prefix := range p.Prefixes {
 		p.Client.BumpAvg(prefix+key, val, tags...)
 	}
 }
 
 func (p *prefixClient) BumpSum(key string, val float64, tags ...string) {
+	if p.alias != nil {
+		p.alias.BumpSumAliases(p.keys(key), val, tags...)
+		return
+	}
 	for _, prefix := range p.Prefixes {
 		p.Client.BumpSum(prefix+key, val, tags...)
 	}
 }
 
 func (p *prefixClient) BumpHistogram(key string, val float64, tags ...string) {
+	if p.alias != nil {
+		p.alias.BumpHistogramAliases(p.keys(key), val, tags...)
+		return
+	}
 	for _, prefix := range p.Prefixes {
 		p.Client.BumpHistogram(prefix+key, val, tags...)
 	}
@@ -60,11 +125,32 @@ func (p *prefixClient) BumpHistogram(key string, val float64, tags ...string) {
 func (p *prefixClient) BumpTime(key string, tags ...string) interface {
 	End()
 } {
+	if p.alias != nil {
+		return p.alias.BumpTimeAliases(p.keys(key), tags...)
+	}
 	var m multiEnder
 	for _, prefix := range p.Prefixes {
 		m = append(m, p.Client.BumpTime(prefix+key, tags...))
 	}
-	return m
+	return OnceEnder(m)
+}
+
+func (p *prefixClient) BumpMin(key string, val float64, tags ...string) {
+	for _, prefix := range p.Prefixes {
+		BumpMin(p.Client, prefix+key, val, tags...)
+	}
+}
+
+func (p *prefixClient) BumpMax(key string, val float64, tags ...string) {
+	for _, prefix := range p.Prefixes {
+		BumpMax(p.Client, prefix+key, val, tags...)
+	}
+}
+
+func (p *prefixClient) BumpLast(key string, val float64, tags ...string) {
+	for _, prefix := range p.Prefixes {
+		BumpLast(p.Client, prefix+key, val, tags...)
+	}
 }
 
 // multiEnder combines many enders together.
@@ -85,6 +171,9 @@ type HookClient struct {
 	BumpAvgHook       func(key string, val float64, tags ...string)
 	BumpSumHook       func(key string, val float64, tags ...string)
 	BumpHistogramHook func(key string, val float64, tags ...string)
+	BumpMinHook       func(key string, val float64, tags ...string)
+	BumpMaxHook       func(key string, val float64, tags ...string)
+	BumpLastHook      func(key string, val float64, tags ...string)
 	BumpTimeHook      func(key string, tags ...string) interface {
 		End()
 	}
@@ -111,6 +200,27 @@ func (c *HookClient) BumpHistogram(key string, val float64, tags ...string) {
 	}
 }
 
+// BumpMin will call BumpMinHook if defined.
+func (c *HookClient) BumpMin(key string, val float64, tags ...string) {
+	if c.BumpMinHook != nil {
+		c.BumpMinHook(key, val, tags...)
+	}
+}
+
+// BumpMax will call BumpMaxHook if defined.
+func (c *HookClient) BumpMax(key string, val float64, tags ...string) {
+	if c.BumpMaxHook != nil {
+		c.BumpMaxHook(key, val, tags...)
+	}
+}
+
+// BumpLast will call BumpLastHook if defined.
+func (c *HookClient) BumpLast(key string, val float64, tags ...string) {
+	if c.BumpLastHook != nil {
+		c.BumpLastHook(key, val, tags...)
+	}
+}
+
 // BumpTime will call BumpTimeHook if defined.
 func (c *HookClient) BumpTime(key string, tags ...string) interface {
 	End()
@@ -153,6 +263,36 @@ func BumpHistogram(c Client, key string, val float64, tags ...string) {
 	}
 }
 
+// BumpMin calls BumpMin on the Client if it isn't nil. Clients which aren't
+// an ExtendedClient record the value with BumpHistogram instead.
+func BumpMin(c Client, key string, val float64, tags ...string) {
+	if e, ok := c.(ExtendedClient); ok {
+		e.BumpMin(key, val, tags...)
+	} else if c != nil {
+		c.BumpHistogram(key, val, tags...)
+	}
+}
+
+// BumpMax calls BumpMax on the Client if it isn't nil. Clients which aren't
+// an ExtendedClient record the value with BumpHistogram instead.
+func BumpMax(c Client, key string, val float64, tags ...string) {
+	if e, ok := c.(ExtendedClient); ok {
+		e.BumpMax(key, val, tags...)
+	} else if c != nil {
+		c.BumpHistogram(key, val, tags...)
+	}
+}
+
+// BumpLast calls BumpLast on the Client if it isn't nil. Clients which aren't
+// an ExtendedClient record the value with BumpHistogram instead.
+func BumpLast(c Client, key string, val float64, tags ...string) {
+	if e, ok := c.(ExtendedClient); ok {
+		e.BumpLast(key, val, tags...)
+	} else if c != nil {
+		c.BumpHistogram(key, val, tags...)
+	}
+}
+
 // BumpTime calls BumpTime on the Client if it isn't nil. If the Client is nil
 // it still returns a valid return value which will be a no-op. This is useful
 // when a component has an optional stats.Client.
@@ -1,7 +1,10 @@
 package stats_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/facebookgo/ensure"
 	"github.com/facebookgo/stats"
@@ -27,19 +30,19 @@ func TestPrefixClient(t *testing.T) {
 
 	var keys []string
 	hc := &stats.HookClient{
-		BumpAvgHook: func(key string, val float64) {
+		BumpAvgHook: func(key string, val float64, tags ...string) {
 			keys = append(keys, key)
 			ensure.DeepEqual(t, val, avgVal)
 		},
-		BumpSumHook: func(key string, val float64) {
+		BumpSumHook: func(key string, val float64, tags ...string) {
 			keys = append(keys, key)
 			ensure.DeepEqual(t, val, sumVal)
 		},
-		BumpHistogramHook: func(key string, val float64) {
+		BumpHistogramHook: func(key string, val float64, tags ...string) {
 			keys = append(keys, key)
 			ensure.DeepEqual(t, val, histogramVal)
 		},
-		BumpTimeHook: func(key string) interface {
+		BumpTimeHook: func(key string, tags ...string) interface {
 			End()
 		} {
 			return multiEnderTest{
@@ -54,7 +57,9 @@ func TestPrefixClient(t *testing.T) {
 	pc.BumpAvg(avgKey, avgVal)
 	pc.BumpSum(sumKey, sumVal)
 	pc.BumpHistogram(histogramKey, histogramVal)
-	pc.BumpTime(timeKey).End()
+	e := pc.BumpTime(timeKey)
+	e.End()
+	e.End() // Only the first End is passed on.
 
 	ensure.SameElements(t, keys, []string{
 		prefix1 + avgKey,
@@ -75,3 +80,118 @@ type multiEnderTest struct {
 func (e multiEnderTest) End() {
 	e.EndHook()
 }
+
+type aliasClientTest struct {
+	stats.HookClient
+	Calls []string
+}
+
+func (a *aliasClientTest) record(kind string, keys []string, tags []string) {
+	call := kind + ":" + strings.Join(keys, ",")
+	if len(tags) > 0 {
+		call += ":" + strings.Join(tags, ",")
+	}
+	a.Calls = append(a.Calls, call)
+}
+
+func (a *aliasClientTest) BumpAvgAliases(keys []string, val float64, tags ...string) {
+	a.record("avg", keys, tags)
+}
+
+func (a *aliasClientTest) BumpSumAliases(keys []string, val float64, tags ...string) {
+	a.record("sum", keys, tags)
+}
+
+func (a *aliasClientTest) BumpHistogramAliases(keys []string, val float64, tags ...string) {
+	a.record("histogram", keys, tags)
+}
+
+func (a *aliasClientTest) BumpTimeAliases(keys []string, tags ...string) interface {
+	End()
+} {
+	return &stats.AliasStopper{Keys: keys, Start: time.Now(), Client: a, Tags: tags}
+}
+
+func TestPrefixClientAliases(t *testing.T) {
+	ac := &aliasClientTest{
+		HookClient: stats.HookClient{
+			BumpSumHook: func(key string, val float64, tags ...string) {
+				t.Fatal("unexpected BumpSum")
+			},
+		},
+	}
+	pc := stats.PrefixClient([]string{"a.", "b."}, ac)
+	pc.BumpAvg("avg", 1)
+	pc.BumpSum("sum", 1)
+	pc.BumpHistogram("histogram", 1)
+	pc.BumpTime("time", "t:x").End()
+
+	ensure.DeepEqual(t, ac.Calls, []string{
+		"avg:a.avg,b.avg",
+		"sum:a.sum,b.sum",
+		"histogram:a.histogram,b.histogram",
+		"sum:a.time.total,b.time.total:t:x",
+		"histogram:a.time,b.time:t:x",
+	})
+}
+
+func TestExtendedHelpers(t *testing.T) {
+	t.Parallel()
+	var bumps []string
+	record := func(kind string) func(string, float64, ...string) {
+		return func(key string, val float64, tags ...string) {
+			bumps = append(bumps, fmt.Sprintf("%s:%s:%v", kind, key, val))
+		}
+	}
+	extended := &stats.HookClient{
+		BumpMinHook:  record("min"),
+		BumpMaxHook:  record("max"),
+		BumpLastHook: record("last"),
+	}
+	stats.BumpMin(extended, "a", 1)
+	stats.BumpMax(extended, "b", 2)
+	stats.BumpLast(extended, "c", 3)
+
+	// Clients which don't support the extended types fall back to a
+	// histogram of the key.
+	basic := struct{ stats.Client }{&stats.HookClient{BumpHistogramHook: record("histogram")}}
+	stats.BumpMin(basic, "d", 4)
+	stats.BumpMax(basic, "e", 5)
+	stats.BumpLast(basic, "f", 6)
+
+	stats.BumpMin(nil, "g", 7)
+	stats.BumpMax(nil, "g", 7)
+	stats.BumpLast(nil, "g", 7)
+
+	ensure.DeepEqual(t, bumps, []string{
+		"min:a:1",
+		"max:b:2",
+		"last:c:3",
+		"histogram:d:4",
+		"histogram:e:5",
+		"histogram:f:6",
+	})
+}
+
+func TestPrefixClientExtended(t *testing.T) {
+	t.Parallel()
+	var keys []string
+	record := func(kind string) func(string, float64, ...string) {
+		return func(key string, val float64, tags ...string) {
+			keys = append(keys, kind+":"+key)
+		}
+	}
+	pc := stats.PrefixClient([]string{"a.", "b."}, &stats.HookClient{
+		BumpMinHook:  record("min"),
+		BumpMaxHook:  record("max"),
+		BumpLastHook: record("last"),
+	})
+	stats.BumpMin(pc, "x", 1)
+	stats.BumpMax(pc, "y", 1)
+	stats.BumpLast(pc, "z", 1)
+	ensure.DeepEqual(t, keys, []string{
+		"min:a.x", "min:b.x",
+		"max:a.y", "max:b.y",
+		"last:a.z", "last:b.z",
+	})
+}
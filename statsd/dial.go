@@ -0,0 +1,56 @@
+// Package statsd emits stats as statsd lines over UDP, TCP or unix sockets,
+// and receives them with Listen.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseAddr splits an address into the network and address for net.Dial and
+// net.Listen. Supported forms are:
+//
+//	host:port              UDP
+//	udp://host:port        UDP
+//	tcp://host:port        TCP
+//	unixgram:///path       unix datagram socket
+//	unix:///path           unix stream socket
+//	unixgram://@name       abstract unix datagram socket (Linux)
+//	unix://@name           abstract unix stream socket (Linux)
+//
+// Unix sockets avoid UDP packet loss and port conflicts when talking to a
+// sidecar agent on the same host.
+func ParseAddr(addr string) (network, address string, err error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "udp", addr, nil
+	}
+	network, address = addr[:i], addr[i+3:]
+	switch network {
+	case "udp", "tcp":
+	case "unix", "unixgram":
+		if address == "" || address == "@" {
+			return "", "", fmt.Errorf("statsd: missing socket path in %q", addr)
+		}
+	default:
+		return "", "", fmt.Errorf("statsd: unsupported network in %q", addr)
+	}
+	return network, address, nil
+}
+
+// Dial connects to an address as accepted by ParseAddr.
+func Dial(addr string) (net.Conn, error) {
+	network, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial(network, address)
+}
+
+// IsStream reports if the network is stream oriented, in which case
+// messages need to be newline terminated rather than relying on datagram
+// boundaries.
+func IsStream(network string) bool {
+	return network == "tcp" || network == "unix"
+}
@@ -0,0 +1,119 @@
+package statsd_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats/statsd"
+)
+
+func TestParseAddr(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Addr, Network, Address string
+	}{
+		{"localhost:8125", "udp", "localhost:8125"},
+		{"udp://localhost:8125", "udp", "localhost:8125"},
+		{"tcp://localhost:8125", "tcp", "localhost:8125"},
+		{"unixgram:///var/run/statsd.sock", "unixgram", "/var/run/statsd.sock"},
+		{"unix:///var/run/statsd.sock", "unix", "/var/run/statsd.sock"},
+		{"unixgram://@statsd", "unixgram", "@statsd"},
+	}
+	for _, c := range cases {
+		network, address, err := statsd.ParseAddr(c.Addr)
+		ensure.Nil(t, err, c.Addr)
+		ensure.DeepEqual(t, network, c.Network, c.Addr)
+		ensure.DeepEqual(t, address, c.Address, c.Addr)
+	}
+
+	_, _, err := statsd.ParseAddr("http://localhost")
+	ensure.Err(t, err, regexp.MustCompile("unsupported network"))
+	_, _, err = statsd.ParseAddr("unix://")
+	ensure.Err(t, err, regexp.MustCompile("missing socket path"))
+	_, _, err = statsd.ParseAddr("unixgram://@")
+	ensure.Err(t, err, regexp.MustCompile("missing socket path"))
+
+	ensure.True(t, statsd.IsStream("unix"))
+	ensure.True(t, statsd.IsStream("tcp"))
+	ensure.False(t, statsd.IsStream("unixgram"))
+	ensure.False(t, statsd.IsStream("udp"))
+}
+
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 1024)
+	ensure.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	ensure.Nil(t, err)
+	return string(buf[:n])
+}
+
+func TestDialUnixgram(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+	l, err := net.ListenPacket("unixgram", path)
+	ensure.Nil(t, err)
+	defer l.Close()
+
+	conn, err := statsd.Dial("unixgram://" + path)
+	ensure.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a:1|c"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, readDatagram(t, l), "a:1|c")
+}
+
+func TestDialUnixStream(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+	l, err := net.Listen("unix", path)
+	ensure.Nil(t, err)
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			line = err.Error()
+		}
+		received <- line
+	}()
+
+	conn, err := statsd.Dial("unix://" + path)
+	ensure.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a:1|c\n"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, <-received, "a:1|c\n")
+}
+
+func TestDialAbstract(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only supported on Linux")
+	}
+	name := fmt.Sprintf("@statsd-test-%d", os.Getpid())
+	l, err := net.ListenPacket("unixgram", name)
+	ensure.Nil(t, err)
+	defer l.Close()
+
+	conn, err := statsd.Dial("unixgram://" + name)
+	ensure.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("b:2|g"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, readDatagram(t, l), "b:2|g")
+}
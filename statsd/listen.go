@@ -0,0 +1,160 @@
+package statsd
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// maxDatagramSize is the largest datagram a Listener reads.
+const maxDatagramSize = 64 << 10
+
+// Listener receives statsd lines on an address as accepted by ParseAddr,
+// the receiving end of a Packer for relays and sidecar agents. Datagrams may
+// hold many newline separated lines, and stream connections send newline
+// terminated lines.
+type Listener struct {
+	packet net.PacketConn
+	stream net.Listener
+
+	// path is a unix datagram socket file to remove on Close.
+	path string
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Listen listens on an address as accepted by ParseAddr. A unix socket file
+// must not exist yet, and is removed by Close.
+func Listen(addr string) (*Listener, error) {
+	network, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{conns: map[net.Conn]struct{}{}}
+	if IsStream(network) {
+		l.stream, err = net.Listen(network, address)
+	} else {
+		l.packet, err = net.ListenPacket(network, address)
+		if network == "unixgram" && !strings.HasPrefix(address, "@") {
+			l.path = address
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Addr returns the address listened on, such as the port picked for
+// "udp://127.0.0.1:0".
+func (l *Listener) Addr() net.Addr {
+	if l.stream != nil {
+		return l.stream.Addr()
+	}
+	return l.packet.LocalAddr()
+}
+
+// Serve calls handle for every non empty line received until Close is
+// called, in which case it returns nil once all connections are done. Calls
+// to handle are never concurrent, even with many stream connections.
+func (l *Listener) Serve(handle func(line string)) error {
+	if l.packet != nil {
+		return l.servePacket(handle)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		handleMu sync.Mutex
+	)
+	defer wg.Wait()
+	for {
+		conn, err := l.stream.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !l.track(conn) {
+			conn.Close()
+			return nil
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer l.untrack(conn)
+			s := bufio.NewScanner(conn)
+			s.Buffer(nil, maxDatagramSize)
+			for s.Scan() {
+				if line := s.Text(); line != "" {
+					handleMu.Lock()
+					handle(line)
+					handleMu.Unlock()
+				}
+			}
+		}()
+	}
+}
+
+func (l *Listener) servePacket(handle func(line string)) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := l.packet.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line != "" {
+				handle(line)
+			}
+		}
+	}
+}
+
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	return true
+}
+
+func (l *Listener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, conn)
+	conn.Close()
+}
+
+// Close stops listening, closes open stream connections and removes the
+// socket file of a unix socket.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	if l.stream != nil {
+		// Closing a unix stream listener removes its socket file.
+		return l.stream.Close()
+	}
+	err := l.packet.Close()
+	if l.path != "" {
+		if rerr := os.Remove(l.path); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package statsd_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats/statsd"
+)
+
+// listen serves the address and returns the Listener and a function which
+// waits for n lines, sorted.
+func listen(t *testing.T, addr string) (*statsd.Listener, func(n int) []string) {
+	l, err := statsd.Listen(addr)
+	ensure.Nil(t, err)
+	lines := make(chan string, 100)
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(func(line string) { lines <- line }) }()
+	t.Cleanup(func() {
+		ensure.Nil(t, l.Close())
+		ensure.Nil(t, <-served)
+	})
+	return l, func(n int) []string {
+		var received []string
+		for len(received) < n {
+			select {
+			case line := <-lines:
+				received = append(received, line)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %v, want %d lines", received, n)
+			}
+		}
+		sort.Strings(received)
+		return received
+	}
+}
+
+func TestListen(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for _, addr := range []string{
+		"udp://127.0.0.1:0",
+		"tcp://127.0.0.1:0",
+		"unixgram://" + filepath.Join(dir, "gram.sock"),
+		"unix://" + filepath.Join(dir, "stream.sock"),
+	} {
+		l, receive := listen(t, addr)
+		network, _, _ := statsd.ParseAddr(addr)
+		p, err := statsd.DialPacker(network+"://"+l.Addr().String(), time.Hour)
+		ensure.Nil(t, err, addr)
+		p.BumpSum("a", 1, "t:x")
+		p.BumpAvg("b", 2)
+		p.BumpHistogram("c", 3)
+		ensure.Nil(t, p.Close(), addr)
+		ensure.DeepEqual(t, receive(3), []string{"a:1|c|#t:x", "b:2|g", "c:3|h"}, addr)
+	}
+}
+
+func TestListenAbstract(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only supported on Linux")
+	}
+	_, receive := listen(t, fmt.Sprintf("unixgram://@statsd-listen-%d", os.Getpid()))
+	conn, err := statsd.Dial(fmt.Sprintf("unixgram://@statsd-listen-%d", os.Getpid()))
+	ensure.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a:1|c\n\nb:2|g"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, receive(2), []string{"a:1|c", "b:2|g"})
+}
+
+func TestListenClose(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for _, network := range []string{"unix", "unixgram"} {
+		path := filepath.Join(dir, network+".sock")
+		l, err := statsd.Listen(network + "://" + path)
+		ensure.Nil(t, err)
+		_, err = os.Stat(path)
+		ensure.Nil(t, err)
+		ensure.Nil(t, l.Close())
+		_, err = os.Stat(path)
+		ensure.True(t, os.IsNotExist(err), network)
+	}
+
+	// Close ends open stream connections.
+	l, err := statsd.Listen("unix://" + filepath.Join(dir, "open.sock"))
+	ensure.Nil(t, err)
+	served := make(chan error, 1)
+	go func() { served <- l.Serve(func(string) {}) }()
+	conn, err := statsd.Dial("unix://" + filepath.Join(dir, "open.sock"))
+	ensure.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("a:1|c\n"))
+	ensure.Nil(t, err)
+	ensure.Nil(t, l.Close())
+	ensure.Nil(t, <-served)
+
+	_, err = statsd.Listen("http://localhost")
+	ensure.Err(t, err, regexp.MustCompile("unsupported network"))
+}
@@ -0,0 +1,309 @@
+package statsd
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookgo/stats"
+)
+
+// DefaultMaxPacketSize fits a datagram in a typical ethernet MTU after IP and
+// UDP headers.
+const DefaultMaxPacketSize = 1432
+
+// Packer is a stats.Client which emits statsd lines, packing many lines into
+// each datagram instead of sending one packet per call. Within a flush
+// interval counters and gauges with the same key and tags are aggregated
+// client side and sent once:
+//
+//	BumpSum        key:sum|c        summed over the interval
+//	BumpAvg        key:avg|g        averaged over the interval
+//	BumpHistogram  key:val|h        every value is sent
+//	BumpTime       key:ms|ms        every timing is sent
+//
+// Tags are sent sorted in the DogStatsD "|#tag,tag" form. Characters which
+// would break a line, ":|@" and newlines in keys and ",|" and newlines in
+// tags, are replaced with '_'.
+//
+// Aggregated values are only sent by Flush, which Start calls every interval.
+// Histogram and timer lines are sent as soon as they fill a packet. Anything
+// buffered is lost if the process exits without calling Flush or Close.
+type Packer struct {
+	// Policy is applied to values as they are bumped, defaulting to
+	// stats.InvalidValuePolicy. Under stats.ValueCount dropped values are
+	// counted as key.invalid_values. It must be set before the Packer is
+	// used.
+	Policy stats.ValuePolicy
+
+	w             io.Writer
+	maxPacketSize int
+	stream        bool
+
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]*gauge
+	pending  []byte
+	err      error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type gauge struct {
+	sum   float64
+	count int
+}
+
+// NewPacker creates a Packer writing packets of at most maxPacketSize bytes
+// to w. If stream is true every line is newline terminated, as needed for
+// stream sockets, otherwise lines are newline separated within a datagram.
+func NewPacker(w io.Writer, maxPacketSize int, stream bool) *Packer {
+	if maxPacketSize <= 0 {
+		maxPacketSize = DefaultMaxPacketSize
+	}
+	return &Packer{
+		w:             w,
+		maxPacketSize: maxPacketSize,
+		stream:        stream,
+		counters:      map[string]float64{},
+		gauges:        map[string]*gauge{},
+	}
+}
+
+// DialPacker dials an address as accepted by ParseAddr and returns a Packer
+// flushing every interval. Close the Packer to flush and close the
+// connection.
+func DialPacker(addr string, interval time.Duration) (*Packer, error) {
+	network, _, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPacker(conn, DefaultMaxPacketSize, IsStream(network))
+	p.Start(interval)
+	return p, nil
+}
+
+var (
+	keyEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_")
+	tagEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+)
+
+// series returns the aggregation identity of a key and its tags. Characters
+// which delimit the parts of a line are replaced with '_', and the tags are
+// sorted so their order doesn't matter.
+func series(key string, tags []string) string {
+	key = keyEscaper.Replace(key)
+	if len(tags) == 0 {
+		return key
+	}
+	sorted := make([]string, len(tags))
+	for i, tag := range tags {
+		sorted[i] = tagEscaper.Replace(tag)
+	}
+	sort.Strings(sorted)
+	return key + "\x00" + strings.Join(sorted, ",")
+}
+
+// valid applies the Policy to a value, and reports if it should be recorded.
+func (p *Packer) valid(key string, val float64, tags []string) (float64, bool) {
+	policy := p.Policy
+	if policy == stats.ValueDefault {
+		policy = stats.InvalidValuePolicy
+	}
+	valid, invalid := stats.ValidValues([]float64{val}, policy)
+	if invalid > 0 && policy == stats.ValueCount {
+		p.BumpSum(key+".invalid_values", float64(invalid), tags...)
+	}
+	if len(valid) == 0 {
+		return 0, false
+	}
+	return valid[0], true
+}
+
+func formatLine(id string, val float64, typ string) string {
+	key, tags := id, ""
+	if i := strings.IndexByte(id, 0); i >= 0 {
+		key, tags = id[:i], id[i+1:]
+	}
+	line := key + ":" + strconv.FormatFloat(val, 'g', -1, 64) + "|" + typ
+	if tags != "" {
+		line += "|#" + tags
+	}
+	return line
+}
+
+// BumpAvg is part of the stats.Client interface.
+func (p *Packer) BumpAvg(key string, val float64, tags ...string) {
+	val, ok := p.valid(key, val, tags)
+	if !ok {
+		return
+	}
+	id := series(key, tags)
+	p.mu.Lock()
+	g, ok := p.gauges[id]
+	if !ok {
+		g = &gauge{}
+		p.gauges[id] = g
+	}
+	g.sum += val
+	g.count++
+	p.mu.Unlock()
+}
+
+// BumpSum is part of the stats.Client interface.
+func (p *Packer) BumpSum(key string, val float64, tags ...string) {
+	val, ok := p.valid(key, val, tags)
+	if !ok {
+		return
+	}
+	id := series(key, tags)
+	p.mu.Lock()
+	p.counters[id] += val
+	p.mu.Unlock()
+}
+
+// BumpHistogram is part of the stats.Client interface.
+func (p *Packer) BumpHistogram(key string, val float64, tags ...string) {
+	val, ok := p.valid(key, val, tags)
+	if !ok {
+		return
+	}
+	p.line(formatLine(series(key, tags), val, "h"))
+}
+
+// BumpTime is part of the stats.Client interface.
+func (p *Packer) BumpTime(key string, tags ...string) interface {
+	End()
+} {
+	return &timer{packer: p, id: series(key, tags), start: time.Now()}
+}
+
+type timer struct {
+	packer *Packer
+	id     string
+	start  time.Time
+	ended  int32
+}
+
+func (t *timer) End() {
+	if atomic.CompareAndSwapInt32(&t.ended, 0, 1) {
+		ms := time.Since(t.start).Seconds() * 1000.0
+		t.packer.line(formatLine(t.id, ms, "ms"))
+	}
+}
+
+// line adds a line to the pending packet, sending the packet first if the
+// line doesn't fit.
+func (p *Packer) line(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.appendLine(line)
+}
+
+func (p *Packer) appendLine(line string) {
+	size := len(line)
+	if p.stream || len(p.pending) > 0 {
+		size++
+	}
+	if len(p.pending) > 0 && len(p.pending)+size > p.maxPacketSize {
+		p.send()
+	}
+	if len(p.pending) > 0 && !p.stream {
+		p.pending = append(p.pending, '\n')
+	}
+	p.pending = append(p.pending, line...)
+	if p.stream {
+		p.pending = append(p.pending, '\n')
+	}
+}
+
+func (p *Packer) send() {
+	if len(p.pending) == 0 {
+		return
+	}
+	if _, err := p.w.Write(p.pending); err != nil && p.err == nil {
+		p.err = err
+	}
+	p.pending = p.pending[:0]
+}
+
+// Flush sends the aggregated counters and gauges and any pending lines. It
+// returns the first write error since the last Flush.
+func (p *Packer) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]string, 0, len(p.counters))
+	for id := range p.counters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		p.appendLine(formatLine(id, p.counters[id], "c"))
+	}
+	ids = ids[:0]
+	for id := range p.gauges {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		g := p.gauges[id]
+		p.appendLine(formatLine(id, g.sum/float64(g.count), "g"))
+	}
+	p.counters = map[string]float64{}
+	p.gauges = map[string]*gauge{}
+	p.send()
+
+	err := p.err
+	p.err = nil
+	return err
+}
+
+// Start calls Flush every interval in a goroutine until Close is called.
+// Calling it again before Close has no effect.
+func (p *Packer) Start(interval time.Duration) {
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.Flush()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the flush goroutine if started, flushes, and closes the
+// underlying writer if it is an io.Closer.
+func (p *Packer) Close() error {
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+		p.stop = nil
+	}
+	err := p.Flush()
+	if c, ok := p.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package statsd_test
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/statsd"
+)
+
+type packets struct {
+	mu      sync.Mutex
+	packets []string
+	err     error
+}
+
+func (p *packets) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.packets = append(p.packets, string(b))
+	return len(b), p.err
+}
+
+func (p *packets) get() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.packets...)
+}
+
+var _ stats.Client = (*statsd.Packer)(nil)
+
+func TestPackerAggregates(t *testing.T) {
+	t.Parallel()
+	var w packets
+	p := statsd.NewPacker(&w, 0, false)
+	p.BumpSum("requests", 1, "method:get")
+	p.BumpSum("requests", 2, "method:get")
+	p.BumpSum("requests", 4)
+	p.BumpAvg("load", 1)
+	p.BumpAvg("load", 2)
+	p.BumpHistogram("size", 10)
+	p.BumpHistogram("size", 20)
+	ensure.DeepEqual(t, len(w.get()), 0)
+
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, w.get(), []string{
+		"size:10|h\nsize:20|h\nrequests:4|c\nrequests:3|c|#method:get\nload:1.5|g",
+	})
+
+	// Aggregates reset after a flush, and an empty flush sends nothing.
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, len(w.get()), 1)
+}
+
+func TestPackerTags(t *testing.T) {
+	t.Parallel()
+	var w packets
+	p := statsd.NewPacker(&w, 0, false)
+	p.BumpSum("y", 1, "a:1", "b:2")
+	p.BumpSum("y", 1, "b:2", "a:1")
+	p.BumpHistogram("bad:key|x@y\n", 1, "c:1,2", "d:|\n")
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, w.get(), []string{
+		"bad_key_x_y_:1|h|#c:1_2,d:__\ny:2|c|#a:1,b:2",
+	})
+}
+
+// Changes the global policy, so it can't run in parallel.
+func TestPackerPolicy(t *testing.T) {
+	defer func(p stats.ValuePolicy) { stats.InvalidValuePolicy = p }(stats.InvalidValuePolicy)
+	stats.InvalidValuePolicy = stats.ValueDrop
+
+	var w packets
+	p := statsd.NewPacker(&w, 0, false)
+	p.BumpAvg("x", math.NaN())
+	p.BumpSum("x", math.Inf(1))
+	p.BumpHistogram("x", math.NaN())
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, len(w.get()), 0)
+
+	p.Policy = stats.ValueClamp
+	p.BumpAvg("x", math.Inf(-1))
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, w.get(), []string{"x:-1.7976931348623157e+308|g"})
+
+	p.Policy = stats.ValueCount
+	p.BumpAvg("x", math.NaN(), "t:1")
+	p.BumpAvg("x", 1, "t:1")
+	p.BumpHistogram("h", math.Inf(1))
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, w.get()[1], "h.invalid_values:1|c\nx.invalid_values:1|c|#t:1\nx:1|g|#t:1")
+}
+
+func TestPackerPacketSize(t *testing.T) {
+	t.Parallel()
+	var w packets
+	p := statsd.NewPacker(&w, 20, false)
+	p.BumpHistogram("a", 1) // 5 bytes
+	p.BumpHistogram("b", 2) // 6 with the separator
+	p.BumpHistogram("c", 3) // 6
+	p.BumpHistogram("d", 4) // would make 23, so the packet is sent
+	ensure.DeepEqual(t, w.get(), []string{"a:1|h\nb:2|h\nc:3|h"})
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, w.get(), []string{"a:1|h\nb:2|h\nc:3|h", "d:4|h"})
+}
+
+func TestPackerStream(t *testing.T) {
+	t.Parallel()
+	var w packets
+	p := statsd.NewPacker(&w, 0, true)
+	p.BumpHistogram("a", 1)
+	p.BumpSum("b", 1)
+	ensure.Nil(t, p.Flush())
+	ensure.DeepEqual(t, w.get(), []string{"a:1|h\nb:1|c\n"})
+}
+
+func TestPackerTime(t *testing.T) {
+	t.Parallel()
+	var w packets
+	p := statsd.NewPacker(&w, 0, false)
+	e := p.BumpTime("rpc", "method:get")
+	e.End()
+	e.End()
+	ensure.Nil(t, p.Flush())
+	lines := strings.Split(w.get()[0], "\n")
+	ensure.DeepEqual(t, len(lines), 1)
+	ensure.True(t, strings.HasPrefix(lines[0], "rpc:"), lines)
+	ensure.True(t, strings.HasSuffix(lines[0], "|ms|#method:get"), lines)
+}
+
+func TestPackerWriteError(t *testing.T) {
+	t.Parallel()
+	w := packets{err: errors.New("boom")}
+	p := statsd.NewPacker(&w, 0, false)
+	p.BumpSum("a", 1)
+	ensure.DeepEqual(t, p.Flush(), w.err)
+	p.BumpSum("a", 1)
+	w.err = nil
+	ensure.Nil(t, p.Flush())
+}
+
+func TestDialPacker(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+	l, err := net.ListenPacket("unixgram", path)
+	ensure.Nil(t, err)
+	defer l.Close()
+
+	p, err := statsd.DialPacker("unixgram://"+path, time.Millisecond)
+	ensure.Nil(t, err)
+	p.BumpSum("a", 1)
+	ensure.DeepEqual(t, readDatagram(t, l), "a:1|c")
+	p.BumpSum("b", 1)
+	// Starting again doesn't leave a second flush goroutine behind Close.
+	p.Start(time.Hour)
+	ensure.Nil(t, p.Close())
+	ensure.DeepEqual(t, readDatagram(t, l), "b:1|c")
+}
+
+// The packed and aggregated emitter against one packet per call.
+func BenchmarkPacker(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		size int
+	}{
+		{"Packed", statsd.DefaultMaxPacketSize},
+		{"PacketPerLine", 1},
+	} {
+		c := c
+		b.Run(c.name+"/BumpSum", func(b *testing.B) {
+			p := statsd.NewPacker(io.Discard, c.size, false)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p.BumpSum("requests", 1, "method:get")
+				}
+			})
+			p.Flush()
+		})
+		b.Run(c.name+"/BumpHistogram", func(b *testing.B) {
+			p := statsd.NewPacker(io.Discard, c.size, false)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p.BumpHistogram("size", 1, "method:get")
+				}
+			})
+			p.Flush()
+		})
+	}
+}
+
+// Compares against sending a datagram per call over a real socket.
+func BenchmarkPackerUnixgram(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		size int
+	}{
+		{"Packed", statsd.DefaultMaxPacketSize},
+		{"PacketPerLine", 1},
+	} {
+		c := c
+		b.Run(c.name, func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "statsd.sock")
+			l, err := net.ListenPacket("unixgram", path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+			go func() {
+				buf := make([]byte, 65536)
+				for {
+					if _, _, err := l.ReadFrom(buf); err != nil {
+						return
+					}
+				}
+			}()
+			conn, err := statsd.Dial("unixgram://" + path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			p := statsd.NewPacker(conn, c.size, false)
+			defer p.Close()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				p.BumpHistogram("size", 1)
+			}
+		})
+	}
+}
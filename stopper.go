@@ -1,17 +1,65 @@
 package stats
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
-// Stopper calls Client.BumpSum and Client.BumpHistogram when End'ed
+// Stopper calls Client.BumpSum and Client.BumpHistogram when End'ed. Only the
+// first call to End records.
 type Stopper struct {
 	Key    string
 	Start  time.Time
 	Client Client
+	Tags   []string
+
+	ended  int32
+	active *ActiveTimers
 }
 
 // End the Stopper
 func (s *Stopper) End() {
+	if !atomic.CompareAndSwapInt32(&s.ended, 0, 1) {
+		return
+	}
+	if s.active != nil {
+		s.active.remove(s)
+	}
+	RecordDuration(s.Client, s.Key, time.Since(s.Start), s.Tags...)
+}
+
+// RecordDuration records an already measured duration the same way as
+// Stopper: in milliseconds, summed for key.total and in the histogram for
+// key. The Client may be nil.
+func RecordDuration(c Client, key string, d time.Duration, tags ...string) {
+	ms := d.Seconds() * 1000.0
+	BumpSum(c, key+".total", ms, tags...)
+	BumpHistogram(c, key, ms, tags...)
+}
+
+// AliasStopper is like Stopper but measures once for many keys, calling
+// AliasClient.BumpSumAliases and AliasClient.BumpHistogramAliases when
+// End'ed. AliasClient implementations can return it from BumpTimeAliases.
+// Only the first call to End records.
+type AliasStopper struct {
+	Keys   []string
+	Start  time.Time
+	Client AliasClient
+	Tags   []string
+
+	ended int32
+}
+
+// End the AliasStopper
+func (s *AliasStopper) End() {
+	if !atomic.CompareAndSwapInt32(&s.ended, 0, 1) {
+		return
+	}
 	since := time.Since(s.Start).Seconds() * 1000.0
-	s.Client.BumpSum(s.Key+".total", since)
-	s.Client.BumpHistogram(s.Key, since)
+	totals := make([]string, len(s.Keys))
+	for i, key := range s.Keys {
+		totals[i] = key + ".total"
+	}
+	s.Client.BumpSumAliases(totals, since, s.Tags...)
+	s.Client.BumpHistogramAliases(s.Keys, since, s.Tags...)
 }
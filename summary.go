@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Quantile is a percentile of a Summary.
+type Quantile struct {
+	Label string
+	P     float64
+	Value float64
+}
+
+// Summary summarizes the values of a histogram.
+type Summary struct {
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+	Mean  float64
+
+	// Quantiles are sorted by P.
+	Quantiles []Quantile
+}
+
+// Summarize summarizes the values, applying InvalidValuePolicy and ignoring
+// NaN values like Percentiles. The mean is kept within the minimum and
+// maximum values, which floating point rounding could otherwise violate. It
+// is NaN if the values include both +Inf and -Inf. Summarize doesn't modify
+// values.
+func Summarize(values []float64, percentiles map[string]float64) *Summary {
+	return summarize(values, percentiles, ValueDefault)
+}
+
+func summarize(values []float64, percentiles map[string]float64, policy ValuePolicy) *Summary {
+	values, _ = ValidValues(values, policy)
+	values = dropNaN(values)
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	s := &Summary{Count: len(sorted)}
+	if len(sorted) > 0 {
+		s.Min, s.Max = sorted[0], sorted[len(sorted)-1]
+		s.Sum = sum(sorted, policy)
+		s.Mean = math.Max(s.Min, math.Min(s.Max, average(sorted, policy)))
+	}
+	results := percentilesOf(sorted, percentiles, policy)
+	for label, p := range percentiles {
+		s.Quantiles = append(s.Quantiles, Quantile{Label: label, P: p, Value: results[label]})
+	}
+	sort.Slice(s.Quantiles, func(i, j int) bool {
+		qi, qj := s.Quantiles[i], s.Quantiles[j]
+		if qi.P != qj.P {
+			return qi.P < qj.P
+		}
+		return qi.Label < qj.Label
+	})
+	return s
+}
+
+// Validate returns an error describing every violation of the guarantees of
+// Summarize: quantiles are within [0, 1], sorted, their values don't
+// decrease as P increases, and the mean and quantile values are within the
+// minimum and maximum. It is useful to check summaries received from other
+// processes or computed by other histogram implementations.
+func (s *Summary) Validate() error {
+	var violations []string
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+	inRange := func(v float64) bool {
+		return v >= s.Min && v <= s.Max
+	}
+
+	switch {
+	case s.Count < 0:
+		violate("negative count %d", s.Count)
+	case s.Count == 0:
+		for _, q := range s.Quantiles {
+			if q.Value != 0 {
+				violate("%s is %v without values", q.Label, q.Value)
+			}
+		}
+	default:
+		if !(s.Min <= s.Max) {
+			violate("min %v is greater than max %v", s.Min, s.Max)
+		}
+		if !inRange(s.Mean) {
+			violate("mean %v is outside [%v, %v]", s.Mean, s.Min, s.Max)
+		}
+		for _, q := range s.Quantiles {
+			if !inRange(q.Value) {
+				violate("%s is %v, outside [%v, %v]", q.Label, q.Value, s.Min, s.Max)
+			}
+		}
+	}
+	for i, q := range s.Quantiles {
+		if !(q.P >= 0 && q.P <= 1) {
+			violate("%s has percentile %v outside [0, 1]", q.Label, q.P)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := s.Quantiles[i-1]
+		if q.P < prev.P {
+			violate("%s with percentile %v is after %s with %v", q.Label, q.P, prev.Label, prev.P)
+		} else if q.Value < prev.Value {
+			violate("%s is %v, less than %v for %s", q.Label, q.Value, prev.Value, prev.Label)
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("stats: invalid summary: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
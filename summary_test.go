@@ -0,0 +1,260 @@
+package stats_test
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+	input := []float64{3, 1, math.NaN(), 2, 4}
+	s := stats.Summarize(input, stats.HistogramPercentiles)
+	ensure.DeepEqual(t, s, &stats.Summary{
+		Count: 4,
+		Sum:   10,
+		Min:   1,
+		Max:   4,
+		Mean:  2.5,
+		Quantiles: []stats.Quantile{
+			{Label: "p50", P: 0.5, Value: 3},
+			{Label: "p95", P: 0.95, Value: 4},
+			{Label: "p99", P: 0.99, Value: 4},
+		},
+	})
+	ensure.Nil(t, s.Validate())
+	ensure.DeepEqual(t, input[0], 3.0)
+
+	empty := stats.Summarize(nil, map[string]float64{"p50": 0.5})
+	ensure.DeepEqual(t, empty.Quantiles, []stats.Quantile{{Label: "p50", P: 0.5}})
+	ensure.Nil(t, empty.Validate())
+}
+
+func TestPercentilesEdges(t *testing.T) {
+	t.Parallel()
+	input := []float64{4, 3, math.NaN(), 2, 1}
+	ensure.DeepEqual(t, stats.Percentiles(input, map[string]float64{
+		"min":   0,
+		"max":   1,
+		"below": -1,
+		"above": 2,
+		"nan":   math.NaN(),
+	}), map[string]float64{
+		"min":   1,
+		"max":   4,
+		"below": 1,
+		"above": 4,
+		"nan":   1,
+	})
+	ensure.DeepEqual(t, stats.Percentiles([]float64{math.NaN()}, map[string]float64{"p50": 0.5}),
+		map[string]float64{"p50": 0})
+}
+
+func TestSummaryValidate(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Summary stats.Summary
+		Error   string
+	}{
+		{stats.Summary{Count: -1}, "negative count -1"},
+		{
+			stats.Summary{Quantiles: []stats.Quantile{{Label: "p50", P: 0.5, Value: 1}}},
+			"p50 is 1 without values",
+		},
+		{stats.Summary{Count: 1, Min: 2, Max: 1, Mean: 1}, "min 2 is greater than max 1"},
+		{stats.Summary{Count: 1, Min: 1, Max: 2, Mean: 3}, `mean 3 is outside \[1, 2\]`},
+		{stats.Summary{Count: 1, Min: 1, Max: 2, Mean: math.NaN()}, `mean NaN is outside \[1, 2\]`},
+		{
+			stats.Summary{Count: 2, Min: 1, Max: 2, Mean: 1.5, Quantiles: []stats.Quantile{
+				{Label: "p50", P: 0.5, Value: 0},
+			}},
+			`p50 is 0, outside \[1, 2\]`,
+		},
+		{
+			stats.Summary{Count: 2, Min: 1, Max: 2, Mean: 1.5, Quantiles: []stats.Quantile{
+				{Label: "p50", P: 0.5, Value: 2},
+				{Label: "p99", P: 0.99, Value: 1},
+			}},
+			"p99 is 1, less than 2 for p50",
+		},
+		{
+			stats.Summary{Count: 2, Min: 1, Max: 2, Mean: 1.5, Quantiles: []stats.Quantile{
+				{Label: "p99", P: 0.99, Value: 1},
+				{Label: "p50", P: 0.5, Value: 2},
+			}},
+			"p50 with percentile 0.5 is after p99 with 0.99",
+		},
+		{
+			stats.Summary{Count: 2, Min: 1, Max: 2, Mean: 1.5, Quantiles: []stats.Quantile{
+				{Label: "bad", P: 1.5, Value: 2},
+			}},
+			`bad has percentile 1.5 outside \[0, 1\]`,
+		},
+	}
+	for _, c := range cases {
+		ensure.Err(t, c.Summary.Validate(), regexp.MustCompile("stats: invalid summary: .*"+c.Error))
+	}
+}
+
+// percentiles maps arbitrary floats to percentiles within [0, 1].
+func percentiles(ps []float64) map[string]float64 {
+	m := map[string]float64{"p0": 0, "p100": 1}
+	for i, p := range ps {
+		m[string(rune('a'+i%26))+string(rune('a'+i/26%26))] = math.Abs(math.Mod(p, 1))
+	}
+	return m
+}
+
+func checkSummary(values []float64, ps map[string]float64) bool {
+	s := stats.Summarize(values, ps)
+	if s.Validate() != nil {
+		return false
+	}
+	// Quantiles are exactly the results of Percentiles.
+	results := stats.Percentiles(append([]float64(nil), values...), ps)
+	for _, q := range s.Quantiles {
+		if results[q.Label] != q.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSummaryProperties(t *testing.T) {
+	t.Parallel()
+	f := func(values, ps []float64) bool {
+		return checkSummary(values, percentiles(ps))
+	}
+	ensure.Nil(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
+}
+
+func TestSummaryPropertiesSmallSamples(t *testing.T) {
+	t.Parallel()
+	// Few samples with many ties are where rank rounding goes wrong.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		values := make([]float64, r.Intn(5))
+		for j := range values {
+			values[j] = float64(r.Intn(3))
+		}
+		ps := make([]float64, r.Intn(6))
+		for j := range ps {
+			ps[j] = r.Float64()
+		}
+		ensure.True(t, checkSummary(values, percentiles(ps)), values, ps)
+	}
+}
+
+func TestSummaryPropertiesAfterMerge(t *testing.T) {
+	t.Parallel()
+	f := func(a, b, ps []float64) bool {
+		agg := stats.Aggregates{}
+		agg.Add(&stats.SimpleCounter{Key: "h", Values: append([]float64(nil), a...), Type: stats.AggregateHistogram})
+		agg.Add(&stats.SimpleCounter{Key: "h", Values: b, Type: stats.AggregateHistogram})
+		merged := stats.Summarize(agg["h"].GetValues(), percentiles(ps))
+		if merged.Validate() != nil || merged.Count != len(a)+len(b) {
+			return false
+		}
+		// The merged extremes bound the extremes of the parts.
+		for _, part := range [][]float64{a, b} {
+			if len(part) == 0 {
+				continue
+			}
+			s := stats.Summarize(part, nil)
+			if s.Min < merged.Min || s.Max > merged.Max {
+				return false
+			}
+		}
+		return true
+	}
+	ensure.Nil(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
+}
+
+func TestSummaryPropertiesInvalidValues(t *testing.T) {
+	t.Parallel()
+	values := []float64{math.Inf(1), 1, math.NaN(), 2}
+	s := stats.Summarize(values, stats.HistogramPercentiles)
+	ensure.Nil(t, s.Validate())
+	ensure.DeepEqual(t, s.Max, math.Inf(1))
+	ensure.DeepEqual(t, s.Mean, math.Inf(1))
+
+	s = stats.Summarize([]float64{math.Inf(1), math.Inf(-1)}, stats.HistogramPercentiles)
+	ensure.True(t, math.IsNaN(s.Mean))
+	ensure.NotNil(t, s.Validate())
+}
+
+// checkOrdered reports if the HistogramPercentiles under key in the result of
+// Aggregate are ordered and within [min, max].
+func checkOrdered(result map[string]float64, key string, min, max float64) bool {
+	prev := min
+	for _, label := range []string{"p50", "p95", "p99"} {
+		v, ok := result[key+"."+label]
+		if !ok {
+			continue
+		}
+		if v < prev || v > max {
+			return false
+		}
+		prev = v
+	}
+	return true
+}
+
+func TestSummaryPropertiesReservoir(t *testing.T) {
+	t.Parallel()
+	for _, decay := range []float64{0, 0.015} {
+		r := rand.New(rand.NewSource(1))
+		f := func(values, ps []float64) bool {
+			c := &stats.ReservoirCounter{Key: "h", Size: 16, Decay: decay, Rand: r}
+			start := time.Unix(0, 0)
+			for i, v := range values {
+				c.AddValuesAt(start.Add(time.Duration(i)*time.Second), v)
+			}
+			s := c.Summary(percentiles(ps))
+			if s.Validate() != nil || s.Count != len(values) {
+				return false
+			}
+			return len(values) == 0 || checkOrdered(c.Aggregate(), "h", s.Min, s.Max)
+		}
+		ensure.Nil(t, quick.Check(f, &quick.Config{MaxCount: 500}), decay)
+	}
+}
+
+func TestSummaryPropertiesAggregator(t *testing.T) {
+	t.Parallel()
+	f := func(values, ps []float64) bool {
+		a := &stats.Aggregator{}
+		for _, v := range values {
+			a.BumpHistogram("h", v)
+		}
+		counters, err := a.Flush()
+		if err != nil {
+			return false
+		}
+		if len(values) == 0 {
+			return len(counters) == 0
+		}
+		c := counters["h"]
+		s := stats.Summarize(c.GetValues(), percentiles(ps))
+		if s.Validate() != nil || s.Count != len(values) {
+			return false
+		}
+		// The exported quantiles are ordered and within [min, max] as well.
+		p := stats.NewPoint(c, nil)
+		prev := s.Min
+		for _, q := range p.Quantiles {
+			if q.Value < prev || q.Value > s.Max {
+				return false
+			}
+			prev = q.Value
+		}
+		return checkOrdered(c.(*stats.SimpleCounter).Aggregate(), "h", s.Min, s.Max)
+	}
+	ensure.Nil(t, quick.Check(f, &quick.Config{MaxCount: 1000}))
+}
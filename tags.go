@@ -0,0 +1,20 @@
+package stats
+
+import "strings"
+
+// SplitTag splits a tag of the form "key:value" or "key=value" at the first
+// separator. A tag without a separator is returned as the key with an empty
+// value.
+func SplitTag(tag string) (key, value string) {
+	if i := strings.IndexAny(tag, ":="); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// Tagged is implemented by counters which carry tags, such as
+// SimpleCounter. Their FullKey includes the tags, while GetKey doesn't.
+type Tagged interface {
+	GetKey() string
+	GetTags() []string
+}
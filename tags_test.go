@@ -0,0 +1,27 @@
+package stats_test
+
+import (
+	"testing"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+)
+
+func TestSplitTag(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Tag, Key, Value string
+	}{
+		{"method:get", "method", "get"},
+		{"method=get", "method", "get"},
+		{"url=http://x", "url", "http://x"},
+		{"host:a=b", "host", "a=b"},
+		{"flag", "flag", ""},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		key, value := stats.SplitTag(c.Tag)
+		ensure.DeepEqual(t, key, c.Key, c.Tag)
+		ensure.DeepEqual(t, value, c.Value, c.Tag)
+	}
+}
@@ -0,0 +1,224 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Every record in a segment is framed as:
+//
+//	uint32 payload length
+//	uint32 CRC-32C of the payload
+//	payload
+//
+// In raw segments, which are appended to, the payload is a snapshot at a
+// single timestamp:
+//
+//	varint timestamp, uvarint count, count * (uvarint key length, key,
+//	uint64 float bits)
+//
+// In sealed and rollup segments, which are written whole, the payload is the
+// points of one series as a gorilla block, with delta-of-delta timestamps
+// and XOR compressed values:
+//
+//	uvarint key length, key, gorilla block
+//
+// A torn write at the end of a segment fails the length or checksum check,
+// and the segment is truncated to the last complete record when opened.
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+const recordHeaderSize = 8
+
+// maxRecordSize bounds the allocation for a corrupt length.
+const maxRecordSize = 64 << 20
+
+var errCorruptRecord = errors.New("tsdb: corrupt record")
+
+type record struct {
+	t      int64
+	values map[string]float64
+}
+
+// frame adds the length and checksum header to a payload.
+func frame(payload []byte) []byte {
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(b, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[4:], crc32.Checksum(payload, castagnoli))
+	return append(b, payload...)
+}
+
+func encodeRecord(t int64, values map[string]float64) []byte {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	payload := make([]byte, 0, 16+len(values)*24)
+	payload = binary.AppendVarint(payload, t)
+	payload = binary.AppendUvarint(payload, uint64(len(keys)))
+	for _, k := range keys {
+		payload = binary.AppendUvarint(payload, uint64(len(k)))
+		payload = append(payload, k...)
+		payload = binary.LittleEndian.AppendUint64(payload, math.Float64bits(values[k]))
+	}
+	return frame(payload)
+}
+
+func encodeBlock(key string, block []byte) []byte {
+	payload := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(block))
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	return frame(append(payload, block...))
+}
+
+func decodeBlock(payload []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(payload)
+	if n <= 0 || l > uint64(len(payload)-n) {
+		return "", nil, errCorruptRecord
+	}
+	return string(payload[n : n+int(l)]), payload[n+int(l):], nil
+}
+
+func decodePayload(payload []byte) (*record, error) {
+	t, n := binary.Varint(payload)
+	if n <= 0 {
+		return nil, errCorruptRecord
+	}
+	payload = payload[n:]
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > uint64(len(payload)) {
+		return nil, errCorruptRecord
+	}
+	payload = payload[n:]
+	r := &record{t: t, values: make(map[string]float64, count)}
+	for i := uint64(0); i < count; i++ {
+		l, n := binary.Uvarint(payload)
+		if n <= 0 || l > uint64(len(payload)-n) || len(payload)-n-int(l) < 8 {
+			return nil, errCorruptRecord
+		}
+		key := string(payload[n : n+int(l)])
+		payload = payload[n+int(l):]
+		r.values[key] = math.Float64frombits(binary.LittleEndian.Uint64(payload))
+		payload = payload[8:]
+	}
+	if len(payload) != 0 {
+		return nil, errCorruptRecord
+	}
+	return r, nil
+}
+
+// readFrames calls fn with the payload of every valid record, and returns
+// the offset after the last valid record. Reading stops at the first invalid
+// record, or when fn returns false.
+func readFrames(name string, fn func(payload []byte) bool) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return offset, nil
+		}
+		size := binary.LittleEndian.Uint32(header)
+		if size > maxRecordSize {
+			return offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return offset, nil
+		}
+		if crc32.Checksum(payload, castagnoli) != binary.LittleEndian.Uint32(header[4:]) {
+			return offset, nil
+		}
+		if !fn(payload) {
+			return offset, nil
+		}
+		offset += int64(recordHeaderSize) + int64(size)
+	}
+}
+
+// readSegment calls fn for every valid snapshot of a raw segment.
+func readSegment(name string, fn func(*record)) (int64, error) {
+	return readFrames(name, func(payload []byte) bool {
+		r, err := decodePayload(payload)
+		if err != nil {
+			return false
+		}
+		fn(r)
+		return true
+	})
+}
+
+// readBlocks calls fn for every valid block of a sealed or rollup segment.
+func readBlocks(name string, fn func(key string, block []byte)) (int64, error) {
+	return readFrames(name, func(payload []byte) bool {
+		key, block, err := decodeBlock(payload)
+		if err != nil {
+			return false
+		}
+		fn(key, block)
+		return true
+	})
+}
+
+// writeSegmentFile atomically writes a complete segment of framed records.
+func writeSegmentFile(name string, records [][]byte) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		if _, err := w.Write(r); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir makes creating, renaming and removing files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,573 @@
+// Package tsdb is a lightweight embedded time series store for aggregated
+// snapshots, such as the output of stats.SimpleCounter.Aggregate at every
+// flush. It is meant for edge deployments and CLIs which can't ship metrics to
+// a remote backend, and for keeping local history for post-mortems.
+//
+// Snapshots are appended to raw segment files which each cover a fixed
+// window of time. An in-memory index records which series each segment
+// contains so queries only read the relevant segments, and the points of the
+// segment being appended to are also kept compressed in a gorilla.History so
+// queries of recent data don't read it. Compact applies
+// retention by deleting old segments, seals raw segments whose window has
+// passed by rewriting each series as a gorilla compressed block, and
+// replaces segments older than RollupAfter with rollups holding each series
+// aggregated per RollupInterval, by default as the average.
+//
+// Appends are crash-safe: a torn record at the end of a segment is detected by
+// its checksum and discarded when the DB is opened, and a failed write is
+// truncated away right away. Sealed segments and rollups are written to a
+// temporary file and renamed into place before the segment they replace is
+// removed, and the directory is synced after files are created, renamed or
+// removed.
+package tsdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/gorilla"
+)
+
+// Options configure a DB.
+type Options struct {
+	// SegmentDuration is the window of time covered by each segment. It
+	// defaults to an hour, and must be at least a millisecond.
+	SegmentDuration time.Duration
+
+	// Retention is how long data is kept. Segments are removed once their
+	// whole window is older than the retention. Zero keeps data forever.
+	Retention time.Duration
+
+	// RollupAfter is the age after which raw segments are rolled up. Zero
+	// disables rollups.
+	RollupAfter time.Duration
+
+	// RollupInterval is the resolution of rollups. It defaults to a minute,
+	// and must be at least a millisecond.
+	RollupInterval time.Duration
+
+	// RollupType returns how the values of a series are aggregated in
+	// rollups, such as stats.AggregateSum for series of sums. Series are
+	// averaged if it is nil.
+	RollupType func(key string) stats.Type
+
+	// Sync calls fsync after every Append.
+	Sync bool
+}
+
+// Point is a single value of a series. T is in milliseconds since the epoch.
+type Point struct {
+	T int64
+	V float64
+}
+
+// kind is the kind of a segment. A window has a single segment, and each kind
+// replaces the previous ones.
+type kind int
+
+const (
+	raw kind = iota
+	sealed
+	rollup
+)
+
+var kindNames = []string{"raw", "sealed", "rollup"}
+
+type segment struct {
+	start int64
+	kind  kind
+	keys  map[string]int
+}
+
+// DB is an open store. It is safe for concurrent use.
+type DB struct {
+	dir  string
+	opts Options
+
+	mu       sync.Mutex
+	segments []*segment
+	active   *os.File
+	activeAt *segment
+
+	// activeSize is the size of the active segment after its last complete
+	// record.
+	activeSize int64
+
+	// recent holds the points of the active segment.
+	recent *gorilla.History
+}
+
+// recentBlockSize is the number of points per block of the recent history.
+const recentBlockSize = 120
+
+// Open opens or creates the store in dir.
+func Open(dir string, opts Options) (*DB, error) {
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = time.Hour
+	}
+	if opts.RollupInterval <= 0 {
+		opts.RollupInterval = time.Minute
+	}
+	// Times are in milliseconds, so shorter durations would be zero.
+	if opts.SegmentDuration < time.Millisecond {
+		return nil, fmt.Errorf("tsdb: SegmentDuration %s is under 1ms", opts.SegmentDuration)
+	}
+	if opts.RollupInterval < time.Millisecond {
+		return nil, fmt.Errorf("tsdb: RollupInterval %s is under 1ms", opts.RollupInterval)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := &DB{dir: dir, opts: opts}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) load() error {
+	files, err := os.ReadDir(db.dir)
+	if err != nil {
+		return err
+	}
+	kinds := map[int64][]kind{}
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// An incomplete sealed segment or rollup, the segment it
+			// replaces is still in place.
+			if err := os.Remove(filepath.Join(db.dir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		start, k, ok := parseSegmentName(name)
+		if !ok {
+			continue
+		}
+		kinds[start] = append(kinds[start], k)
+	}
+
+	for start, ks := range kinds {
+		sort.Slice(ks, func(i, j int) bool { return ks[i] > ks[j] })
+		// The replacement was renamed into place before the segments it
+		// replaces were removed.
+		for _, k := range ks[1:] {
+			if err := os.Remove(db.segmentPath(start, k)); err != nil {
+				return err
+			}
+		}
+		s, err := db.index(start, ks[0])
+		if err != nil {
+			return err
+		}
+		db.segments = append(db.segments, s)
+	}
+	db.sortSegments()
+	return nil
+}
+
+// index reads a segment to build its index, truncating a torn tail.
+func (db *DB) index(start int64, k kind) (*segment, error) {
+	s := &segment{start: start, kind: k, keys: map[string]int{}}
+	name := db.segmentPath(start, k)
+	var valid int64
+	var err error
+	if k == raw {
+		valid, err = readSegment(name, func(r *record) {
+			for key := range r.values {
+				s.keys[key]++
+			}
+		})
+	} else {
+		valid, err = readBlocks(name, func(key string, block []byte) {
+			s.keys[key]++
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != valid {
+		if err := os.Truncate(name, valid); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func parseSegmentName(name string) (int64, kind, bool) {
+	if !strings.HasSuffix(name, ".seg") {
+		return 0, 0, false
+	}
+	for k, prefix := range kindNames {
+		rest := strings.TrimPrefix(name, prefix+"-")
+		if rest == name {
+			continue
+		}
+		start, err := strconv.ParseInt(strings.TrimSuffix(rest, ".seg"), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return start, kind(k), true
+	}
+	return 0, 0, false
+}
+
+func (db *DB) segmentPath(start int64, k kind) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%s-%d.seg", kindNames[k], start))
+}
+
+func (db *DB) sortSegments() {
+	sort.Slice(db.segments, func(i, j int) bool {
+		return db.segments[i].start < db.segments[j].start
+	})
+}
+
+func (db *DB) segmentMillis() int64 {
+	return int64(db.opts.SegmentDuration / time.Millisecond)
+}
+
+func (db *DB) segmentStart(t int64) int64 {
+	d := db.segmentMillis()
+	start := t / d * d
+	if t < 0 && t%d != 0 {
+		start -= d
+	}
+	return start
+}
+
+func (db *DB) find(start int64) *segment {
+	for _, s := range db.segments {
+		if s.start == start {
+			return s
+		}
+	}
+	return nil
+}
+
+// Append adds a snapshot of values at time t.
+func (db *DB) Append(t time.Time, values map[string]float64) error {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	start := db.segmentStart(ms)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if s := db.find(start); s != nil && s.kind != raw {
+		return fmt.Errorf("tsdb: segment for %s has already been %s", t, kindNames[s.kind])
+	}
+	if db.activeAt == nil || db.activeAt.start != start {
+		if err := db.closeActive(); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(db.segmentPath(start, raw), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		recent := gorilla.NewHistory(recentBlockSize, 0)
+		s := db.find(start)
+		if s == nil {
+			if err := syncDir(db.dir); err != nil {
+				f.Close()
+				return err
+			}
+			s = &segment{start: start, keys: map[string]int{}}
+			db.segments = append(db.segments, s)
+			db.sortSegments()
+		} else {
+			_, err := readSegment(db.segmentPath(start, raw), func(r *record) {
+				recent.Record(r.t, r.values)
+			})
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+		db.active, db.activeAt, db.activeSize, db.recent = f, s, info.Size(), recent
+	}
+
+	b := encodeRecord(ms, values)
+	if _, err := db.active.Write(b); err != nil {
+		// Remove the partial record so later appends aren't lost behind it
+		// when the segment is read. If that fails too the segment is closed,
+		// and the torn record is discarded when the DB is next opened.
+		if terr := db.active.Truncate(db.activeSize); terr != nil {
+			db.closeActive()
+		}
+		return err
+	}
+	db.activeSize += int64(len(b))
+	if db.opts.Sync {
+		if err := db.active.Sync(); err != nil {
+			return err
+		}
+	}
+	for k := range values {
+		db.activeAt.keys[k]++
+	}
+	db.recent.Record(ms, values)
+	return nil
+}
+
+func (db *DB) closeActive() error {
+	if db.active == nil {
+		return nil
+	}
+	err := db.active.Close()
+	db.active, db.activeAt, db.recent = nil, nil, nil
+	return err
+}
+
+// Keys returns the sorted keys of every series in the store.
+func (db *DB) Keys() []string {
+	db.mu.Lock()
+	seen := map[string]bool{}
+	for _, s := range db.segments {
+		for k := range s.keys {
+			seen[k] = true
+		}
+	}
+	db.mu.Unlock()
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Query returns the points for key with from <= T < to, ordered by time.
+// Points older than RollupAfter are at the rollup resolution.
+func (db *DB) Query(key string, from, to time.Time) ([]Point, error) {
+	fromMs := from.UnixNano() / int64(time.Millisecond)
+	toMs := to.UnixNano() / int64(time.Millisecond)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var points []Point
+	for _, s := range db.segments {
+		if s.keys[key] == 0 || s.start >= toMs || s.start+db.segmentMillis() <= fromMs {
+			continue
+		}
+		if s == db.activeAt {
+			recent, err := db.recent.Query(key, fromMs, toMs)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range recent {
+				points = append(points, Point(p))
+			}
+			continue
+		}
+		match := func(k string) bool { return k == key }
+		err := db.scan(s, match, func(_ string, t int64, v float64) {
+			if t >= fromMs && t < toMs {
+				points = append(points, Point{T: t, V: v})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].T < points[j].T
+	})
+	return points, nil
+}
+
+// scan calls fn for every point of the series of the segment which match,
+// in the order they are stored.
+func (db *DB) scan(s *segment, match func(key string) bool, fn func(key string, t int64, v float64)) error {
+	name := db.segmentPath(s.start, s.kind)
+	if s.kind == raw {
+		_, err := readSegment(name, func(r *record) {
+			for k, v := range r.values {
+				if match(k) {
+					fn(k, r.t, v)
+				}
+			}
+		})
+		return err
+	}
+	var ierr error
+	_, err := readBlocks(name, func(k string, block []byte) {
+		if ierr != nil || !match(k) {
+			return
+		}
+		it, err := gorilla.NewIterator(block)
+		if err != nil {
+			ierr = err
+			return
+		}
+		for it.Next() {
+			t, v := it.At()
+			fn(k, t, v)
+		}
+		ierr = it.Err()
+	})
+	if err != nil {
+		return err
+	}
+	return ierr
+}
+
+// Compact applies retention, seals raw segments whose window has passed and
+// rolls up old segments, as of now.
+func (db *DB) Compact(now time.Time) error {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var kept []*segment
+	for i, s := range db.segments {
+		end := s.start + db.segmentMillis()
+		if db.opts.Retention > 0 && end <= nowMs-int64(db.opts.Retention/time.Millisecond) {
+			if err := db.remove(s); err != nil {
+				db.segments = append(kept, db.segments[i:]...)
+				return err
+			}
+			continue
+		}
+		var err error
+		switch {
+		case s.kind != rollup && db.opts.RollupAfter > 0 && end <= nowMs-int64(db.opts.RollupAfter/time.Millisecond):
+			s, err = db.rollup(s)
+		case s.kind == raw && end <= nowMs:
+			s, err = db.seal(s)
+		}
+		if err != nil {
+			db.segments = append(kept, db.segments[i:]...)
+			return err
+		}
+		kept = append(kept, s)
+	}
+	db.segments = kept
+	return nil
+}
+
+func (db *DB) remove(s *segment) error {
+	if db.activeAt == s {
+		if err := db.closeActive(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(db.segmentPath(s.start, s.kind)); err != nil {
+		return err
+	}
+	return syncDir(db.dir)
+}
+
+// replace writes a segment of kind k with the points of each series in
+// order, and removes s which it replaces.
+func (db *DB) replace(s *segment, k kind, series map[string][]Point) (*segment, error) {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	replaced := &segment{start: s.start, kind: k, keys: map[string]int{}}
+	records := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		e := gorilla.NewEncoder()
+		for _, p := range series[key] {
+			e.Append(p.T, p.V)
+		}
+		records = append(records, encodeBlock(key, e.Bytes()))
+		replaced.keys[key]++
+	}
+	if err := writeSegmentFile(db.segmentPath(s.start, k), records); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(db.segmentPath(s.start, s.kind)); err != nil {
+		return nil, err
+	}
+	if err := syncDir(db.dir); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+func all(string) bool { return true }
+
+// seal replaces a raw segment with one holding a gorilla block per series.
+func (db *DB) seal(s *segment) (*segment, error) {
+	if db.activeAt == s {
+		if err := db.closeActive(); err != nil {
+			return nil, err
+		}
+	}
+	series := map[string][]Point{}
+	err := db.scan(s, all, func(key string, t int64, v float64) {
+		series[key] = append(series[key], Point{T: t, V: v})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, points := range series {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].T < points[j].T
+		})
+	}
+	return db.replace(s, sealed, series)
+}
+
+// rollup replaces a raw or sealed segment with each series aggregated by its
+// RollupType per RollupInterval.
+func (db *DB) rollup(s *segment) (*segment, error) {
+	if db.activeAt == s {
+		if err := db.closeActive(); err != nil {
+			return nil, err
+		}
+	}
+	interval := int64(db.opts.RollupInterval / time.Millisecond)
+	buckets := map[string]map[int64][]float64{}
+	err := db.scan(s, all, func(key string, t int64, v float64) {
+		if buckets[key] == nil {
+			buckets[key] = map[int64][]float64{}
+		}
+		b := t - (t-s.start)%interval
+		buckets[key][b] = append(buckets[key][b], v)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	series := map[string][]Point{}
+	for key, values := range buckets {
+		typ := stats.AggregateAvg
+		if db.opts.RollupType != nil {
+			typ = db.opts.RollupType(key)
+		}
+		points := make([]Point, 0, len(values))
+		for b, vs := range values {
+			points = append(points, Point{T: b, V: stats.AggregateValue(typ, vs)})
+		}
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].T < points[j].T
+		})
+		series[key] = points
+	}
+	return db.replace(s, rollup, series)
+}
+
+// Close closes the store.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.closeActive()
+}
@@ -0,0 +1,334 @@
+package tsdb_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/ensure"
+	"github.com/facebookgo/stats"
+	"github.com/facebookgo/stats/tsdb"
+)
+
+var epoch = time.Unix(1500000000, 0)
+
+func at(d time.Duration) time.Time {
+	return epoch.Add(d)
+}
+
+func ms(d time.Duration) int64 {
+	return at(d).UnixNano() / int64(time.Millisecond)
+}
+
+func query(t *testing.T, db *tsdb.DB, key string) []tsdb.Point {
+	points, err := db.Query(key, at(-24*time.Hour), at(24*time.Hour))
+	ensure.Nil(t, err)
+	return points
+}
+
+func TestAppendQuery(t *testing.T) {
+	t.Parallel()
+	db, err := tsdb.Open(t.TempDir(), tsdb.Options{SegmentDuration: time.Hour})
+	ensure.Nil(t, err)
+	defer db.Close()
+
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1, "b": 2}))
+	ensure.Nil(t, db.Append(at(time.Minute), map[string]float64{"a": 3}))
+	ensure.Nil(t, db.Append(at(2*time.Hour), map[string]float64{"a": 5}))
+	// Out of order into an earlier segment.
+	ensure.Nil(t, db.Append(at(30*time.Minute), map[string]float64{"a": 4}))
+
+	ensure.DeepEqual(t, db.Keys(), []string{"a", "b"})
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{
+		{T: ms(0), V: 1},
+		{T: ms(time.Minute), V: 3},
+		{T: ms(30 * time.Minute), V: 4},
+		{T: ms(2 * time.Hour), V: 5},
+	})
+	ensure.DeepEqual(t, query(t, db, "b"), []tsdb.Point{{T: ms(0), V: 2}})
+
+	points, err := db.Query("a", at(time.Minute), at(2*time.Hour))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, points, []tsdb.Point{
+		{T: ms(time.Minute), V: 3},
+		{T: ms(30 * time.Minute), V: 4},
+	})
+	ensure.DeepEqual(t, len(query(t, db, "missing")), 0)
+}
+
+func TestOpenDurations(t *testing.T) {
+	t.Parallel()
+	_, err := tsdb.Open(t.TempDir(), tsdb.Options{SegmentDuration: time.Microsecond})
+	ensure.Err(t, err, regexp.MustCompile("SegmentDuration 1µs is under 1ms"))
+	_, err = tsdb.Open(t.TempDir(), tsdb.Options{RollupInterval: time.Microsecond})
+	ensure.Err(t, err, regexp.MustCompile("RollupInterval 1µs is under 1ms"))
+
+	db, err := tsdb.Open(t.TempDir(), tsdb.Options{SegmentDuration: time.Millisecond})
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1}))
+}
+
+func TestQueryRecent(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, err := tsdb.Open(dir, tsdb.Options{SegmentDuration: time.Hour})
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1}))
+	ensure.Nil(t, db.Append(at(time.Minute), map[string]float64{"a": 2}))
+
+	// The segment being appended to is queried from memory.
+	raw, err := filepath.Glob(filepath.Join(dir, "raw-*.seg"))
+	ensure.Nil(t, err)
+	b, err := os.ReadFile(raw[0])
+	ensure.Nil(t, err)
+	ensure.Nil(t, os.WriteFile(raw[0], nil, 0644))
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{{T: ms(0), V: 1}, {T: ms(time.Minute), V: 2}})
+	ensure.Nil(t, os.WriteFile(raw[0], b, 0644))
+
+	// Moving to another segment and back reloads it from the file.
+	ensure.Nil(t, db.Append(at(2*time.Hour), map[string]float64{"a": 4}))
+	ensure.Nil(t, db.Append(at(2*time.Minute), map[string]float64{"a": 3}))
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{
+		{T: ms(0), V: 1},
+		{T: ms(time.Minute), V: 2},
+		{T: ms(2 * time.Minute), V: 3},
+		{T: ms(2 * time.Hour), V: 4},
+	})
+}
+
+func TestReopen(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, err := tsdb.Open(dir, tsdb.Options{Sync: true})
+	ensure.Nil(t, err)
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1}))
+	ensure.Nil(t, db.Close())
+
+	db, err = tsdb.Open(dir, tsdb.Options{})
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.Nil(t, db.Append(at(time.Second), map[string]float64{"a": 2}))
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{
+		{T: ms(0), V: 1},
+		{T: ms(time.Second), V: 2},
+	})
+}
+
+// A torn write at the end of a segment is discarded and appends continue
+// after the last complete record.
+func TestTornWrite(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, err := tsdb.Open(dir, tsdb.Options{})
+	ensure.Nil(t, err)
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1}))
+	ensure.Nil(t, db.Append(at(time.Second), map[string]float64{"a": 2}))
+	ensure.Nil(t, db.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "raw-*.seg"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(segments), 1)
+	info, err := os.Stat(segments[0])
+	ensure.Nil(t, err)
+	// Cut the last record short.
+	ensure.Nil(t, os.Truncate(segments[0], info.Size()-3))
+
+	db, err = tsdb.Open(dir, tsdb.Options{})
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{{T: ms(0), V: 1}})
+	ensure.Nil(t, db.Append(at(2*time.Second), map[string]float64{"a": 3}))
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{
+		{T: ms(0), V: 1},
+		{T: ms(2 * time.Second), V: 3},
+	})
+}
+
+func TestCorruptRecord(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, err := tsdb.Open(dir, tsdb.Options{})
+	ensure.Nil(t, err)
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1}))
+	ensure.Nil(t, db.Append(at(time.Second), map[string]float64{"a": 2}))
+	ensure.Nil(t, db.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "raw-*.seg"))
+	ensure.Nil(t, err)
+	b, err := os.ReadFile(segments[0])
+	ensure.Nil(t, err)
+	b[len(b)-1] ^= 0xff
+	ensure.Nil(t, os.WriteFile(segments[0], b, 0644))
+
+	db, err = tsdb.Open(dir, tsdb.Options{})
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{{T: ms(0), V: 1}})
+}
+
+func TestRetention(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	db, err := tsdb.Open(dir, tsdb.Options{
+		SegmentDuration: time.Hour,
+		Retention:       2 * time.Hour,
+	})
+	ensure.Nil(t, err)
+	defer db.Close()
+	for h := 0; h < 4; h++ {
+		ensure.Nil(t, db.Append(at(time.Duration(h)*time.Hour), map[string]float64{"a": float64(h)}))
+	}
+	ensure.Nil(t, db.Compact(at(4*time.Hour)))
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{
+		{T: ms(2 * time.Hour), V: 2},
+		{T: ms(3 * time.Hour), V: 3},
+	})
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(segments), 2)
+}
+
+func TestSeal(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	opts := tsdb.Options{SegmentDuration: time.Hour}
+	db, err := tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	var expected []tsdb.Point
+	for s := 0; s < 600; s += 10 {
+		d := time.Duration(s) * time.Second
+		ensure.Nil(t, db.Append(at(d), map[string]float64{"a": 1, "b": float64(s)}))
+		expected = append(expected, tsdb.Point{T: ms(d), V: float64(s)})
+	}
+	ensure.Nil(t, db.Append(at(90*time.Minute), map[string]float64{"b": 1}))
+	glob := func(pattern string) []string {
+		names, err := filepath.Glob(filepath.Join(dir, pattern))
+		ensure.Nil(t, err)
+		return names
+	}
+	raw := glob("raw-*.seg")
+	ensure.DeepEqual(t, len(raw), 2)
+	b, err := os.ReadFile(raw[0])
+	ensure.Nil(t, err)
+
+	// Only the window which has passed is sealed, as compressed blocks.
+	ensure.Nil(t, db.Compact(at(time.Hour)))
+	ensure.DeepEqual(t, len(glob("raw-*.seg")), 1)
+	sealed := glob("sealed-*.seg")
+	ensure.DeepEqual(t, len(sealed), 1)
+	info, err := os.Stat(sealed[0])
+	ensure.Nil(t, err)
+	ensure.True(t, info.Size()*4 < int64(len(b)), info.Size(), len(b))
+
+	ensure.DeepEqual(t, query(t, db, "b"), append(expected, tsdb.Point{T: ms(90 * time.Minute), V: 1}))
+	ensure.DeepEqual(t, len(query(t, db, "a")), 60)
+	ensure.Err(t, db.Append(at(time.Minute), map[string]float64{"a": 1}), regexp.MustCompile("already been sealed"))
+	ensure.Nil(t, db.Close())
+
+	// A crash after the rename leaves the raw segment next to the sealed one.
+	ensure.Nil(t, os.WriteFile(raw[0], b, 0644))
+	db, err = tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.DeepEqual(t, db.Keys(), []string{"a", "b"})
+	ensure.DeepEqual(t, len(query(t, db, "b")), 61)
+	_, err = os.Stat(raw[0])
+	ensure.True(t, os.IsNotExist(err))
+}
+
+func TestRollup(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	opts := tsdb.Options{
+		SegmentDuration: time.Hour,
+		RollupAfter:     time.Hour,
+		RollupInterval:  10 * time.Minute,
+	}
+	db, err := tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	for m := 0; m < 20; m += 5 {
+		ensure.Nil(t, db.Append(at(time.Duration(m)*time.Minute), map[string]float64{"a": float64(m)}))
+	}
+	ensure.Nil(t, db.Append(at(90*time.Minute), map[string]float64{"a": 100}))
+	ensure.Nil(t, db.Compact(at(2*time.Hour)))
+
+	expected := []tsdb.Point{
+		{T: ms(0), V: 2.5},
+		{T: ms(10 * time.Minute), V: 12.5},
+		{T: ms(90 * time.Minute), V: 100},
+	}
+	ensure.DeepEqual(t, query(t, db, "a"), expected)
+	ensure.Nil(t, db.Close())
+
+	// Rollups survive reopening, and appending to a rolled up window fails.
+	db, err = tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.DeepEqual(t, query(t, db, "a"), expected)
+	ensure.NotNil(t, db.Append(at(time.Minute), map[string]float64{"a": 1}))
+}
+
+func TestRollupType(t *testing.T) {
+	t.Parallel()
+	db, err := tsdb.Open(t.TempDir(), tsdb.Options{
+		SegmentDuration: time.Hour,
+		RollupAfter:     time.Hour,
+		RollupInterval:  10 * time.Minute,
+		RollupType: func(key string) stats.Type {
+			if key == "requests" {
+				return stats.AggregateSum
+			}
+			return stats.AggregateAvg
+		},
+	})
+	ensure.Nil(t, err)
+	defer db.Close()
+	for m := 0; m < 10; m += 5 {
+		ensure.Nil(t, db.Append(at(time.Duration(m)*time.Minute), map[string]float64{
+			"requests": 3,
+			"load":     float64(m),
+		}))
+	}
+	ensure.Nil(t, db.Compact(at(2*time.Hour)))
+	ensure.DeepEqual(t, query(t, db, "requests"), []tsdb.Point{{T: ms(0), V: 6}})
+	ensure.DeepEqual(t, query(t, db, "load"), []tsdb.Point{{T: ms(0), V: 2.5}})
+}
+
+// Leftovers from a crash during rollup are cleaned up when opening.
+func TestRollupCrashRecovery(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	opts := tsdb.Options{SegmentDuration: time.Hour, RollupAfter: time.Hour}
+	db, err := tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	ensure.Nil(t, db.Append(at(0), map[string]float64{"a": 1}))
+	ensure.Nil(t, db.Close())
+	raw, err := filepath.Glob(filepath.Join(dir, "raw-*.seg"))
+	ensure.Nil(t, err)
+	b, err := os.ReadFile(raw[0])
+	ensure.Nil(t, err)
+
+	// Crash before the rename leaves a temporary file.
+	ensure.Nil(t, os.WriteFile(filepath.Join(dir, "rollup-1.seg.tmp"), []byte("partial"), 0644))
+	db, err = tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{{T: ms(0), V: 1}})
+	ensure.Nil(t, db.Compact(at(3*time.Hour)))
+	ensure.Nil(t, db.Close())
+	tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, len(tmp), 0)
+
+	// Crash after the rename leaves the raw segment next to its rollup.
+	ensure.Nil(t, os.WriteFile(raw[0], b, 0644))
+	db, err = tsdb.Open(dir, opts)
+	ensure.Nil(t, err)
+	defer db.Close()
+	ensure.DeepEqual(t, query(t, db, "a"), []tsdb.Point{{T: ms(0), V: 1}})
+	_, err = os.Stat(raw[0])
+	ensure.True(t, os.IsNotExist(err))
+}